@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//agingBuckets 账龄分段，按就诊后的天数，Max 为 0 表示以上全部
+var agingBuckets = []struct {
+	Title string
+	Max   int
+}{
+	{"0-30天", 30},
+	{"31-90天", 90},
+	{"91-180天", 180},
+	{"180天以上", 0},
+}
+
+//followUps 欠费回访的状态，第一个为默认
+var followUps = []string{"未联系", "已联系", "承诺付款", "无法联系"}
+
+//AgingRow 一个病人的欠费，按账龄分段
+type AgingRow struct {
+	Patient *Patient
+	Name    string
+	Phone   string
+	Total   Money
+	Days0   Money
+	Days31  Money
+	Days91  Money
+	Days181 Money
+	//Oldest 最早一笔欠费的就诊时间
+	Oldest time.Time
+	Visits int
+	//以下字段从档案复制，给表格显示
+	FollowUp   string
+	FollowNote string
+	FollowedAt time.Time
+}
+
+//agingBucket 欠费所在的账龄分段
+func agingBucket(days int) int {
+	for i, b := range agingBuckets {
+		if b.Max == 0 || days <= b.Max {
+			return i
+		}
+	}
+	return len(agingBuckets) - 1
+}
+
+func (r *AgingRow) add(bucket int, owed Money) {
+	switch bucket {
+	case 0:
+		r.Days0 += owed
+	case 1:
+		r.Days31 += owed
+	case 2:
+		r.Days91 += owed
+	default:
+		r.Days181 += owed
+	}
+	r.Total += owed
+}
+
+//aging 有欠费的病人，按欠费金额从多到少排列，金额相同的欠得久的在前；需持有读锁
+func (m *FooModel) aging(now time.Time) []*AgingRow {
+	day := today(now)
+	byPatient := map[string]*AgingRow{}
+	var rows []*AgingRow
+	for _, item := range m.items {
+		owed := item.RealFee - item.PaidFee
+		if item.Deleted || owed <= 0 {
+			continue
+		}
+		key := item.PatientID
+		if key == "" {
+			key = patientKey(item.Name, item.Phone)
+		}
+		r, ok := byPatient[key]
+		if !ok {
+			r = &AgingRow{Name: item.Name, Phone: item.Phone, FollowUp: followUps[0]}
+			if p, ok := m.patients[item.PatientID]; ok {
+				r.Patient = p
+				r.Name, r.Phone = p.Name, p.Phone
+				r.setFollowUp(p)
+			}
+			byPatient[key] = r
+			rows = append(rows, r)
+		}
+		days := int(math.Round(day.Sub(today(item.Create)).Hours() / 24))
+		r.add(agingBucket(days), owed)
+		if r.Oldest.IsZero() || item.Create.Before(r.Oldest) {
+			r.Oldest = item.Create
+		}
+		r.Visits++
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Total != rows[j].Total {
+			return rows[i].Total > rows[j].Total
+		}
+		return rows[i].Oldest.Before(rows[j].Oldest)
+	})
+	return rows
+}
+
+func (r *AgingRow) setFollowUp(p *Patient) {
+	r.FollowUp, r.FollowNote, r.FollowedAt = p.FollowUp, p.FollowNote, p.FollowedAt
+	if r.FollowUp == "" {
+		r.FollowUp = followUps[0]
+	}
+}
+
+//followUp 记录一次欠费回访，失败时档案不变
+func (m *FooModel) followUp(store Store, r *AgingRow, status, note string, at time.Time) error {
+	p := r.Patient
+	before := *p
+	p.FollowUp, p.FollowNote, p.FollowedAt = status, note, at
+	if err := store.UpdatePatient(p); err != nil {
+		*p = before
+		return err
+	}
+	r.setFollowUp(p)
+	return nil
+}
+
+//agingHeader、agingRecords 导出的催款名单
+func agingHeader() []string {
+	header := []string{"姓名", "电话", "欠费合计"}
+	for _, b := range agingBuckets {
+		header = append(header, b.Title)
+	}
+	return append(header, "最早欠费", "欠费次数", "回访状态", "回访备注", "回访时间")
+}
+
+func agingRecords(rows []*AgingRow) [][]string {
+	records := make([][]string, 0, len(rows))
+	for _, r := range rows {
+		records = append(records, []string{
+			r.Name, r.Phone, r.Total.String(),
+			r.Days0.String(), r.Days31.String(), r.Days91.String(), r.Days181.String(),
+			r.Oldest.Format("2006-01-02"), strconv.Itoa(r.Visits),
+			r.FollowUp, r.FollowNote, formatTime(r.FollowedAt),
+		})
+	}
+	return records
+}
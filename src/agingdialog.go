@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+//AgingDialog 应收账款账龄：有欠费的病人按账龄分段列出，可以记录电话回访并导出催款名单
+func AgingDialog(owner walk.Form) (int, error) {
+	var dlg *walk.Dialog
+	var tv *walk.TableView
+	var statusCB *walk.ComboBox
+	var noteLE *walk.LineEdit
+	rwLock.RLock()
+	rows := model.aging(time.Now())
+	rwLock.RUnlock()
+
+	summary := func() string {
+		var total Money
+		sums := make([]Money, len(agingBuckets))
+		for _, r := range rows {
+			total += r.Total
+			sums[0] += r.Days0
+			sums[1] += r.Days31
+			sums[2] += r.Days91
+			sums[3] += r.Days181
+		}
+		parts := []string{fmt.Sprintf("共 %d 人，欠费 %s 元", len(rows), total)}
+		for i, b := range agingBuckets {
+			parts = append(parts, b.Title+" "+sums[i].String())
+		}
+		return strings.Join(parts, "    ")
+	}
+
+	//current 选中行的回访填入下方
+	current := func() {
+		index := tv.CurrentIndex()
+		if index < 0 {
+			return
+		}
+		r := rows[index]
+		for i, s := range followUps {
+			if s == r.FollowUp {
+				_ = statusCB.SetCurrentIndex(i)
+			}
+		}
+		_ = noteLE.SetText(r.FollowNote)
+	}
+
+	return Dialog{
+		AssignTo: &dlg,
+		Title:    "应收账款账龄",
+		MinSize:  Size{Width: 1000, Height: 500},
+		Layout:   VBox{},
+		Children: []Widget{
+			Label{
+				Text: summary(),
+				Font: labelFont,
+			},
+			TableView{
+				AssignTo:         &tv,
+				ColumnsOrderable: true,
+				Columns: []TableViewColumn{
+					{Name: "Name", Title: "姓名", Width: 60},
+					{Name: "Phone", Title: "电话", Width: 100},
+					{Name: "Total", Title: "欠费合计", Alignment: AlignFar, Width: 80},
+					{Name: "Days0", Title: agingBuckets[0].Title, Alignment: AlignFar, Width: 75},
+					{Name: "Days31", Title: agingBuckets[1].Title, Alignment: AlignFar, Width: 75},
+					{Name: "Days91", Title: agingBuckets[2].Title, Alignment: AlignFar, Width: 75},
+					{Name: "Days181", Title: agingBuckets[3].Title, Alignment: AlignFar, Width: 75},
+					{Name: "Oldest", Title: "最早欠费", Format: "2006-01-02", Width: 90},
+					{Name: "Visits", Title: "次数", Alignment: AlignFar, Width: 40},
+					{Name: "FollowUp", Title: "回访状态", Width: 70},
+					{Name: "FollowNote", Title: "回访备注", Width: 150},
+					{Name: "FollowedAt", Title: "回访时间", Format: "2006-01-02 15:04", Width: 120},
+				},
+				Model:                 rows,
+				OnCurrentIndexChanged: current,
+				OnItemActivated: func() {
+					if index := tv.CurrentIndex(); index >= 0 && rows[index].Patient != nil {
+						_, _ = HistoryDialog(dlg, rows[index].Patient.ID)
+					}
+				},
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					Label{Text: "回访:"},
+					ComboBox{
+						AssignTo:     &statusCB,
+						Model:        followUps,
+						CurrentIndex: 0,
+					},
+					Label{Text: "备注:"},
+					LineEdit{
+						AssignTo:    &noteLE,
+						ToolTipText: "如：答应月底来付、电话停机",
+						MinSize:     Size{Width: 250},
+					},
+					PushButton{
+						Text: "记录回访",
+						OnClicked: func() {
+							index := tv.CurrentIndex()
+							if index < 0 {
+								return
+							}
+							r := rows[index]
+							if r.Patient == nil {
+								walk.MsgBox(dlg, "提示", "这条记录还没有归档到病人档案", walk.MsgBoxIconInformation)
+								return
+							}
+							err := model.commit(func(store Store) error {
+								return model.followUp(store, r, statusCB.Text(), strings.TrimSpace(noteLE.Text()), time.Now())
+							})
+							if err != nil {
+								walk.MsgBox(dlg, "错误", "保存失败："+err.Error(), walk.MsgBoxIconError)
+								return
+							}
+							_ = tv.SetModel(rows)
+							_ = tv.SetCurrentIndex(index)
+						},
+					},
+					HSpacer{},
+					PushButton{
+						Text: "导出",
+						OnClicked: func() {
+							name := "应收账款" + time.Now().Format("20060102") + ".csv"
+							exportTo(dlg, "导出催款名单", name, agingHeader(), agingRecords(rows))
+						},
+					},
+					PushButton{
+						Text:      "关闭",
+						OnClicked: func() { dlg.Accept() },
+					},
+				},
+			},
+		},
+	}.Run(owner)
+}
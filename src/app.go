@@ -5,10 +5,9 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
+	"flag"
 	"fmt"
-	"log"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
@@ -18,6 +17,8 @@ import (
 )
 
 import (
+	"github.com/gaoyangtok/medic/src/fulltext"
+	"github.com/gaoyangtok/medic/src/query"
 	"github.com/lxn/walk"
 	. "github.com/lxn/walk/declarative"
 )
@@ -27,105 +28,23 @@ type Sex string
 type Foo struct {
 	Name      string
 	Phone     string
+	PhoneNorm string
 	Create    time.Time
 	Update    time.Time
 	Diagnosed string
 	Program   string
-	AllFee    float64
-	RealFee   float64
-	PaidFee   float64
+	AllFee    Money
+	RealFee   Money
+	PaidFee   Money
 	Address   string
 	Age       int
 	Sex       Sex
-	Index     int
+	ID        string
+	PatientID string
+	Payments  []*Payment
 	Checked   bool
 	Deleted   bool
-}
-
-const data = "data.csv"
-
-//Write 写入运行配置
-func Write(dabs []*Foo) {
-	//_ = os.Remove(data)
-	f, err := os.OpenFile(data, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
-
-	if err != nil {
-		fmt.Print("写文件失败！")
-	}
-	write := csv.NewWriter(f)
-	records := make([][]string, len(dabs)+1)
-	records[0] = []string{"姓名", "电话", "登记时间", "最新时间", "病例诊断", "治疗方案", "就诊费用", "实收费用", "已付费用", "住址", "性别", "年龄", "是否删除"}
-	for index, foo := range dabs {
-		var del string
-		if foo.Deleted {
-			del = "1"
-		} else {
-			del = "0"
-		}
-		records[index+1] = []string{
-			foo.Name,
-			foo.Phone,
-			foo.Create.Format("2006-01-02 15:04:05"),
-			foo.Update.Format("2006-01-02 15:04:05"),
-			foo.Diagnosed,
-			foo.Program,
-			strconv.FormatFloat(foo.AllFee, 'f', 1, 64),
-			strconv.FormatFloat(foo.RealFee, 'f', 1, 64),
-			strconv.FormatFloat(foo.PaidFee, 'f', 1, 64),
-			foo.Address, string(foo.Sex), strconv.Itoa(foo.Age),
-			del,
-		}
-	}
-	_ = write.WriteAll(records)
-
-	f.Close()
-	return
-}
-
-//Read 读取运行配置
-func Read() []*Foo {
-	file, err := os.Open(data)
-	if err != nil {
-		Write([]*Foo{}) //如果不存在先创建一个空文件
-		file, _ = os.Open(data)
-	}
-	defer file.Close()
-
-	read := csv.NewReader(file)
-
-	records, err := read.ReadAll()
-	dabs := make([]*Foo, len(records)-1)
-	for index := range dabs {
-		record := records[index+1]
-		allFee, _ := strconv.ParseFloat(record[6], 64)
-		realFee, _ := strconv.ParseFloat(record[7], 64)
-		paidFee, _ := strconv.ParseFloat(record[8], 64)
-		create, _ := time.Parse("2006-01-02 15:04:05", record[2])
-		update, _ := time.Parse("2006-01-02 15:04:05", record[3])
-		age, err := strconv.Atoi(record[11])
-		if err != nil {
-			panic(err)
-		}
-		dabs[index] = &Foo{
-			Name:      record[0],
-			Phone:     record[1],
-			Create:    create,
-			Update:    update,
-			Diagnosed: record[4],
-			Program:   record[5],
-			AllFee:    allFee,
-			RealFee:   realFee,
-			PaidFee:   paidFee,
-			Address:   record[9],
-			Sex:       Sex(record[10]),
-			Age:       age,
-			Index:     index,
-			Deleted:   len(record) >= 13 && strings.Compare(record[12], "1") == 0,
-		}
-	}
-
-	file.Close()
-	return dabs
+	DeletedAt time.Time
 }
 
 type FooModel struct {
@@ -134,41 +53,119 @@ type FooModel struct {
 	sortColumn int
 	sortOrder  walk.SortOrder
 	search     *Search
+	store      Store
 	items      []*Foo
 	sItems     []*Foo
-	sum        float64
-	sSum       float64
-	lSum       float64
+	patients   map[string]*Patient
+	byKey      map[string]*Patient
+	changes    map[string][]*Change
+	merges     []*Merge
+	mergedInto map[string]string
+	searcher   searcher
+	watchers   watchers
+	pageSize   int
+	page       int
+	index      *fulltext.Index
+	sum        Money
+	sSum       Money
+	lSum       Money
+	loadErr    error
+	loadErrs   []RowError
 	SumLabel   *walk.Label
 	SSumLabel  *walk.Label
 	LSumLabel  *walk.Label
+	PageLabel  *walk.Label
+	//TotalLabel 当前查询结果的合计
+	TotalLabel *walk.Label
+	PageEdit   *walk.LineEdit
+
+	//closings 按日期的日结，adjustments 对已日结日期的调整
+	closings    map[string]*Closing
+	adjustments []*Adjustment
 }
 
-func NewFooModel() *FooModel {
+func NewFooModel(store Store) *FooModel {
 	m := new(FooModel)
+	m.store = store
 	m.sortColumn = 3
 	m.sortOrder = 0
+	m.pageSize = defaultPageSize
 	rwLock.Lock()
-	m.items = Read()
+	m.loadErrs, m.loadErr = m.load()
+	if m.loadErr == nil && retainDays > 0 {
+		m.loadErr = store.Transact(func(store Store) error {
+			_, err := m.purgeExpired(store, time.Now().AddDate(0, 0, -retainDays))
+			return err
+		})
+	}
 	for _, item := range m.items {
 		if !item.Deleted {
 			m.sItems = append(m.sItems, item)
 		}
 	}
-	m.items = m.sItems[0:]
-	copy(m.items, m.sItems)
 	m.search = new(Search)
 	m.SumLabel = new(walk.Label)
 	m.SSumLabel = new(walk.Label)
 	m.LSumLabel = new(walk.Label)
 	m.refreshTotal()
-	m.search.Start, _ = time.Parse("2006-01-02 15:04:05", "2018-12-01 00:00:00")
+	m.search.Start = firstDay
 	m.search.End = time.Now().Add(time.Hour * 24)
 	m.ResetRows()
 	rwLock.Unlock()
 	return m
 }
 
+//load 从存储读出全部数据，重建病人档案、收款、修改记录、日结和全文索引；需持有写锁。
+//读取失败时保留原来的数据
+func (m *FooModel) load() ([]RowError, error) {
+	ds, errs, err := m.store.Load()
+	if err != nil {
+		return nil, err
+	}
+	m.patients = map[string]*Patient{}
+	m.byKey = map[string]*Patient{}
+	m.index = fulltext.New(textFields...)
+	for _, p := range ds.Patients {
+		m.addPatient(p)
+	}
+	m.attachMerges(ds.Merges)
+	//已删除的记录留在 items 中，作为回收站
+	m.items = ds.Visits
+	//旧数据补齐档案和收款，在一个事务中写入
+	err = m.store.Transact(func(store Store) error {
+		if err := m.linkAll(store, ds.Visits); err != nil {
+			return err
+		}
+		return m.attachPayments(store, ds.Visits, ds.Payments)
+	})
+	m.attachChanges(ds.Changes)
+	m.attachClosings(ds.Closings, ds.Adjustments)
+	m.buildIndex()
+	return errs, err
+}
+
+//loadReport 汇总读取数据时出错的行，最多列出 10 条
+func loadReport(errs []RowError) string {
+	lines := []string{fmt.Sprintf("有 %d 处数据无法识别，已按空值载入：", len(errs))}
+	for i, e := range errs {
+		if i == 10 {
+			lines = append(lines, "……")
+			break
+		}
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+//release 载入时的错误已经提示过，CSV 存储可以合并快照了，见 csvFile.held
+func (m *FooModel) release() {
+	rwLock.Lock()
+	defer rwLock.Unlock()
+	if s, ok := m.store.(*CSVStore); ok {
+		s.release()
+	}
+}
+
 func (m *FooModel) GetSearch() *Search {
 	return m.search
 }
@@ -176,7 +173,7 @@ func (m *FooModel) GetSearch() *Search {
 // Called by the TableView from SetModel and every time the model publishes a
 // RowsReset event.
 func (m *FooModel) RowCount() int {
-	return len(m.sItems)
+	return len(m.pageRows())
 }
 
 func (m *FooModel) Head(foo *Foo) {
@@ -186,7 +183,7 @@ func (m *FooModel) Head(foo *Foo) {
 
 // Called by the TableView when it needs the text to display for a given cell.
 func (m *FooModel) Value(row, col int) interface{} {
-	item := m.sItems[row]
+	item := m.row(row)
 	switch col {
 	case 0:
 		return ""
@@ -223,12 +220,12 @@ func (m *FooModel) Value(row, col int) interface{} {
 
 // Called by the TableView to retrieve if a given row is checked.
 func (m *FooModel) Checked(row int) bool {
-	return m.sItems[row].Checked
+	return m.row(row).Checked
 }
 
 // Called by the TableView when the user toggled the check box of a given row.
 func (m *FooModel) SetChecked(row int, checked bool) error {
-	m.sItems[row].Checked = checked
+	m.row(row).Checked = checked
 	return nil
 }
 
@@ -248,7 +245,7 @@ func (m *FooModel) Sort(col int, order walk.SortOrder) error {
 
 		switch m.sortColumn {
 		case 0:
-			return c(a.Index < b.Index)
+			return c(a.ID < b.ID)
 		case 1:
 			return c(a.Name < b.Name)
 		case 2:
@@ -277,26 +274,48 @@ func (m *FooModel) Sort(col int, order walk.SortOrder) error {
 		panic("unreachable")
 	})
 
+	m.refreshResult()
 	return m.SorterBase.Sort(col, order)
 }
 
+//refreshResult 显示当前查询结果的合计，窗口创建前什么也不做
+func (m *FooModel) refreshResult() {
+	if m.TotalLabel == nil {
+		return
+	}
+	_ = m.TotalLabel.SetText(sumVisits(m.sItems).String())
+}
+
 func (m *FooModel) ResetRows() {
+	m.clampPage()
 	// Notify TableView and other interested parties about the reset.
 	m.PublishRowsReset()
 	_ = m.Sort(m.sortColumn, m.sortOrder)
+	m.refreshPager()
 }
 
-func (m *FooModel) Search() {
-	sItems := []*Foo{}
-	start := m.search.Start.Format("2006-01-02 15:04:05")
-	end := m.search.End.Format("2006-01-02 15:04:05")
-	for _, item := range m.items {
-		create := item.Create.Format("2006-01-02 15:04:05")
-		if strings.Contains(item.Name, m.search.Name) && create > start && create < end && strings.Contains(item.Phone, m.search.Phone) && !item.Deleted {
-			sItems = append(sItems, item)
-		}
+//turnPage 翻到第 page 页，见 setPage
+func (m *FooModel) turnPage(page int) {
+	if m.setPage(page) {
+		m.PublishRowsReset()
+	}
+	m.refreshPager()
+}
+
+//refreshPager 显示页码和总数，窗口创建前什么也不做
+func (m *FooModel) refreshPager() {
+	if m.PageLabel == nil || m.PageEdit == nil {
+		return
 	}
-	m.sItems = sItems
+	_ = m.PageLabel.SetText(fmt.Sprintf("/ %d 页，共 %d 条", m.pageCount(), len(m.sItems)))
+	_ = m.PageEdit.SetText(strconv.Itoa(m.page + 1))
+}
+
+//Search 立即按当前条件查询，边输入边查询见 searchAsync
+func (m *FooModel) Search() {
+	m.cancelSearch()
+	m.sItems, _ = filterVisits(context.Background(), m.items, m.search)
+	m.page = 0
 	m.ResetRows()
 }
 
@@ -308,10 +327,16 @@ func (m *FooModel) refreshTotal() {
 		if item.Deleted {
 			continue
 		}
-		m.sum = m.sum + item.PaidFee
+		//收入按实际收款时间计入
+		for _, p := range item.Payments {
+			if p.Voided {
+				continue
+			}
+			m.sum = m.sum + p.Amount
 
-		if item.Create.Year() == time.Now().Year() && item.Create.Month() == time.Now().Month() {
-			m.sSum = m.sSum + item.PaidFee
+			if p.At.Year() == time.Now().Year() && p.At.Month() == time.Now().Month() {
+				m.sSum = m.sSum + p.Amount
+			}
 		}
 		if item.RealFee > item.PaidFee {
 			m.lSum = m.lSum + item.RealFee - item.PaidFee
@@ -319,15 +344,29 @@ func (m *FooModel) refreshTotal() {
 	}
 }
 
-func (m *FooModel) save() {
+//commit 在写锁内修改存储，并刷新合计。fn 在一个事务中执行，失败时存储中的修改全部撤销，
+//内存中改了一半的记录按存储重新载入，查询结果随之刷新
+func (m *FooModel) commit(fn func(store Store) error) error {
 	rwLock.Lock()
-	Write(m.items)
+	err := m.store.Transact(fn)
+	if err != nil {
+		if _, lerr := m.load(); lerr != nil {
+			err = fmt.Errorf("%v；重新载入数据也失败：%v", err, lerr)
+		}
+		//进行中的后台查询引用的是旧的记录
+		m.cancelSearch()
+		m.sItems, _ = filterVisits(context.Background(), m.items, m.search)
+		m.ResetRows()
+	}
 
 	m.refreshTotal()
-	m.LSumLabel.SetText(strconv.FormatFloat(model.lSum, 'f', 1, 64) + " 元")
-	m.SSumLabel.SetText(strconv.FormatFloat(model.sSum, 'f', 1, 64) + " 元")
-	m.SumLabel.SetText(strconv.FormatFloat(model.sum, 'f', 1, 64) + " 元")
+	m.LSumLabel.SetText(model.lSum.String() + " 元")
+	m.SSumLabel.SetText(model.sSum.String() + " 元")
+	m.SumLabel.SetText(model.sum.String() + " 元")
+	m.refreshResult()
 	rwLock.Unlock()
+	m.watchers.notify()
+	return err
 }
 
 var (
@@ -346,7 +385,7 @@ const (
 	SM_CYSCREEN = 1
 )
 
-var model = NewFooModel()
+var model *FooModel
 var rwLock *sync.RWMutex = new(sync.RWMutex)
 
 type Search struct {
@@ -354,6 +393,9 @@ type Search struct {
 	Phone string
 	Start time.Time
 	End   time.Time
+	//Query 查询语言写的条件，见 query 包
+	Query string
+	node  query.Node
 }
 type MyDialog struct {
 	*walk.Dialog
@@ -365,10 +407,56 @@ var rgbs [7]walk.Color
 
 
 func main() {
+	kind := flag.String("store", storeCSV, "存储类型：csv 或 sqlite")
+	path := flag.String("data", "", "数据文件，默认为 "+data+" 或 "+sqliteData)
+	migrate := flag.Bool("migrate", false, "把 "+data+" 导入 "+sqliteData+" 后退出")
+	flag.StringVar(&operator, "user", operator, "操作人，记入修改记录")
+	flag.IntVar(&retainDays, "retain", 0, "回收站中的记录保留天数，0 表示一直保留")
+	flag.Parse()
+
+	if *migrate {
+		if n, err := migrateCSV(data, sqliteData); err != nil {
+			walk.MsgBox(nil, "错误", "导入失败："+err.Error(), walk.MsgBoxIconError)
+		} else {
+			walk.MsgBox(nil, "完成", fmt.Sprintf("已导入 %d 条记录到 %s", n, sqliteData), walk.MsgBoxIconInformation)
+		}
+		return
+	}
+
+	if *path == "" {
+		*path = data
+		if *kind == storeSQLite {
+			*path = sqliteData
+		}
+	}
+	store, err := OpenStore(*kind, *path)
+	if err != nil {
+		walk.MsgBox(nil, "错误", "打开数据失败："+err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	defer store.Close()
+	model = NewFooModel(store)
+
 	walk.FocusEffect, _ = walk.NewBorderGlowEffect(walk.RGB(0, 63, 255))
 	walk.InteractionEffect, _ = walk.NewDropShadowEffect(walk.RGB(63, 63, 63))
 	walk.ValidationErrorEffect, _ = walk.NewBorderGlowEffect(walk.RGB(255, 0, 0))
 
+	if model.loadErr != nil {
+		walk.MsgBox(nil, "错误", "读取数据失败："+model.loadErr.Error(), walk.MsgBoxIconError)
+		return
+	}
+	if len(model.loadErrs) > 0 {
+		walk.MsgBox(nil, "警告", loadReport(model.loadErrs), walk.MsgBoxIconWarning)
+		model.release()
+	}
+	if err := openSettings(); err != nil {
+		walk.MsgBox(nil, "警告", "读取设置失败："+err.Error(), walk.MsgBoxIconWarning)
+	}
+	restoreSearch(model.search)
+	model.setPageSize(loadPageSize())
+	model.Search()
+	presets := loadPresets()
+
 	rgbs[0]=walk.RGB(255,127,36)
 	rgbs[1]=walk.RGB(240,128,128)
 	rgbs[2]=walk.RGB(205,173,0)
@@ -381,7 +469,7 @@ func main() {
 	height := GetSystemMetrics(SM_CYSCREEN)
 	//boldFont, _ := walk.NewFont("Segoe UI", 9, walk.FontBold)
 
-	inputWidth := (with*80/100 - 30*3 - 60*2 - 150) / 4
+	inputWidth := (with*80/100 - 30*4 - 60*11 - 100 - 40) / 6
 
 	goodIcon, _ := walk.Resources.Icon("img/check.ico")
 	//badIcon, _ := walk.Resources.Icon("img/stop.ico")
@@ -404,8 +492,37 @@ func main() {
 
 	var tv *walk.TableView
 	var db *walk.DataBinder
-	var queryPB, addPB, delPB, staPB *walk.PushButton
+	var presetCB *walk.ComboBox
+	var queryPB, presetPB, addPB, delPB, staPB, hisPB, trashPB, textPB, agingPB, dupPB, closePB *walk.PushButton
 	var mw *walk.MainWindow
+	runQuery := func() {
+		if err := db.Submit(); err == nil {
+			if err := model.search.parse(); err != nil {
+				walk.MsgBox(mw, "查询条件有误", err.Error(), walk.MsgBoxIconWarning)
+				return
+			}
+			rememberSearch(model.search)
+			model.Search()
+		}
+	}
+	var pageSizeCB *walk.ComboBox
+	//jumpPage 翻到页码框中的页
+	jumpPage := func() {
+		page, err := strconv.Atoi(strings.TrimSpace(model.PageEdit.Text()))
+		if err != nil {
+			model.refreshPager()
+			return
+		}
+		model.turnPage(page - 1)
+	}
+	//liveQuery 输入停顿后自动提交条件，在后台查询；条件写错时不提示，保持上次的结果
+	liveQuery := func() {
+		if err := model.search.parse(); err != nil {
+			return
+		}
+		rememberSearch(model.search)
+		model.searchAsync(*model.search, mw.Synchronize)
+	}
 	_, _ = MainWindow{
 		AssignTo:   &mw,
 		Size:       Size{Width: with * 90 / 100, Height: height - 150},
@@ -416,12 +533,15 @@ func main() {
 		Children: []Widget{
 			Composite{
 				DataBinder: DataBinder{
-					AssignTo:       &db,
-					Name:           "search",
-					DataSource:     model.GetSearch(),
-					ErrorPresenter: ToolTipErrorPresenter{},
+					AssignTo:        &db,
+					Name:            "search",
+					DataSource:      model.GetSearch(),
+					ErrorPresenter:  ToolTipErrorPresenter{},
+					AutoSubmit:      true,
+					AutoSubmitDelay: searchDelay,
+					OnSubmitted:     liveQuery,
 				},
-				Layout:  Grid{Columns: 12},
+				Layout:  Grid{Columns: 22},
 				MaxSize: Size{Width: with * 80 / 100, Height: 40},
 				MinSize: Size{Width: with * 80 / 100, Height: 40},
 				Children: []Widget{
@@ -469,6 +589,23 @@ func main() {
 						MaxSize: Size{Width: inputWidth, Height: 20},
 						MinSize: Size{Width: inputWidth, Height: 20},
 					},
+					Label{
+						Text:    "条件:",
+						Font:    labelFont,
+						MaxSize: Size{Width: 30},
+						MinSize: Size{Width: 30},
+					},
+					LineEdit{
+						Text:        Bind("Query"),
+						ToolTipText: "如：diag:腰痛 sex:女 age:30..40 owed>0 fee>=200 created:2023-01..2023-06",
+						MaxSize:     Size{Width: inputWidth * 2, Height: 20},
+						MinSize:     Size{Width: inputWidth * 2, Height: 20},
+						OnKeyPress: func(key walk.Key) {
+							if key == walk.KeyReturn {
+								runQuery()
+							}
+						},
+					},
 					PushButton{
 						AssignTo: &queryPB,
 						Text:     "查询",
@@ -476,9 +613,41 @@ func main() {
 						MaxSize:  Size{Width: 60, Height: 20},
 						MinSize:  Size{Width: 60, Height: 20},
 						OnClicked: func() {
-							if err := db.Submit(); err == nil {
-								model.Search()
+							runQuery()
+						},
+					},
+					ComboBox{
+						AssignTo:    &presetCB,
+						Model:       presetNames(presets),
+						ToolTipText: "常用条件",
+						MaxSize:     Size{Width: 100, Height: 20},
+						MinSize:     Size{Width: 100, Height: 20},
+						OnCurrentIndexChanged: func() {
+							index := presetCB.CurrentIndex()
+							if index < 0 || index >= len(presets) {
+								return
 							}
+							if err := presets[index].apply(model.search, time.Now()); err != nil {
+								walk.MsgBox(mw, "查询条件有误", err.Error(), walk.MsgBoxIconWarning)
+								return
+							}
+							_ = db.Reset()
+							rememberSearch(model.search)
+							model.Search()
+						},
+					},
+					PushButton{
+						AssignTo: &presetPB,
+						Text:     "存条件",
+						Font:     labelFont,
+						MaxSize:  Size{Width: 60, Height: 20},
+						MinSize:  Size{Width: 60, Height: 20},
+						OnClicked: func() {
+							if err := db.Submit(); err != nil {
+								return
+							}
+							presets = PresetDialog(mw, presets)
+							_ = presetCB.SetModel(presetNames(presets))
 						},
 					},
 					PushButton{
@@ -510,17 +679,115 @@ func main() {
 						MaxSize:  Size{Width: 60, Height: 20},
 						MinSize:  Size{Width: 60, Height: 20},
 						OnClicked: func() {
-							rwLock.Lock()
+							//已日结的就诊要说明删除的原因
+							var locked int
+							rwLock.RLock()
 							for _, item := range model.items {
-								item.Deleted = item.Checked
+								if item.Checked && !item.Deleted && model.closingOf(item.Create) != nil {
+									locked++
+								}
+							}
+							rwLock.RUnlock()
+							var reason string
+							if locked > 0 {
+								var ok bool
+								if reason, ok = adjustReason(mw, fmt.Sprintf("选中的记录中有 %d 条登记在已日结的日期，删除将记为调整。", locked)); !ok {
+									return
+								}
+							}
+							err := model.commit(func(store Store) error {
+								for _, item := range model.items {
+									if item.Checked && !item.Deleted {
+										before := *item
+										if err := store.Delete(item); err != nil {
+											return err
+										}
+										item.Checked = false
+										model.reindex(item)
+										if err := model.audit(store, &before, item); err != nil {
+											return err
+										}
+										if c := model.closingOf(item.Create); c != nil {
+											if err := model.adjust(store, c, adjustDelete, reason, &before, item); err != nil {
+												return err
+											}
+										}
+									}
+								}
+								return nil
+							})
+							if err != nil {
+								walk.MsgBox(mw, "错误", "保存失败："+err.Error(), walk.MsgBoxIconError)
 							}
-							rwLock.Unlock()
 
 							if err := db.Submit(); err == nil {
 								model.Search()
 							}
-
-							model.save()
+						},
+					},
+					PushButton{
+						AssignTo: &hisPB,
+						Text:     "病史",
+						Font:     labelFont,
+						MaxSize:  Size{Width: 60, Height: 20},
+						MinSize:  Size{Width: 60, Height: 20},
+						OnClicked: func() {
+							if index := tv.CurrentIndex(); index >= 0 {
+								_, _ = HistoryDialog(mw, model.row(index).PatientID)
+							}
+						},
+					},
+					PushButton{
+						AssignTo: &agingPB,
+						Text:     "应收",
+						Font:     labelFont,
+						MaxSize:  Size{Width: 60, Height: 20},
+						MinSize:  Size{Width: 60, Height: 20},
+						OnClicked: func() {
+							_, _ = AgingDialog(mw)
+						},
+					},
+					PushButton{
+						AssignTo: &closePB,
+						Text:     "日结",
+						Font:     labelFont,
+						MaxSize:  Size{Width: 60, Height: 20},
+						MinSize:  Size{Width: 60, Height: 20},
+						OnClicked: func() {
+							_, _ = ClosingDialog(mw)
+						},
+					},
+					PushButton{
+						AssignTo: &textPB,
+						Text:     "全文",
+						Font:     labelFont,
+						MaxSize:  Size{Width: 60, Height: 20},
+						MinSize:  Size{Width: 60, Height: 20},
+						OnClicked: func() {
+							_, _ = FullTextDialog(mw)
+						},
+					},
+					PushButton{
+						AssignTo: &dupPB,
+						Text:     "查重",
+						Font:     labelFont,
+						MaxSize:  Size{Width: 60, Height: 20},
+						MinSize:  Size{Width: 60, Height: 20},
+						OnClicked: func() {
+							_, _ = DuplicatesDialog(mw)
+						},
+					},
+					PushButton{
+						AssignTo: &trashPB,
+						Text:     "回收站",
+						Font:     labelFont,
+						MaxSize:  Size{Width: 60, Height: 20},
+						MinSize:  Size{Width: 60, Height: 20},
+						OnClicked: func() {
+							_, _ = TrashDialog(mw)
+							if err := db.Submit(); err == nil {
+								model.Search()
+							}
 						},
 					},
 				},
@@ -550,7 +817,7 @@ func main() {
 					{Title: "住址", Width: 130},
 				},
 				StyleCell: func(style *walk.CellStyle) {
-					item := model.sItems[style.Row()]
+					item := model.row(style.Row())
 					if item.Checked {
 						style.BackgroundColor = walk.RGB(143, 199, 239)
 					}
@@ -566,13 +833,13 @@ func main() {
 				},
 				Model: model,
 				OnItemActivated: func() {
-					if cmd, err := AddDialog(mw, model.sItems[tv.SelectedIndexes()[0]]); err == nil && cmd == walk.DlgCmdOK {
+					if cmd, err := AddDialog(mw, model.row(tv.SelectedIndexes()[0])); err == nil && cmd == walk.DlgCmdOK {
 						model.ResetRows()
 					}
 				},
 			},
 			Composite{
-				Layout: Grid{Columns: 13},
+				Layout: Grid{Columns: 14},
 				Children: []Widget{
 					Label{
 						Text:    "合计收入:",
@@ -581,7 +848,7 @@ func main() {
 						MinSize: Size{Width: 60},
 					},
 					Label{
-						Text:     strconv.FormatFloat(model.sum, 'f', 1, 64) + " 元",
+						Text:     model.sum.String() + " 元",
 						AssignTo: &model.SumLabel,
 						Font:     labelFont,
 						MaxSize:  Size{Width: 100},
@@ -594,7 +861,7 @@ func main() {
 						MinSize: Size{Width: 60},
 					},
 					Label{
-						Text:     strconv.FormatFloat(model.sSum, 'f', 1, 64) + " 元",
+						Text:     model.sSum.String() + " 元",
 						AssignTo: &model.SSumLabel,
 						Font:     labelFont,
 						MaxSize:  Size{Width: 100},
@@ -607,356 +874,84 @@ func main() {
 						MinSize: Size{Width: 60},
 					},
 					Label{
-						Text:     strconv.FormatFloat(model.lSum, 'f', 1, 64) + " 元",
+						Text:     model.lSum.String() + " 元",
 						AssignTo: &model.LSumLabel,
 						Font:     labelFont,
 						MaxSize:  Size{Width: 100},
 						MinSize:  Size{Width: 100},
 					},
-					/*PushButton{
+					PushButton{
 						Text: "首页",
 						OnClicked: func() {
-
+							model.turnPage(0)
 						},
 					},
 					PushButton{
 						Text: "上一页",
 						OnClicked: func() {
+							model.turnPage(model.page - 1)
 						},
 					},
 					LineEdit{
-						Text:    Bind("CurPage"),
-						MaxSize: Size{Width: inputWidth, Height: 20},
-						MinSize: Size{Width: inputWidth, Height: 20},
+						AssignTo: &model.PageEdit,
+						Text:     "1",
+						MaxSize:  Size{Width: 40, Height: 20},
+						MinSize:  Size{Width: 40, Height: 20},
+						OnKeyPress: func(key walk.Key) {
+							if key == walk.KeyReturn {
+								jumpPage()
+							}
+						},
+					},
+					Label{
+						AssignTo: &model.PageLabel,
+						Font:     labelFont,
 					},
 					PushButton{
-						Text: "跳转",
-						OnClicked: func() {
-
-						},
+						Text:      "跳转",
+						OnClicked: jumpPage,
 					},
 					PushButton{
 						Text: "下一页",
 						OnClicked: func() {
-
+							model.turnPage(model.page + 1)
 						},
 					},
 					PushButton{
 						Text: "末页",
 						OnClicked: func() {
-
+							model.turnPage(model.pageCount() - 1)
 						},
-					},*/
+					},
+					Label{
+						AssignTo:   &model.TotalLabel,
+						Text:       sumVisits(model.sItems).String(),
+						Font:       labelFont,
+						ColumnSpan: 14,
+					},
+					ComboBox{
+						AssignTo:     &pageSizeCB,
+						Model:        pageSizeTitles(),
+						CurrentIndex: pageSizeIndex(model.pageSize),
+						OnCurrentIndexChanged: func() {
+							index := pageSizeCB.CurrentIndex()
+							if index < 0 || pageSizes[index] == model.pageSize {
+								return
+							}
+							model.setPageSize(pageSizes[index])
+							savePageSize(model.pageSize)
+							model.ResetRows()
+						},
+					},
 				},
 			},
 		},
 	}.Run()
-}
-
-var width,offset,total = 55, 200, 200
-
-func OpenStatic() {
-	dmw := new(MyMainWindow)
-
-	mc,yc ,_, _ := GetMonthSum()
-
-	var height = len(yc)*30+100
-	if (height<350){
-		height = 350
-	}
-	var leng=6
-	if len(mc) > leng{
-		leng=len(mc)
-	}
-	if _, err := (MainWindow{
-		AssignTo: &dmw.MainWindow,
-		Title:    "收入统计",
-		MinSize:  Size{leng * width+offset, 350},
-		Size:     Size{leng * width+offset, height},
-		Layout:   VBox{MarginsZero: true},
-		Children: []Widget{
-			CustomWidget{
-				AssignTo:            &dmw.paintWidget,
-				ClearsBackground:    true,
-				InvalidatesOnResize: true,
-				Paint:               dmw.drawStuff,
-			},
-		},
-	}).Run(); err != nil {
-		log.Fatal(err)
+	if err := settings.Save(); err != nil {
+		walk.MsgBox(nil, "错误", "保存设置失败："+err.Error(), walk.MsgBoxIconError)
 	}
 }
 
-type MyMainWindow struct {
-	*walk.MainWindow
-	paintWidget *walk.CustomWidget
-}
-
-type MonthCount struct {
-	Month int
-	Money float64
-}
-
-type YearCount struct {
-	Year int
-	Money float64
-}
-
-func GetMonthSum() ([]MonthCount, []YearCount, float64, float64) {
-
-	var months = map[int]float64{}
-	var years = map[int]float64{}
-	var mons []int
-
-	var mc []MonthCount
-	var yc []YearCount
-
-	start := model.search.Start.Year()*12 + int(model.search.Start.Month())
-	end := model.search.End.Year()*12 + int(model.search.End.Month())
-
-	rwLock.RLock()
-	for _, item := range model.items {
-		year:=item.Create.Year()
-
-		_time := year*12 + int(item.Create.Month())
-		if item.Deleted {
-			continue
-		}
-
-		ym,ok := years[year]
-		if !ok {
-			ym = 0
-			years[year] = 0
-		}
-		years[year] = item.PaidFee + ym
-
-		if _time < start || _time > end {
-			continue
-		}
-
-		mon := year*12 + int(item.Create.Month())
-		money, ok := months[mon]
-		if !ok {
-			money = 0
-			months[mon] = 0
-			mons = append(mons, mon)
-		}
-		months[mon] = item.PaidFee + money
-	}
-	rwLock.RUnlock()
-	sort.Ints(mons)
-
-	var max = 0.0
-	var min = 10000000.0
-
-	keys := make([]int, 0, len(years))
-	for k := range years {
-		keys = append(keys, k)
-	}
-
-	sort.Ints(keys)
-	for _,year := range keys{
-		money := years[year]
-		yc = append(yc,YearCount{
-			Money: money,
-			Year: year,
-		})
-	}
-
-	// var yc,cur_year = 0,0
-	for _, mon := range mons {
-		money := months[mon]
-
-		// year:=(mon-1)/12
-		// if (year!=cur_year){
-		// 	yc++;
-		// 	cur_year = year
-		// }
-
-		mc = append(mc, MonthCount{
-			Money: money,
-			Month: mon,
-		})
-
-		if max < money {
-			max = money
-		}
-		if min > money {
-			min = money
-		}
-	}
-	return mc, yc , max, min
-}
-
-func DrawMonth(canvas *walk.Canvas,item MonthCount,i int,max float64,sum int)  error{
-	mon := (item.Month-1)%12 + 1
-
-	height := int(item.Money/max*float64(sum-50))
-
-	ellipseBrush, err :=  walk.NewSolidColorBrush(rgbs[((item.Month-1)/12)%len(rgbs)])
-	if err != nil {
-		return err
-	}
-	defer ellipseBrush.Dispose()
-
-	if err := canvas.FillRectangle(ellipseBrush, walk.Rectangle{
-		X:      offset + i*width,
-		Y:      sum - height,
-		Width:  20,
-		Height: height,
-	}); err != nil {
-		return err
-	}
-
-	if err := canvas.DrawText(fmt.Sprint(mon, "月"), font, walk.RGB(0, 0, 0), walk.Rectangle{
-		X:      offset + i*width,
-		Y:      sum,
-		Width:  60,
-		Height: height,
-	}, walk.TextWordbreak); err != nil {
-		return err
-	}
-
-	if err := canvas.DrawText(fmt.Sprint(item.Money), font, walk.RGB(0, 0, 0), walk.Rectangle{
-		X:      offset-6 + i*width,
-		Y:      sum - height - 20,
-		Width:  60,
-		Height: 20,
-	}, walk.TextWordbreak); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func DrawYear(canvas *walk.Canvas,item YearCount,i int ,sum int) (err error){
-
-	var height = 14
-
-	ellipseBrush, err :=  walk.NewSolidColorBrush(rgbs[(item.Year)%len(rgbs)])
-	if err != nil {
-		return err
-	}
-	defer ellipseBrush.Dispose()
-
-	leng := item.Money*2/10000
-
-	if err := canvas.DrawText(fmt.Sprint(item.Year," 年"), font, walk.RGB(0, 0, 0), walk.Rectangle{
-		X:      10,
-		Y:      sum-height*2*i-height,
-		Width:  60,
-		Height: height,
-	}, walk.TextWordbreak); err != nil {
-		return err
-	}
-
-	if err := canvas.FillRectangle(ellipseBrush, walk.Rectangle{
-		X:      60,
-		Y:      sum-height*2*i-height,
-		Width:  int(leng),
-		Height: height,
-	}); err != nil {
-		return  err
-	}
-
-	var year_str = ""
-	if item.Money>=10000{
-		end := int(item.Money)%10000;
-		var fill = ""
-		if end<10{
-			fill = "000"
-		}else if end< 100{
-			fill = "00"
-		}else if end < 1000{
-			fill = "0"
-		}
-		year_str = fmt.Sprint(int(item.Money)/10000," ",fill,end," 元")
-	}else{
-		year_str = fmt.Sprint(int(item.Money)%10000," 元")
-	}
-
-	if err := canvas.DrawText(year_str, font, walk.RGB(0, 0, 0), walk.Rectangle{
-		X:      60+10+int(leng),
-		Y:      sum-height*2*i-height,
-		Width:  100,
-		Height: height,
-	}, walk.TextWordbreak); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (mw *MyMainWindow) drawStuff(canvas *walk.Canvas, updateBounds walk.Rectangle) error {
-	mons, yc ,max, _ := GetMonthSum()
-
-	var height = len(yc)*30+100
-	if (height<350){
-		height = 350
-	}
-	sum := height - 76
-	//bmp, err := createBitmap()
-	//if err != nil {
-	//	return err
-	//}
-	//defer bmp.Dispose()
-
-	//ellipseBrush, err := walk.NewHatchBrush(walk.RGB(255, 0, 255), walk.HatchBackwardDiagonal)
-	//ellipseBrush, err :=  walk.NewSolidColorBrush(walk.RGB(0, 0, 255))
-	//if err != nil {
-	//	return err
-	//}
-	//defer ellipseBrush.Dispose()
-	//
-	
-	for i,item := range yc{
-		if err := DrawYear(canvas, item,i ,sum); err != nil {
-			return err
-		}
-	}
-
-	// 总高度200
-	//var cur_year,sum_year,c_year,m_year, last_year= 0, 0.0, 1, 0, 0
-	// var cur_year,sum_year,c_year,m_year, last_year= 0, 0.0, 1, 0, 0
-
-	for i, item := range mons {
-		// year := (item.Month-1)/12
-
-		// if 0==last_year {
-		// 	last_year = year
-		// }
-
-		// if cur_year != year {
-		// 	m_year++
-		// }
-
-		if err := DrawMonth(canvas,item,i,max,sum); err != nil {
-			return err
-		}
-
-		// if cur_year != year {
-		// 	if c_year>1{
-		// 		if err := DrawYear(canvas, c_year-1,last_year, sum_year,sum); err != nil {
-		// 			return err
-		// 		}
-		// 	}
-
-		// 	last_year = cur_year
-		// 	cur_year = year
-
-		// 	sum_year = 0.0
-		// 	c_year++
-		// }
-		// sum_year += item.Money
-		// last_year = year
-	}
-
-	// if err := DrawYear(canvas, c_year-1, last_year, sum_year,sum); err != nil {
-	// 	return err
-	// }
-	return nil
-}
-
 //
 //func createBitmap() (*walk.Bitmap, error) {
 //	bounds := walk.Rectangle{Width: 200, Height: 200}
@@ -1023,12 +1018,18 @@ func AddDialog(owner walk.Form, foo *Foo) (int, error) {
 	dlg := new(MyDialog)
 	var db *walk.DataBinder
 	var acceptPB, cancelPB *walk.PushButton
+	var paidNE, payNE *walk.NumberEdit
+	var methodCB *walk.ComboBox
 	addIcon, _ := walk.Resources.Icon("img/plus.png")
 	addFlag := foo == nil
 	if addFlag {
 		foo = new(Foo)
 		foo.Sex = SexMan
 	}
+	//修改前的内容，保存时与之比较生成修改记录
+	before := *foo
+	//对话框绑定到副本，保存成功才写回 foo；取消或保存失败时记录保持原样
+	edit := *foo
 	return Dialog{
 		AssignTo:      &dlg.Dialog,
 		Icon:          addIcon,
@@ -1039,7 +1040,7 @@ func AddDialog(owner walk.Form, foo *Foo) (int, error) {
 		DataBinder: DataBinder{
 			AssignTo:       &db,
 			Name:           "foo",
-			DataSource:     foo,
+			DataSource:     &edit,
 			ErrorPresenter: ToolTipErrorPresenter{},
 		},
 		MinSize: Size{Width: 300},
@@ -1060,7 +1061,18 @@ func AddDialog(owner walk.Form, foo *Foo) (int, error) {
 						Text: "联系电话:",
 					},
 					LineEdit{
-						Text: Bind("Phone"),
+						Text:        Bind("Phone", PhoneNumber{Keep: before.Phone}),
+						ToolTipText: "手机号码或带区号的固定电话",
+						OnEditingFinished: func() {
+							//老病人复诊时按电话调出档案
+							if !addFlag || db.Submit() != nil {
+								return
+							}
+							if p := model.findPatient(edit.Name, edit.Phone); p != nil {
+								p.fill(&edit)
+								_ = db.Reset()
+							}
+						},
 					},
 
 					RadioButtonGroupBox{
@@ -1111,51 +1123,145 @@ func AddDialog(owner walk.Form, foo *Foo) (int, error) {
 						Text: "就诊费用:",
 					},
 					NumberEdit{
-						Value:    Bind("AllFee"),
+						Value:    Bind("AllFeeYuan"),
 						Suffix:   "$",
-						Decimals: 1,
+						Decimals: 2,
 					},
 
 					Label{
 						Text: "实收费用:",
 					},
 					NumberEdit{
-						Value:    Bind("RealFee"),
+						Value:    Bind("RealFeeYuan"),
 						Suffix:   "$",
-						Decimals: 1,
+						Decimals: 2,
 					},
 
 					Label{
 						Text: "已付费用:",
 					},
 					NumberEdit{
-						Value:    Bind("PaidFee"),
+						AssignTo: &paidNE,
+						Value:    Bind("PaidFeeYuan"),
+						Suffix:   "$",
+						Decimals: 2,
+						ReadOnly: true,
+					},
+
+					Label{
+						Text: "本次收款:",
+					},
+					NumberEdit{
+						AssignTo: &payNE,
 						Suffix:   "$",
-						Decimals: 1,
+						Decimals: 2,
+					},
+
+					Label{
+						Text: "收款方式:",
+					},
+					ComboBox{
+						AssignTo:     &methodCB,
+						Model:        payMethods,
+						CurrentIndex: 0,
 					},
 				},
 			},
 			Composite{
 				Layout: HBox{},
 				Children: []Widget{
+					PushButton{
+						Text:    "收费记录",
+						Visible: !addFlag,
+						OnClicked: func() {
+							PaymentsDialog(dlg, foo)
+							edit.PaidFee, edit.Payments = foo.PaidFee, foo.Payments
+							_ = paidNE.SetValue(edit.PaidFee.Yuan())
+						},
+					},
+					PushButton{
+						Text:      "修改记录",
+						Visible:   !addFlag,
+						OnClicked: func() { ChangesDialog(dlg, foo) },
+					},
 					HSpacer{},
 					PushButton{
 						AssignTo: &acceptPB,
 						Text:     "保存",
 						OnClicked: func() {
 							if err := db.Submit(); err == nil {
-								if foo.AllFee > 0 || foo.RealFee > 0 || foo.PaidFee > 0 {
-									if foo.RealFee == 0.0 {
-										foo.RealFee = foo.AllFee
+								amount := MoneyOf(payNE.Value())
+								if edit.AllFee > 0 || edit.RealFee > 0 || edit.PaidFee > 0 || amount > 0 {
+									if edit.RealFee == 0 {
+										edit.RealFee = edit.AllFee
 									}
 
-									foo.Update = time.Now()
+									edit.Update = time.Now()
 									if addFlag {
-										foo.Deleted = false
-										foo.Create = foo.Update
-										model.Head(foo)
+										//归档时要按登记时间判断是否是最新的一次就诊
+										edit.Create = edit.Update
+									}
+									edit.cleanPhone()
+									//已日结的就诊要说明修改的原因；已付的变化由作废收款另行记下
+									was := before
+									was.PaidFee = edit.PaidFee
+									var closing *Closing
+									var reason string
+									if !addFlag {
+										rwLock.RLock()
+										closing = model.closingOf(before.Create)
+										rwLock.RUnlock()
+									}
+									if closing != nil && len(diff(&was, &edit, edit.Update)) > 0 {
+										var ok bool
+										if reason, ok = adjustReason(dlg, fmt.Sprintf("这次就诊登记在 %s，已经日结，修改将记为调整。", dayKey(closing.Day))); !ok {
+											return
+										}
+									}
+									err := model.commit(func(store Store) (err error) {
+										current := *foo
+										*foo = edit
+										defer func() {
+											if err != nil {
+												*foo = current
+											}
+										}()
+										if err := model.link(store, foo); err != nil {
+											return err
+										}
+										if !addFlag {
+											if err := store.Update(foo); err != nil {
+												return err
+											}
+											if err := model.audit(store, &before, foo); err != nil {
+												return err
+											}
+											if reason != "" {
+												if err := model.adjust(store, closing, adjustEdit, reason, &was, foo); err != nil {
+													return err
+												}
+											}
+										} else {
+											foo.Deleted = false
+											if err := store.Insert(foo); err != nil {
+												return err
+											}
+											model.Head(foo)
+										}
+										model.reindex(foo)
+										if amount > 0 {
+											return model.pay(store, foo, amount, methodCB.Text(), "")
+										}
+										return nil
+									})
+									if err != nil {
+										walk.MsgBox(dlg, "错误", "保存失败："+err.Error(), walk.MsgBoxIconError)
+										//失败后数据已重新载入，foo 不再是表格中的那条记录，要重新打开再改
+										if !addFlag {
+											dlg.Cancel()
+										}
+										return
 									}
-									model.save()
 									dlg.Accept()
 								} else {
 									dlg.openAction_Triggered()
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupDir   = "backup"
+	backupCount = 10
+)
+
+//writeAtomic 先写入同目录下的临时文件并落盘，再备份旧文件、改名覆盖，
+//中途崩溃或磁盘写满时原文件保持不变
+func writeAtomic(name string, fn func(w io.Writer) error) error {
+	return replaceFile(name, true, fn)
+}
+
+//replaceFile 同 writeAtomic，withBackup 为 false 时不保留备份
+func replaceFile(name string, withBackup bool, fn func(w io.Writer) error) error {
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, base+".tmp*")
+	if err != nil {
+		return err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := fn(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if withBackup {
+		if err := backup(name); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	ok = true
+	return nil
+}
+
+//backup 把当前文件复制到备份目录，只保留最近 backupCount 份
+func backup(name string) error {
+	dir := filepath.Join(filepath.Dir(name), backupDir)
+	ext := filepath.Ext(name)
+	prefix := strings.TrimSuffix(filepath.Base(name), ext) + "-"
+	if err := copyFile(name, filepath.Join(dir, prefix+time.Now().Format("20060102-150405.000")+ext)); err != nil {
+		return err
+	}
+
+	olds, err := filepath.Glob(filepath.Join(dir, prefix+"*"+ext))
+	if err != nil {
+		return err
+	}
+	sort.Strings(olds)
+	for len(olds) > backupCount {
+		_ = os.Remove(olds[0])
+		olds = olds[1:]
+	}
+	return nil
+}
+
+//pinBackup 把当前文件复制为备份目录中的 name.tag.ext，不参与 backup 的轮换；
+//已经有这份备份时保留最早的那份
+func pinBackup(name, tag string) error {
+	ext := filepath.Ext(name)
+	dst := filepath.Join(filepath.Dir(name), backupDir, strings.TrimSuffix(filepath.Base(name), ext)+"."+tag+ext)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	return copyFile(name, dst)
+}
+
+//copyFile 复制文件并落盘，目录不存在时创建；源文件不存在时什么也不做
+func copyFile(name, dst string) error {
+	src, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
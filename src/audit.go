@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os/user"
+	"sort"
+	"time"
+)
+
+//operator 操作人，记入修改记录，默认为当前系统用户
+var operator = currentUser()
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+//Change 对一条就诊记录一个字段的修改，只追加不修改
+type Change struct {
+	ID       string
+	VisitID  string
+	Field    string
+	Old      string
+	New      string
+	At       time.Time
+	Operator string
+}
+
+//auditSkip 不记录修改的列：编号不会变，最新时间每次保存都会变，规范电话随电话变化
+var auditSkip = map[string]bool{"编号": true, "最新时间": true, "规范电话": true}
+
+//diff 按 fooColumns 逐列比较修改前后的记录
+func diff(before, after *Foo, at time.Time) []*Change {
+	old, cur := before.values(), after.values()
+	var changes []*Change
+	for i, col := range fooColumns {
+		if auditSkip[col] || old[i] == cur[i] {
+			continue
+		}
+		changes = append(changes, &Change{
+			VisitID:  after.ID,
+			Field:    col,
+			Old:      old[i],
+			New:      cur[i],
+			At:       at,
+			Operator: operator,
+		})
+	}
+	return changes
+}
+
+//audit 保存 before 到 after 之间的修改记录，在保存记录本身之后调用
+func (m *FooModel) audit(store Store, before, after *Foo) error {
+	for _, c := range diff(before, after, time.Now()) {
+		if err := store.InsertChange(c); err != nil {
+			return err
+		}
+		m.changes[c.VisitID] = append(m.changes[c.VisitID], c)
+	}
+	return nil
+}
+
+//attachChanges 按就诊分组载入修改记录
+func (m *FooModel) attachChanges(changes []*Change) {
+	m.changes = map[string][]*Change{}
+	for _, c := range changes {
+		m.changes[c.VisitID] = append(m.changes[c.VisitID], c)
+	}
+}
+
+//changesOf 一次就诊的修改记录，最近的在前
+func (m *FooModel) changesOf(visitID string) []*Change {
+	changes := append([]*Change{}, m.changes[visitID]...)
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].At.After(changes[j].At)
+	})
+	return changes
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+//ChangesDialog 列出一次就诊的修改记录
+func ChangesDialog(owner walk.Form, foo *Foo) (int, error) {
+	rwLock.RLock()
+	changes := model.changesOf(foo.ID)
+	rwLock.RUnlock()
+
+	return Dialog{
+		Title:   foo.Name + " 的修改记录",
+		MinSize: Size{Width: 700, Height: 300},
+		Layout:  VBox{},
+		Children: []Widget{
+			TableView{
+				Columns: []TableViewColumn{
+					{Name: "At", Title: "修改时间", Format: "2006-01-02 15:04:05", Width: 140},
+					{Name: "Operator", Title: "操作人", Width: 80},
+					{Name: "Field", Title: "字段", Width: 80},
+					{Name: "Old", Title: "原值", Width: 180},
+					{Name: "New", Title: "新值", Width: 180},
+				},
+				Model: changes,
+			},
+		},
+	}.Run(owner)
+}
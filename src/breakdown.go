@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gaoyangtok/medic/src/stats"
+)
+
+//breakdownFields 可以分组统计收入的字段
+var breakdownFields = []struct {
+	Title string
+	Value func(foo *Foo) string
+}{
+	{"病例诊断", func(foo *Foo) string { return foo.Diagnosed }},
+	{"治疗方案", func(foo *Foo) string { return foo.Program }},
+}
+
+//breakdownMetrics 排行和走势可以依据的数字
+var breakdownMetrics = []chartMetric{
+	{Title: "就诊次数", Value: func(b stats.Bucket) int64 { return int64(b.Visits) }, Count: true},
+	{Title: "应收", Value: func(b stats.Bucket) int64 { return b.Billed }},
+	{Title: "已收", Value: func(b stats.Bucket) int64 { return b.Collected }},
+	{Title: "平均费用", Value: averageFee},
+	{Title: "欠费余额", Value: func(b stats.Bucket) int64 { return b.Outstanding }, Balance: true},
+}
+
+//averageFee 每次就诊的平均应收
+func averageFee(b stats.Bucket) int64 {
+	if b.Visits == 0 {
+		return 0
+	}
+	return b.Billed / int64(b.Visits)
+}
+
+func breakdownFieldTitles() []string {
+	titles := make([]string, len(breakdownFields))
+	for i, f := range breakdownFields {
+		titles[i] = f.Title
+	}
+	return titles
+}
+
+func breakdownMetricTitles() []string {
+	titles := make([]string, len(breakdownMetrics))
+	for i, c := range breakdownMetrics {
+		titles[i] = c.Title
+	}
+	return titles
+}
+
+//unfilledTerm 诊断或方案没有填写时归入的类别
+const unfilledTerm = "（未填写）"
+
+//termWidth 全角标点对应的半角字符
+var termWidth = strings.NewReplacer("，", ",", "；", ";", "：", ":", "（", "(", "）", ")", "　", " ")
+
+//normalizeTerm 整理诊断或方案的写法：全角标点改为半角，连续的空白合并为一个空格，
+//去掉首尾的空白和句末标点，再按同义词表换成标准名；synonyms 的键为小写，见 synonymLookup
+func normalizeTerm(s string, synonyms map[string]string) string {
+	s = strings.Join(strings.Fields(termWidth.Replace(s)), " ")
+	s = strings.Trim(s, " .,;:、。")
+	if s == "" {
+		return unfilledTerm
+	}
+	if name, ok := synonyms[strings.ToLower(s)]; ok {
+		return name
+	}
+	return s
+}
+
+//parseSynonyms 解析同义词表，每行为“标准名=别名,别名”，别名之间也可以用顿号或分号分隔；
+//空行和 # 开头的行忽略。返回标准名及其别名
+func parseSynonyms(text string) (map[string][]string, error) {
+	synonyms := map[string][]string{}
+	seen := map[string]string{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.Replace(line, "＝", "=", -1)
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("第 %d 行应为“标准名=别名,别名”", i+1)
+		}
+		name := normalizeTerm(line[:eq], nil)
+		if name == unfilledTerm {
+			return nil, fmt.Errorf("第 %d 行没有标准名", i+1)
+		}
+		aliases := strings.FieldsFunc(termWidth.Replace(line[eq+1:]), func(r rune) bool {
+			return r == ',' || r == ';' || r == '、'
+		})
+		for _, alias := range aliases {
+			alias = normalizeTerm(alias, nil)
+			if alias == unfilledTerm || alias == name {
+				continue
+			}
+			key := strings.ToLower(alias)
+			if other, ok := seen[key]; ok && other != name {
+				return nil, fmt.Errorf("第 %d 行：%s 已经是 %s 的别名", i+1, alias, other)
+			}
+			seen[key] = name
+			synonyms[name] = append(synonyms[name], alias)
+		}
+		if _, ok := synonyms[name]; !ok {
+			synonyms[name] = nil
+		}
+	}
+	return synonyms, nil
+}
+
+//formatSynonyms 同义词表的文本形式，按标准名排列
+func formatSynonyms(synonyms map[string][]string) string {
+	names := make([]string, 0, len(synonyms))
+	for name := range synonyms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%s\r\n", name, strings.Join(synonyms[name], ","))
+	}
+	return b.String()
+}
+
+//synonymLookup 别名和标准名的小写形式到标准名，给 normalizeTerm 使用
+func synonymLookup(synonyms map[string][]string) map[string]string {
+	lookup := map[string]string{}
+	for name, aliases := range synonyms {
+		lookup[strings.ToLower(name)] = name
+		for _, alias := range aliases {
+			lookup[strings.ToLower(alias)] = name
+		}
+	}
+	return lookup
+}
+
+//BreakdownRow 一种诊断或治疗方案在统计期间的收入
+type BreakdownRow struct {
+	Rank        int
+	Name        string
+	Visits      int
+	Billed      Money
+	Collected   Money
+	Average     Money
+	Outstanding Money
+	//Share 已收占全部已收的百分比
+	Share string
+	sum   stats.Bucket
+	//visits 这一类的就诊，用于走势
+	visits []stats.Visit
+}
+
+//breakdown 按第 field 个字段把就诊分类，汇总 [from, to) 的次数和金额，按 metric 从大到小排列。
+//欠费余额为 to 时仍未付清的部分；期间没有就诊、收款，也没有欠费的类别不列出。需持有读锁
+func (m *FooModel) breakdown(field int, from, to time.Time, metric chartMetric, synonyms map[string]string) []*BreakdownRow {
+	value := breakdownFields[field].Value
+	byName := map[string]*BreakdownRow{}
+	var rows []*BreakdownRow
+	for _, item := range m.items {
+		if item.Deleted {
+			continue
+		}
+		name := normalizeTerm(value(item), synonyms)
+		r, ok := byName[name]
+		if !ok {
+			r = &BreakdownRow{Name: name}
+			byName[name] = r
+			rows = append(rows, r)
+		}
+		r.visits = append(r.visits, statsVisit(item))
+	}
+
+	var collected int64
+	kept := rows[:0]
+	for _, r := range rows {
+		r.sum = stats.Sum(r.visits, from, to)
+		if r.sum.Visits == 0 && r.sum.Collected == 0 && r.sum.Outstanding == 0 {
+			continue
+		}
+		r.Visits = r.sum.Visits
+		r.Billed, r.Collected, r.Outstanding = Money(r.sum.Billed), Money(r.sum.Collected), Money(r.sum.Outstanding)
+		r.Average = Money(averageFee(r.sum))
+		collected += r.sum.Collected
+		kept = append(kept, r)
+	}
+	rows = kept
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := metric.Value(rows[i].sum), metric.Value(rows[j].sum)
+		if a != b {
+			return a > b
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	for i, r := range rows {
+		r.Rank = i + 1
+		r.Share = "—"
+		if collected != 0 {
+			r.Share = fmt.Sprintf("%.1f%%", float64(r.sum.Collected)*100/float64(collected))
+		}
+	}
+	return rows
+}
+
+//breakdownTotal 全部类别的合计
+func breakdownTotal(rows []*BreakdownRow) stats.Bucket {
+	var t stats.Bucket
+	for _, r := range rows {
+		t.Visits += r.sum.Visits
+		t.Billed += r.sum.Billed
+		t.Collected += r.sum.Collected
+		t.Outstanding += r.sum.Outstanding
+	}
+	return t
+}
+
+//breakdownTrend 排在前 n 名的类别按 p 分段的走势，Kind 为名次减一
+func breakdownTrend(rows []*BreakdownRow, n int, p stats.Period, from, to time.Time) ([]chartSeries, error) {
+	if len(rows) < n {
+		n = len(rows)
+	}
+	var series []chartSeries
+	for i, r := range rows[:n] {
+		buckets := stats.Series(r.visits, p, from, to, time.Local)
+		if len(buckets) > chartMaxBuckets {
+			return nil, fmt.Errorf("共 %d 个时间段，太多了，请缩短日期范围或按更长的时间段统计", len(buckets))
+		}
+		series = append(series, chartSeries{Kind: i, Title: r.Name, Buckets: buckets})
+	}
+	return series, nil
+}
+
+//breakdownHeader、breakdownRecords 导出的排行
+func breakdownHeader(field int) []string {
+	return []string{"名次", breakdownFields[field].Title, "就诊次数", "应收", "已收", "平均费用", "欠费余额", "收入占比"}
+}
+
+func breakdownRecords(rows []*BreakdownRow) [][]string {
+	records := make([][]string, 0, len(rows))
+	for _, r := range rows {
+		records = append(records, []string{
+			strconv.Itoa(r.Rank), r.Name, strconv.Itoa(r.Visits),
+			r.Billed.String(), r.Collected.String(), r.Average.String(), r.Outstanding.String(), r.Share,
+		})
+	}
+	return records
+}
+
+//trendHeader、trendRecords 导出的走势，每行一个类别，每列一个时间段
+func trendHeader(field int, series []chartSeries, p stats.Period) []string {
+	header := []string{breakdownFields[field].Title}
+	if len(series) > 0 {
+		for _, b := range series[0].Buckets {
+			header = append(header, p.Label(b.Start))
+		}
+	}
+	return header
+}
+
+func trendRecords(series []chartSeries, metric chartMetric) [][]string {
+	records := make([][]string, 0, len(series))
+	for _, s := range series {
+		record := []string{s.Title}
+		for _, v := range s.values(metric) {
+			if metric.Count {
+				record = append(record, strconv.FormatInt(v, 10))
+			} else {
+				record = append(record, Money(v).String())
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
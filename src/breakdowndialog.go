@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+//trendTops 走势图可以显示的名次
+var trendTops = []int{3, 5, 8}
+
+//BreakdownDialog 按诊断或治疗方案分类的收入排行和前几名的走势，日期范围和时间段沿用统计窗口的选项
+func BreakdownDialog(owner walk.Form, o chartOptions) (int, error) {
+	var dlg *walk.Dialog
+	var fieldCB, metricCB, topCB *walk.ComboBox
+	var summaryLabel *walk.Label
+	var tv *walk.TableView
+	var chart *walk.CustomWidget
+	var rows []*BreakdownRow
+	var series []chartSeries
+	var trendErr error
+	from, to := o.span()
+	synonyms := loadSynonyms()
+
+	metric := func() chartMetric {
+		return breakdownMetrics[metricCB.CurrentIndex()]
+	}
+
+	refresh := func() {
+		if chart == nil {
+			return
+		}
+		rwLock.RLock()
+		rows = model.breakdown(fieldCB.CurrentIndex(), from, to, metric(), synonymLookup(synonyms))
+		rwLock.RUnlock()
+		series, trendErr = breakdownTrend(rows, trendTops[topCB.CurrentIndex()], o.Period, from, to)
+		_ = tv.SetModel(rows)
+
+		t := breakdownTotal(rows)
+		text := fmt.Sprintf("%s 至 %s 共 %d 类，就诊 %d 次，应收 %s 元，已收 %s 元，欠费 %s 元",
+			from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02"), len(rows),
+			t.Visits, Money(t.Billed), Money(t.Collected), Money(t.Outstanding))
+		if trendErr != nil {
+			text += "；" + trendErr.Error()
+		}
+		_ = summaryLabel.SetText(text)
+		_ = chart.Invalidate()
+	}
+
+	exportName := func(kind string) string {
+		return fmt.Sprintf("%s%s%s-%s.csv", breakdownFields[fieldCB.CurrentIndex()].Title, kind,
+			from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+	}
+
+	err := Dialog{
+		AssignTo: &dlg,
+		Title:    "诊断和治疗方案收入分析",
+		MinSize:  Size{Width: 1000, Height: 650},
+		Layout:   VBox{},
+		Children: []Widget{
+			Composite{
+				Layout: HBox{MarginsZero: true},
+				Children: []Widget{
+					Label{Text: "分类:"},
+					ComboBox{
+						AssignTo:              &fieldCB,
+						Model:                 breakdownFieldTitles(),
+						CurrentIndex:          0,
+						OnCurrentIndexChanged: refresh,
+					},
+					Label{Text: "排序:"},
+					ComboBox{
+						AssignTo:              &metricCB,
+						Model:                 breakdownMetricTitles(),
+						CurrentIndex:          2,
+						OnCurrentIndexChanged: refresh,
+					},
+					Label{Text: "走势:"},
+					ComboBox{
+						AssignTo:              &topCB,
+						Model:                 []string{"前 3 名", "前 5 名", "前 8 名"},
+						CurrentIndex:          1,
+						OnCurrentIndexChanged: refresh,
+					},
+					HSpacer{},
+					PushButton{
+						Text:        "同义词",
+						ToolTipText: "把不同写法的诊断或方案归为一类",
+						OnClicked: func() {
+							if SynonymDialog(dlg, synonyms) == walk.DlgCmdOK {
+								synonyms = loadSynonyms()
+								refresh()
+							}
+						},
+					},
+				},
+			},
+			Label{
+				AssignTo: &summaryLabel,
+				Font:     labelFont,
+			},
+			TableView{
+				AssignTo:         &tv,
+				ColumnsOrderable: true,
+				StretchFactor:    1,
+				Columns: []TableViewColumn{
+					{Name: "Rank", Title: "名次", Alignment: AlignFar, Width: 40},
+					{Name: "Name", Title: "名称", Width: 250},
+					{Name: "Visits", Title: "就诊次数", Alignment: AlignFar, Width: 70},
+					{Name: "Billed", Title: "应收", Alignment: AlignFar, Width: 90},
+					{Name: "Collected", Title: "已收", Alignment: AlignFar, Width: 90},
+					{Name: "Average", Title: "平均费用", Alignment: AlignFar, Width: 80},
+					{Name: "Outstanding", Title: "欠费余额", Alignment: AlignFar, Width: 80},
+					{Name: "Share", Title: "收入占比", Alignment: AlignFar, Width: 70},
+				},
+			},
+			CustomWidget{
+				AssignTo:            &chart,
+				ClearsBackground:    true,
+				InvalidatesOnResize: true,
+				StretchFactor:       1,
+				MinSize:             Size{Height: 250},
+				Paint: func(canvas *walk.Canvas, updateBounds walk.Rectangle) error {
+					if trendErr != nil {
+						return nil
+					}
+					return paintChart(canvas, chart.ClientBounds(), series, metric(), o.Period, true)
+				},
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						Text: "导出排行",
+						OnClicked: func() {
+							field := fieldCB.CurrentIndex()
+							exportTo(dlg, "导出收入排行", exportName("排行"), breakdownHeader(field), breakdownRecords(rows))
+						},
+					},
+					PushButton{
+						Text: "导出走势",
+						OnClicked: func() {
+							if trendErr != nil {
+								walk.MsgBox(dlg, "提示", trendErr.Error(), walk.MsgBoxIconInformation)
+								return
+							}
+							field := fieldCB.CurrentIndex()
+							exportTo(dlg, "导出"+metric().Title+"走势", exportName("走势"),
+								trendHeader(field, series, o.Period), trendRecords(series, metric()))
+						},
+					},
+					PushButton{
+						Text:      "关闭",
+						OnClicked: func() { dlg.Accept() },
+					},
+				},
+			},
+		},
+	}.Create(owner)
+	if err != nil {
+		return 0, err
+	}
+	cancel := model.watchers.watch(func() {
+		dlg.Synchronize(refresh)
+	})
+	defer cancel()
+	refresh()
+	return dlg.Run(), nil
+}
+
+//SynonymDialog 编辑诊断和治疗方案的同义词表，保存后返回 walk.DlgCmdOK
+func SynonymDialog(owner walk.Form, synonyms map[string][]string) int {
+	var dlg *walk.Dialog
+	var te *walk.TextEdit
+	result, err := Dialog{
+		AssignTo: &dlg,
+		Title:    "同义词",
+		MinSize:  Size{Width: 500, Height: 400},
+		Layout:   VBox{},
+		Children: []Widget{
+			Label{
+				Text: "每行一类，写作“标准名=别名,别名”，统计时别名归入标准名。\r\n例如：腰椎间盘突出症=腰突,腰椎间盘突出",
+			},
+			TextEdit{
+				AssignTo: &te,
+				Text:     formatSynonyms(synonyms),
+				VScroll:  true,
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						Text: "保存",
+						OnClicked: func() {
+							parsed, err := parseSynonyms(te.Text())
+							if err != nil {
+								walk.MsgBox(dlg, "错误", err.Error(), walk.MsgBoxIconError)
+								return
+							}
+							if err := saveSynonyms(parsed); err != nil {
+								walk.MsgBox(dlg, "错误", "保存失败："+err.Error(), walk.MsgBoxIconError)
+								return
+							}
+							dlg.Accept()
+						},
+					},
+					PushButton{
+						Text:      "取消",
+						OnClicked: func() { dlg.Cancel() },
+					},
+				},
+			},
+		},
+	}.Run(owner)
+	if err != nil {
+		walk.MsgBox(owner, "错误", err.Error(), walk.MsgBoxIconError)
+	}
+	return result
+}
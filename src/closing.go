@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//Closing 一天的日结，记下日结时的汇总数字。日结后，这一天登记的就诊和这一天的收款
+//不能再直接修改、删除、恢复或作废，要登记调整（Adjustment）
+type Closing struct {
+	ID  string
+	Day time.Time
+	//以下为日结时的数字，含义见 DailyReport
+	Registered  int
+	NewPatients int
+	Edited      int
+	AllFee      Money
+	RealFee     Money
+	Collected   Money
+	Receivable  Money
+	Note        string
+	At          time.Time
+	Operator    string
+}
+
+//Adjustment 对已日结日期的一次调整，只追加不修改
+type Adjustment struct {
+	ID string
+	//Day 被调整的日结日期
+	Day     time.Time
+	VisitID string
+	Kind    string
+	Reason  string
+	//RealFee、Paid 调整使实收费用、已付费用增加的金额，减少时为负
+	RealFee  Money
+	Paid     Money
+	At       time.Time
+	Operator string
+}
+
+//调整的种类
+const (
+	adjustEdit    = "修改"
+	adjustDelete  = "删除"
+	adjustRestore = "恢复"
+	adjustVoid    = "作废收款"
+	//合并档案、批量规范电话不改变金额，改到已日结的就诊时自动登记
+	adjustMerge = "合并档案"
+	adjustPhone = "规范电话"
+)
+
+//dayKey 日期的键，按本地时间的年月日
+func dayKey(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+//attachClosings 载入日结和调整记录
+func (m *FooModel) attachClosings(closings []*Closing, adjustments []*Adjustment) {
+	m.closings = map[string]*Closing{}
+	for _, c := range closings {
+		m.closings[dayKey(c.Day)] = c
+	}
+	m.adjustments = adjustments
+}
+
+//closingOf t 所在那天的日结，没有日结时为 nil
+func (m *FooModel) closingOf(t time.Time) *Closing {
+	if t.IsZero() {
+		return nil
+	}
+	return m.closings[dayKey(t)]
+}
+
+//lockedBy 就诊登记的那天或它的某笔收款那天的日结，都没有日结时为 nil；
+//有日结的就诊不能彻底删除
+func (m *FooModel) lockedBy(foo *Foo) *Closing {
+	if c := m.closingOf(foo.Create); c != nil {
+		return c
+	}
+	for _, p := range foo.Payments {
+		if c := m.closingOf(p.At); c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+//counted 就诊计入报表的实收和已付，已删除的不计
+func counted(foo *Foo) (realFee, paid Money) {
+	if foo.Deleted {
+		return 0, 0
+	}
+	return foo.RealFee, foo.PaidFee
+}
+
+//adjust 记下对已日结日期 c 的一次调整，before、after 为调整前后的就诊；在保存调整本身之后调用
+func (m *FooModel) adjust(store Store, c *Closing, kind, reason string, before, after *Foo) error {
+	realBefore, paidBefore := counted(before)
+	realAfter, paidAfter := counted(after)
+	a := &Adjustment{
+		Day:      c.Day,
+		VisitID:  after.ID,
+		Kind:     kind,
+		Reason:   reason,
+		RealFee:  realAfter - realBefore,
+		Paid:     paidAfter - paidBefore,
+		At:       time.Now(),
+		Operator: operator,
+	}
+	if err := store.InsertAdjustment(a); err != nil {
+		return err
+	}
+	m.adjustments = append(m.adjustments, a)
+	return nil
+}
+
+//adjustAuto 由程序批量改动的就诊登记在已日结的日期时，以 reason 自动登记调整
+func (m *FooModel) adjustAuto(store Store, kind, reason string, before, after *Foo) error {
+	if c := m.closingOf(before.Create); c != nil {
+		return m.adjust(store, c, kind, reason, before, after)
+	}
+	return nil
+}
+
+//MethodTotal 一种收款方式的合计
+type MethodTotal struct {
+	Method string
+	Count  int
+	Amount Money
+}
+
+//DayVisit 报表中当天登记的一次就诊
+type DayVisit struct {
+	Create  time.Time
+	Name    string
+	AllFee  Money
+	RealFee Money
+	//Paid 到当天结束时已付的，Owed 仍未付的
+	Paid Money
+	Owed Money
+}
+
+//DayPayment 报表中当天的一笔收款
+type DayPayment struct {
+	At     time.Time
+	Name   string
+	Method string
+	Amount Money
+	Note   string
+}
+
+//DayAdjustment 报表中当天登记的一次调整
+type DayAdjustment struct {
+	At       time.Time
+	Day      time.Time
+	Name     string
+	Kind     string
+	Reason   string
+	RealFee  Money
+	Paid     Money
+	Operator string
+}
+
+//DailyReport 一天的日结报表，已删除的就诊不计入
+type DailyReport struct {
+	Day time.Time
+	//Registered 当天登记的就诊次数，NewPatients 当天新建的病人档案
+	Registered  int
+	NewPatients int
+	//Edited 当天修改过的就诊，不论是哪天登记的
+	Edited int
+	//AllFee、RealFee 当天登记的就诊的就诊费用和实收费用
+	AllFee  Money
+	RealFee Money
+	//Collected 当天收到的款项，不论是哪天的就诊，ByMethod 按收款方式分列
+	Collected Money
+	ByMethod  []MethodTotal
+	//Receivable 新增欠费：当天登记的就诊到当天结束时仍未付清的部分
+	Receivable  Money
+	Visits      []*DayVisit
+	Payments    []*DayPayment
+	Adjustments []*DayAdjustment
+	//Closing 这一天的日结，还没有日结时为 nil
+	Closing *Closing
+}
+
+//dailyReport 汇总 day 这一天的日结报表；需持有读锁
+func (m *FooModel) dailyReport(day time.Time) *DailyReport {
+	key := dayKey(day)
+	r := &DailyReport{Day: today(day), Closing: m.closings[key]}
+	for id, p := range m.patients {
+		if _, merged := m.mergedInto[id]; !merged && dayKey(p.Create) == key {
+			r.NewPatients++
+		}
+	}
+	for _, changes := range m.changes {
+		for _, c := range changes {
+			if dayKey(c.At) == key {
+				r.Edited++
+				break
+			}
+		}
+	}
+
+	methods := map[string]*MethodTotal{}
+	names := map[string]string{}
+	for _, item := range m.items {
+		names[item.ID] = item.Name
+		if item.Deleted {
+			continue
+		}
+		registered := dayKey(item.Create) == key
+		var paid Money
+		for _, p := range item.Payments {
+			if p.Voided {
+				continue
+			}
+			pay := dayKey(p.At)
+			if pay == key {
+				r.Collected += p.Amount
+				method := p.Method
+				if method == "" {
+					method = "未注明"
+				}
+				t, ok := methods[method]
+				if !ok {
+					t = &MethodTotal{Method: method}
+					methods[method] = t
+				}
+				t.Count++
+				t.Amount += p.Amount
+				r.Payments = append(r.Payments, &DayPayment{At: p.At, Name: item.Name, Method: method, Amount: p.Amount, Note: p.Note})
+			}
+			//日期的键可以按字符串比较先后
+			if pay <= key {
+				paid += p.Amount
+			}
+		}
+		if !registered {
+			continue
+		}
+		v := &DayVisit{Create: item.Create, Name: item.Name, AllFee: item.AllFee, RealFee: item.RealFee, Paid: paid}
+		if paid < item.RealFee {
+			v.Owed = item.RealFee - paid
+		}
+		r.Registered++
+		r.AllFee += item.AllFee
+		r.RealFee += item.RealFee
+		r.Receivable += v.Owed
+		r.Visits = append(r.Visits, v)
+	}
+
+	for _, method := range payMethods {
+		if t, ok := methods[method]; ok {
+			r.ByMethod = append(r.ByMethod, *t)
+			delete(methods, method)
+		}
+	}
+	var others []string
+	for method := range methods {
+		others = append(others, method)
+	}
+	sort.Strings(others)
+	for _, method := range others {
+		r.ByMethod = append(r.ByMethod, *methods[method])
+	}
+
+	for _, a := range m.adjustments {
+		if dayKey(a.At) == key {
+			r.Adjustments = append(r.Adjustments, &DayAdjustment{
+				At: a.At, Day: a.Day, Name: names[a.VisitID], Kind: a.Kind, Reason: a.Reason,
+				RealFee: a.RealFee, Paid: a.Paid, Operator: a.Operator,
+			})
+		}
+	}
+	sort.SliceStable(r.Visits, func(i, j int) bool { return r.Visits[i].Create.Before(r.Visits[j].Create) })
+	sort.SliceStable(r.Payments, func(i, j int) bool { return r.Payments[i].At.Before(r.Payments[j].At) })
+	return r
+}
+
+//closeDay 日结：记下报表的汇总数字并锁定这一天；已经日结过或是以后的日期时返回错误
+func (m *FooModel) closeDay(store Store, r *DailyReport, note string, now time.Time) error {
+	key := dayKey(r.Day)
+	if _, ok := m.closings[key]; ok {
+		return fmt.Errorf("%s 已经日结", key)
+	}
+	if key > dayKey(now) {
+		return fmt.Errorf("%s 还没有到，不能日结", key)
+	}
+	c := &Closing{
+		Day:         r.Day,
+		Registered:  r.Registered,
+		NewPatients: r.NewPatients,
+		Edited:      r.Edited,
+		AllFee:      r.AllFee,
+		RealFee:     r.RealFee,
+		Collected:   r.Collected,
+		Receivable:  r.Receivable,
+		Note:        note,
+		At:          now,
+		Operator:    operator,
+	}
+	if err := store.InsertClosing(c); err != nil {
+		return err
+	}
+	m.closings[key] = c
+	r.Closing = c
+	return nil
+}
+
+//DailyLine 报表汇总的一行；Closed 为日结时的数字，没有日结时为空
+type DailyLine struct {
+	Title  string
+	Value  string
+	Closed string
+}
+
+//lines 报表的汇总，按显示的顺序
+func (r *DailyReport) lines() []*DailyLine {
+	//没有日结时 Closed 列为空
+	c := r.Closing
+	if c == nil {
+		c = &Closing{}
+	}
+	closed := func(s string) string {
+		if r.Closing == nil {
+			return ""
+		}
+		return s
+	}
+	count := strconv.Itoa
+	lines := []*DailyLine{
+		{"新登记就诊（次）", count(r.Registered), closed(count(c.Registered))},
+		{"新建档案（人）", count(r.NewPatients), closed(count(c.NewPatients))},
+		{"修改就诊（次）", count(r.Edited), closed(count(c.Edited))},
+		{"就诊费用", r.AllFee.String(), closed(c.AllFee.String())},
+		{"实收费用", r.RealFee.String(), closed(c.RealFee.String())},
+		{"优惠", (r.AllFee - r.RealFee).String(), closed((c.AllFee - c.RealFee).String())},
+		{"收款合计", r.Collected.String(), closed(c.Collected.String())},
+	}
+	for _, t := range r.ByMethod {
+		lines = append(lines, &DailyLine{Title: fmt.Sprintf("　%s（%d 笔）", t.Method, t.Count), Value: t.Amount.String()})
+	}
+	lines = append(lines, &DailyLine{"新增欠费", r.Receivable.String(), closed(c.Receivable.String())})
+	if len(r.Adjustments) > 0 {
+		var realFee, paid Money
+		for _, a := range r.Adjustments {
+			realFee += a.RealFee
+			paid += a.Paid
+		}
+		lines = append(lines,
+			&DailyLine{Title: fmt.Sprintf("调整已日结的记录（%d 次）实收变动", len(r.Adjustments)), Value: realFee.String()},
+			&DailyLine{Title: "调整已日结的记录 已付变动", Value: paid.String()},
+		)
+	}
+	return lines
+}
+
+//status 报表是否已日结的说明
+func (r *DailyReport) status() string {
+	if c := r.Closing; c != nil {
+		s := fmt.Sprintf("已于 %s 由 %s 日结", c.At.Format("2006-01-02 15:04"), c.Operator)
+		if c.Note != "" {
+			s += "，备注：" + c.Note
+		}
+		return s
+	}
+	return "未日结"
+}
+
+//dailyRecords 导出的日结报表：汇总在前，之后依次为就诊、收款和调整的明细，各有一行表头
+func dailyRecords(r *DailyReport) [][]string {
+	records := [][]string{{"日期", dayKey(r.Day), r.status()}}
+	for _, l := range r.lines() {
+		records = append(records, []string{l.Title, l.Value, l.Closed})
+	}
+	records = append(records, nil, []string{"登记时间", "姓名", "就诊费用", "实收费用", "当日已付", "欠费"})
+	for _, v := range r.Visits {
+		records = append(records, []string{v.Create.Format("15:04"), v.Name, v.AllFee.String(), v.RealFee.String(), v.Paid.String(), v.Owed.String()})
+	}
+	records = append(records, nil, []string{"收款时间", "姓名", "方式", "金额", "备注"})
+	for _, p := range r.Payments {
+		records = append(records, []string{p.At.Format("15:04"), p.Name, p.Method, p.Amount.String(), p.Note})
+	}
+	if len(r.Adjustments) > 0 {
+		records = append(records, nil, []string{"调整时间", "日结日期", "姓名", "类型", "原因", "实收变动", "已付变动", "操作人"})
+		for _, a := range r.Adjustments {
+			records = append(records, []string{a.At.Format("15:04"), dayKey(a.Day), a.Name, a.Kind, a.Reason, a.RealFee.String(), a.Paid.String(), a.Operator})
+		}
+	}
+	return records
+}
+
+func dailyHeader() []string {
+	return []string{"项目", "数值", "日结时"}
+}
+
+//dailyTemplate 打印用的日结报表
+var dailyTemplate = template.Must(template.New("daily").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>日结报表 {{.Day}}</title>
+<style>
+body { font-family: "Microsoft YaHei", sans-serif; font-size: 12pt; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #999; padding: 2px 8px; }
+td.num { text-align: right; }
+</style></head>
+<body onload="window.print()">
+<h2>日结报表 {{.Day}}</h2>
+<p>{{.Status}}</p>
+<table>
+<tr><th>项目</th><th>数值</th>{{if .Closed}}<th>日结时</th>{{end}}</tr>
+{{range .Lines}}<tr><td>{{.Title}}</td><td class="num">{{.Value}}</td>{{if $.Closed}}<td class="num">{{.Closed}}</td>{{end}}</tr>
+{{end}}</table>
+<h3>就诊明细</h3>
+<table>
+<tr><th>登记时间</th><th>姓名</th><th>就诊费用</th><th>实收费用</th><th>当日已付</th><th>欠费</th></tr>
+{{range .Visits}}<tr><td>{{.Create.Format "15:04"}}</td><td>{{.Name}}</td><td class="num">{{.AllFee}}</td><td class="num">{{.RealFee}}</td><td class="num">{{.Paid}}</td><td class="num">{{.Owed}}</td></tr>
+{{end}}</table>
+<h3>收款明细</h3>
+<table>
+<tr><th>收款时间</th><th>姓名</th><th>方式</th><th>金额</th><th>备注</th></tr>
+{{range .Payments}}<tr><td>{{.At.Format "15:04"}}</td><td>{{.Name}}</td><td>{{.Method}}</td><td class="num">{{.Amount}}</td><td>{{.Note}}</td></tr>
+{{end}}</table>
+{{if .Adjustments}}<h3>调整已日结的记录</h3>
+<table>
+<tr><th>调整时间</th><th>日结日期</th><th>姓名</th><th>类型</th><th>原因</th><th>实收变动</th><th>已付变动</th><th>操作人</th></tr>
+{{range .Adjustments}}<tr><td>{{.At.Format "15:04"}}</td><td>{{.Day.Format "2006-01-02"}}</td><td>{{.Name}}</td><td>{{.Kind}}</td><td>{{.Reason}}</td><td class="num">{{.RealFee}}</td><td class="num">{{.Paid}}</td><td>{{.Operator}}</td></tr>
+{{end}}</table>{{end}}
+<p>打印时间：{{.Printed}}</p>
+</body></html>
+`))
+
+//dailyHTML 打印用的日结报表，打开后自动弹出打印
+func dailyHTML(r *DailyReport, now time.Time) ([]byte, error) {
+	var b bytes.Buffer
+	err := dailyTemplate.Execute(&b, map[string]interface{}{
+		"Day":         dayKey(r.Day),
+		"Status":      r.status(),
+		"Closed":      r.Closing != nil,
+		"Lines":       r.lines(),
+		"Visits":      r.Visits,
+		"Payments":    r.Payments,
+		"Adjustments": r.Adjustments,
+		"Printed":     now.Format("2006-01-02 15:04"),
+	})
+	return b.Bytes(), err
+}
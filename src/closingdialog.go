@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+//ClosingDialog 日结报表：选择日期查看当天的登记、收款和欠费，确认日结后锁定这一天；可以打印或导出
+func ClosingDialog(owner walk.Form) (int, error) {
+	var dlg *walk.Dialog
+	var dayDE *walk.DateEdit
+	var statusLabel *walk.Label
+	var noteLE *walk.LineEdit
+	var closePB *walk.PushButton
+	var linesTV, visitsTV, paymentsTV, adjustTV *walk.TableView
+	var report *DailyReport
+
+	refresh := func() {
+		if adjustTV == nil {
+			return
+		}
+		rwLock.RLock()
+		report = model.dailyReport(dayDE.Date())
+		rwLock.RUnlock()
+		_ = statusLabel.SetText(dayKey(report.Day) + " " + report.status())
+		closePB.SetEnabled(report.Closing == nil && dayKey(report.Day) <= dayKey(time.Now()))
+		noteLE.SetEnabled(report.Closing == nil)
+		_ = linesTV.SetModel(report.lines())
+		_ = visitsTV.SetModel(report.Visits)
+		_ = paymentsTV.SetModel(report.Payments)
+		_ = adjustTV.SetModel(report.Adjustments)
+	}
+
+	err := Dialog{
+		AssignTo: &dlg,
+		Title:    "日结",
+		MinSize:  Size{Width: 1000, Height: 700},
+		Layout:   VBox{},
+		Children: []Widget{
+			Composite{
+				Layout: HBox{MarginsZero: true},
+				Children: []Widget{
+					Label{Text: "日期:"},
+					DateEdit{
+						AssignTo:      &dayDE,
+						Date:          time.Now(),
+						OnDateChanged: refresh,
+					},
+					Label{
+						AssignTo: &statusLabel,
+						Font:     labelFont,
+					},
+					HSpacer{},
+					Label{Text: "日结备注:"},
+					LineEdit{
+						AssignTo: &noteLE,
+						MinSize:  Size{Width: 200},
+					},
+				},
+			},
+			Composite{
+				Layout:        HBox{MarginsZero: true},
+				StretchFactor: 1,
+				Children: []Widget{
+					TableView{
+						AssignTo: &linesTV,
+						MinSize:  Size{Width: 380},
+						MaxSize:  Size{Width: 380},
+						Columns: []TableViewColumn{
+							{Name: "Title", Title: "项目", Width: 190},
+							{Name: "Value", Title: "当前", Alignment: AlignFar, Width: 85},
+							{Name: "Closed", Title: "日结时", Alignment: AlignFar, Width: 85},
+						},
+					},
+					TableView{
+						AssignTo: &visitsTV,
+						Columns: []TableViewColumn{
+							{Name: "Create", Title: "登记时间", Format: "15:04", Width: 70},
+							{Name: "Name", Title: "姓名", Width: 80},
+							{Name: "AllFee", Title: "就诊费用", Alignment: AlignFar, Width: 80},
+							{Name: "RealFee", Title: "实收费用", Alignment: AlignFar, Width: 80},
+							{Name: "Paid", Title: "当日已付", Alignment: AlignFar, Width: 80},
+							{Name: "Owed", Title: "欠费", Alignment: AlignFar, Width: 80},
+						},
+					},
+				},
+			},
+			Composite{
+				Layout:        HBox{MarginsZero: true},
+				StretchFactor: 1,
+				Children: []Widget{
+					TableView{
+						AssignTo: &paymentsTV,
+						Columns: []TableViewColumn{
+							{Name: "At", Title: "收款时间", Format: "15:04", Width: 70},
+							{Name: "Name", Title: "姓名", Width: 80},
+							{Name: "Method", Title: "方式", Width: 60},
+							{Name: "Amount", Title: "金额", Alignment: AlignFar, Width: 80},
+							{Name: "Note", Title: "备注", Width: 100},
+						},
+					},
+					TableView{
+						AssignTo: &adjustTV,
+						Columns: []TableViewColumn{
+							{Name: "At", Title: "调整时间", Format: "15:04", Width: 70},
+							{Name: "Day", Title: "日结日期", Format: "2006-01-02", Width: 90},
+							{Name: "Name", Title: "姓名", Width: 70},
+							{Name: "Kind", Title: "类型", Width: 60},
+							{Name: "Reason", Title: "原因", Width: 120},
+							{Name: "RealFee", Title: "实收变动", Alignment: AlignFar, Width: 70},
+							{Name: "Paid", Title: "已付变动", Alignment: AlignFar, Width: 70},
+						},
+					},
+				},
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						AssignTo: &closePB,
+						Text:     "日结",
+						OnClicked: func() {
+							msg := fmt.Sprintf("确定日结 %s 吗？日结后这一天的就诊和收款再修改、删除或作废，都要登记调整原因。", dayKey(report.Day))
+							if walk.MsgBox(dlg, "日结", msg, walk.MsgBoxYesNo|walk.MsgBoxIconQuestion) != walk.DlgCmdYes {
+								return
+							}
+							note := strings.TrimSpace(noteLE.Text())
+							err := model.commit(func(store Store) error {
+								return model.closeDay(store, model.dailyReport(report.Day), note, time.Now())
+							})
+							if err != nil {
+								walk.MsgBox(dlg, "错误", "日结失败："+err.Error(), walk.MsgBoxIconError)
+							}
+							refresh()
+						},
+					},
+					PushButton{
+						Text: "打印",
+						OnClicked: func() {
+							if err := printDaily(report); err != nil {
+								walk.MsgBox(dlg, "错误", "打印失败："+err.Error(), walk.MsgBoxIconError)
+							}
+						},
+					},
+					PushButton{
+						Text: "导出",
+						OnClicked: func() {
+							name := fmt.Sprintf("日结%s.csv", report.Day.Format("20060102"))
+							exportTo(dlg, "导出日结报表", name, dailyHeader(), dailyRecords(report))
+						},
+					},
+					PushButton{
+						Text:      "关闭",
+						OnClicked: func() { dlg.Accept() },
+					},
+				},
+			},
+		},
+	}.Create(owner)
+	if err != nil {
+		return 0, err
+	}
+	cancel := model.watchers.watch(func() {
+		dlg.Synchronize(refresh)
+	})
+	defer cancel()
+	refresh()
+	return dlg.Run(), nil
+}
+
+//printDaily 把报表写到临时目录的网页里，用默认浏览器打开并弹出打印；临时网页不留备份
+func printDaily(r *DailyReport) error {
+	page, err := dailyHTML(r, time.Now())
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("日结%s.html", r.Day.Format("20060102")))
+	err = replaceFile(path, false, func(w io.Writer) error {
+		_, err := w.Write(page)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return exec.Command("rundll32", "url.dll,FileProtocolHandler", path).Start()
+}
+
+//adjustReason 修改已日结的记录前询问原因，取消时返回 false
+func adjustReason(owner walk.Form, message string) (string, bool) {
+	var dlg *walk.Dialog
+	var reasonLE *walk.LineEdit
+	var acceptPB, cancelPB *walk.PushButton
+	var reason string
+	result, err := Dialog{
+		AssignTo:      &dlg,
+		Title:         "调整已日结的记录",
+		DefaultButton: &acceptPB,
+		CancelButton:  &cancelPB,
+		MinSize:       Size{Width: 400, Height: 150},
+		Layout:        VBox{},
+		Children: []Widget{
+			Label{Text: message},
+			Composite{
+				Layout: HBox{MarginsZero: true},
+				Children: []Widget{
+					Label{Text: "调整原因:"},
+					LineEdit{AssignTo: &reasonLE},
+				},
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						AssignTo: &acceptPB,
+						Text:     "确定",
+						OnClicked: func() {
+							reason = strings.TrimSpace(reasonLE.Text())
+							if reason == "" {
+								walk.MsgBox(dlg, "提示", "请填写调整原因", walk.MsgBoxIconInformation)
+								return
+							}
+							dlg.Accept()
+						},
+					},
+					PushButton{
+						AssignTo:  &cancelPB,
+						Text:      "取消",
+						OnClicked: func() { dlg.Cancel() },
+					},
+				},
+			},
+		},
+	}.Run(owner)
+	if err != nil {
+		walk.MsgBox(owner, "错误", err.Error(), walk.MsgBoxIconError)
+		return "", false
+	}
+	return reason, result == walk.DlgCmdOK
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//record 可以按编号保存为 csvFile 中一行的记录
+type record interface {
+	key() string
+	//newKey 为没有编号或编号重复的记录重新分配编号
+	newKey()
+	//values 按 csvFile.columns 的顺序编码
+	values() []string
+}
+
+//csvFile 以一个 CSV 文件为快照，每次修改只追加一条日志，
+//启动时在快照之上重放日志，日志过长时在后台合并成新快照；
+//columns 的第一列是编号
+type csvFile struct {
+	path    string
+	schema  *schema
+	columns []string
+	decode  func(r *rowReader) record
+
+	items   []record
+	byID    map[string]record
+	journal *journal
+	seq     int
+	//batch 不为空时在事务中，日志先记在这里，见 CSVStore.Transact
+	batch *[]batchEntry
+
+	compacting int32
+	wg         sync.WaitGroup
+	//held 载入时有无法识别的数据，用户看到提示之前不合并快照，
+	//以免出错的日志被清掉；dirty 为此推迟的合并
+	held  bool
+	dirty bool
+	//raw 按编号和列号记下载入时无法解析的字段，见 values
+	raw map[string]map[int]rawCell
+}
+
+//rawCell 无法解析的字段的原文，和按空值解析后再编码的结果
+type rawCell struct {
+	text, decoded string
+}
+
+func (f *csvFile) journalPath() string {
+	return strings.TrimSuffix(f.path, filepath.Ext(f.path)) + ".journal"
+}
+
+//load 读取快照并重放日志，重新载入时丢弃内存中的记录
+func (f *csvFile) load() ([]RowError, error) {
+	if f.journal != nil {
+		f.wg.Wait()
+		if err := f.journal.close(); err != nil {
+			return nil, err
+		}
+	}
+	t, err := readTable(f.path, f.schema)
+	if os.IsNotExist(err) {
+		//如果不存在先创建一个空文件
+		t = newTable(f.schema, f.columns)
+		err = writeTable(f.path, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.upgraded {
+		//升级后的数据会在合并时写回，轮换的备份迟早被挤掉，升级前的原样另存一份
+		if err := pinBackup(f.path, fmt.Sprintf("v%d", t.from)); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []RowError
+	f.items = nil
+	f.byID = map[string]record{}
+	f.raw = map[string]map[int]rawCell{}
+	dirty := t.upgraded
+	for index, row := range t.rows {
+		r := &rowReader{t: t, row: row, line: index + 1}
+		item := f.decode(r)
+		errs = append(errs, r.errs...)
+		if _, dup := f.byID[item.key()]; item.key() == "" || dup {
+			item.newKey()
+			dirty = true
+		}
+		f.items = append(f.items, item)
+		f.byID[item.key()] = item
+		f.keepRaw(item, r)
+	}
+	f.seq = snapshotSeq(t)
+
+	j, entries, jerrs, err := readJournal(f.journalPath(), f.seq)
+	if err != nil {
+		return nil, err
+	}
+	errs = append(errs, jerrs...)
+	f.journal = j
+	for _, e := range entries {
+		rerrs, err := f.replay(e)
+		errs = append(errs, rerrs...)
+		if err != nil {
+			errs = append(errs, RowError{Line: e.Seq, Column: "修改日志", Err: err})
+		}
+		f.seq = e.Seq
+	}
+	f.held = len(errs) > 0
+	f.dirty = dirty || len(entries) > 0
+	if f.dirty {
+		//编号等升级结果要尽快落盘，之后的引用才稳定
+		f.compact()
+	}
+	return errs, nil
+}
+
+//keepRaw 记下 r 中无法解析的字段的原文
+func (f *csvFile) keepRaw(item record, r *rowReader) {
+	if len(r.errs) == 0 {
+		return
+	}
+	values := item.values()
+	cells := map[int]rawCell{}
+	for _, e := range r.errs {
+		for i, col := range f.columns {
+			if col == e.Column {
+				cells[i] = rawCell{text: r.str(col), decoded: values[i]}
+			}
+		}
+	}
+	f.raw[item.key()] = cells
+}
+
+//values 按列编码一条记录。载入时无法解析、之后没有改过的字段写回原文，
+//不把按空值解析的结果写进日志和快照；改过的字段不再保留原文
+func (f *csvFile) values(item record) []string {
+	values := item.values()
+	cells, ok := f.raw[item.key()]
+	if !ok {
+		return values
+	}
+	for i, c := range cells {
+		if values[i] == c.decoded {
+			values[i] = c.text
+		} else {
+			delete(cells, i)
+		}
+	}
+	if len(cells) == 0 {
+		delete(f.raw, item.key())
+	}
+	return values
+}
+
+//release 用户已经看到载入时的错误，恢复合并快照，并补上载入时推迟的合并
+func (f *csvFile) release() {
+	f.held = false
+	if f.dirty {
+		f.compact()
+	}
+}
+
+func (f *csvFile) insert(item record) error {
+	if item.key() == "" {
+		item.newKey()
+	}
+	if _, ok := f.byID[item.key()]; ok {
+		return fmt.Errorf("编号 %s 已存在", item.key())
+	}
+	f.items = append(f.items, item)
+	f.byID[item.key()] = item
+	return f.log(opInsert, item)
+}
+
+func (f *csvFile) update(item record) error {
+	if _, ok := f.byID[item.key()]; !ok {
+		return fmt.Errorf("记录 %s 不存在", item.key())
+	}
+	return f.log(opUpdate, item)
+}
+
+//remove 彻底删除一条记录
+func (f *csvFile) remove(id string) error {
+	if err := f.drop(id); err != nil {
+		return err
+	}
+	return f.append(entry{Seq: f.seq + 1, Op: opPurge, ID: id})
+}
+
+func (f *csvFile) drop(id string) error {
+	old, ok := f.byID[id]
+	if !ok {
+		return fmt.Errorf("记录 %s 不存在", id)
+	}
+	for i := range f.items {
+		if f.items[i] == old {
+			f.items = append(f.items[:i], f.items[i+1:]...)
+			break
+		}
+	}
+	delete(f.byID, id)
+	delete(f.raw, id)
+	return nil
+}
+
+func (f *csvFile) close() error {
+	f.wg.Wait()
+	if f.journal == nil {
+		return nil
+	}
+	return f.journal.close()
+}
+
+//log 追加一条日志，累计过多时触发合并
+func (f *csvFile) log(op string, item record) error {
+	e := entry{Seq: f.seq + 1, Op: op, ID: item.key(), Row: map[string]string{}}
+	for i, v := range f.values(item) {
+		e.Row[f.columns[i]] = v
+	}
+	return f.append(e)
+}
+
+func (f *csvFile) append(e entry) error {
+	if f.batch != nil {
+		*f.batch = append(*f.batch, batchEntry{File: f.name(), Entry: e})
+		f.seq = e.Seq
+		return nil
+	}
+	if err := f.journal.append(e); err != nil {
+		return err
+	}
+	f.seq = e.Seq
+	f.maybeCompact()
+	return nil
+}
+
+//maybeCompact 日志累计过多时合并
+func (f *csvFile) maybeCompact() {
+	if f.journal.count() >= compactEvery {
+		f.compact()
+	}
+}
+
+//name 文件名，用于在整批的日志中区分文件
+func (f *csvFile) name() string {
+	return filepath.Base(f.path)
+}
+
+//replay 把一条日志应用到内存中的记录，此时还没有别处持有这些记录；
+//日志中无法解析的字段按空值应用并逐个报告
+func (f *csvFile) replay(e entry) ([]RowError, error) {
+	if e.Op == opInsert {
+		item, errs := f.decodeEntry(e, e.ID)
+		if item.key() == "" {
+			item.newKey()
+		}
+		f.items = append(f.items, item)
+		f.byID[item.key()] = item
+		return errs, nil
+	}
+	if e.Op == opPurge {
+		return nil, f.drop(e.ID)
+	}
+
+	old, ok := f.byID[e.ID]
+	if !ok {
+		return nil, fmt.Errorf("记录 %s 不存在", e.ID)
+	}
+	switch e.Op {
+	case opUpdate:
+		delete(f.raw, old.key())
+		item, errs := f.decodeEntry(e, old.key())
+		for i := range f.items {
+			if f.items[i] == old {
+				f.items[i] = item
+			}
+		}
+		delete(f.byID, old.key())
+		f.byID[item.key()] = item
+		return errs, nil
+	case opDelete:
+		d, ok := old.(interface{ softDelete() })
+		if !ok {
+			return nil, fmt.Errorf("记录 %s 不能删除", e.ID)
+		}
+		d.softDelete()
+	default:
+		return nil, fmt.Errorf("未知的操作 %q", e.Op)
+	}
+	return nil, nil
+}
+
+//decodeEntry 解析日志中的整行，旧日志可能没有编号列，此时使用 id；出错时行号为日志的序号
+func (f *csvFile) decodeEntry(e entry, id string) (record, []RowError) {
+	t := &table{}
+	var row []string
+	for k, v := range e.Row {
+		t.header = append(t.header, k)
+		row = append(row, v)
+	}
+	if t.col(f.columns[0]) < 0 && id != "" {
+		t.header = append(t.header, f.columns[0])
+		row = append(row, id)
+	}
+	r := &rowReader{t: t, row: row, line: e.Seq}
+	item := f.decode(r)
+	f.keepRaw(item, r)
+	for i := range r.errs {
+		r.errs[i].Column = "修改日志 " + r.errs[i].Column
+	}
+	return item, r.errs
+}
+
+//compact 在调用方持锁时取下当前数据，在后台写成新快照并清理日志；
+//事务中内存里有还没写入的修改，不合并
+func (f *csvFile) compact() {
+	if f.held || f.batch != nil || !atomic.CompareAndSwapInt32(&f.compacting, 0, 1) {
+		return
+	}
+	f.dirty = false
+	t := newTable(f.schema, f.columns)
+	for _, item := range f.items {
+		t.rows = append(t.rows, f.values(item))
+	}
+	seq := f.seq
+	t.meta["seq"] = strconv.Itoa(seq)
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer atomic.StoreInt32(&f.compacting, 0)
+		err := writeTable(f.path, t)
+		if err == nil {
+			err = f.journal.trim(seq)
+		}
+		if err != nil {
+			//日志仍然完整，下次再合并
+			log.Println("合并快照失败:", err)
+		}
+	}()
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const data = "data.csv"
+
+//fooColumns 就诊记录文件的表头
+var fooColumns = []string{"编号", "姓名", "电话", "登记时间", "最新时间", "病例诊断", "治疗方案", "就诊费用", "实收费用", "已付费用", "住址", "性别", "年龄", "是否删除", "患者编号", "删除时间", "规范电话"}
+
+//patientColumns 病人档案文件的表头
+var patientColumns = []string{"编号", "姓名", "电话", "性别", "年龄", "住址", "建档时间", "回访状态", "回访备注", "回访时间"}
+
+//paymentColumns 收款记录文件的表头
+var paymentColumns = []string{"编号", "就诊编号", "金额", "收款时间", "方式", "备注", "是否作废"}
+
+//changeColumns 修改记录文件的表头
+var changeColumns = []string{"编号", "就诊编号", "字段", "原值", "新值", "修改时间", "操作人"}
+
+//mergeColumns 档案合并记录文件的表头，就诊编号之间用空格分隔
+var mergeColumns = []string{"编号", "保留档案", "合并档案", "就诊编号", "合并时间", "操作人", "撤销时间"}
+
+//closingColumns 日结记录文件的表头
+var closingColumns = []string{"编号", "日期", "新登记", "新建档", "修改", "就诊费用", "实收费用", "收款", "新增欠费", "备注", "日结时间", "操作人"}
+
+//adjustmentColumns 调整记录文件的表头
+var adjustmentColumns = []string{"编号", "日结日期", "就诊编号", "类型", "原因", "实收变动", "已付变动", "调整时间", "操作人"}
+
+//CSVStore 就诊记录保存在 data.csv，病人档案、收款记录、修改记录、档案合并记录、日结记录、调整记录
+//保存在同目录的 patients.csv、payments.csv、changes.csv、merges.csv、closings.csv、adjustments.csv；
+//batch.journal 为正在写入各文件日志的一个事务，见 Transact
+type CSVStore struct {
+	path        string
+	visits      *csvFile
+	patients    *csvFile
+	payments    *csvFile
+	changes     *csvFile
+	merges      *csvFile
+	closings    *csvFile
+	adjustments *csvFile
+}
+
+func NewCSVStore(path string) *CSVStore {
+	return &CSVStore{
+		path: path,
+		visits: &csvFile{
+			path:    path,
+			schema:  visitSchema,
+			columns: fooColumns,
+			decode:  func(r *rowReader) record { return readFoo(r) },
+		},
+		patients: &csvFile{
+			path:    siblingPath(path, "patients.csv"),
+			schema:  patientSchema,
+			columns: patientColumns,
+			decode:  func(r *rowReader) record { return readPatient(r) },
+		},
+		payments: &csvFile{
+			path:    siblingPath(path, "payments.csv"),
+			schema:  paymentSchema,
+			columns: paymentColumns,
+			decode:  func(r *rowReader) record { return readPayment(r) },
+		},
+		changes: &csvFile{
+			path:    siblingPath(path, "changes.csv"),
+			schema:  changeSchema,
+			columns: changeColumns,
+			decode:  func(r *rowReader) record { return readChange(r) },
+		},
+		merges: &csvFile{
+			path:    siblingPath(path, "merges.csv"),
+			schema:  mergeSchema,
+			columns: mergeColumns,
+			decode:  func(r *rowReader) record { return readMerge(r) },
+		},
+		closings: &csvFile{
+			path:    siblingPath(path, "closings.csv"),
+			schema:  closingSchema,
+			columns: closingColumns,
+			decode:  func(r *rowReader) record { return readClosing(r) },
+		},
+		adjustments: &csvFile{
+			path:    siblingPath(path, "adjustments.csv"),
+			schema:  adjustmentSchema,
+			columns: adjustmentColumns,
+			decode:  func(r *rowReader) record { return readAdjustment(r) },
+		},
+	}
+}
+
+func (s *CSVStore) files() []*csvFile {
+	return []*csvFile{s.visits, s.patients, s.payments, s.changes, s.merges, s.closings, s.adjustments}
+}
+
+func (s *CSVStore) Load() (*Dataset, []RowError, error) {
+	var errs []RowError
+	for _, f := range s.files() {
+		ferrs, err := f.load()
+		if err != nil {
+			return nil, nil, err
+		}
+		errs = append(errs, ferrs...)
+	}
+	rerrs, err := s.recover()
+	if err != nil {
+		return nil, nil, err
+	}
+	errs = append(errs, rerrs...)
+
+	ds := new(Dataset)
+	for _, item := range s.visits.items {
+		ds.Visits = append(ds.Visits, item.(*Foo))
+	}
+	for _, item := range s.patients.items {
+		ds.Patients = append(ds.Patients, item.(*Patient))
+	}
+	for _, item := range s.payments.items {
+		ds.Payments = append(ds.Payments, item.(*Payment))
+	}
+	for _, item := range s.changes.items {
+		ds.Changes = append(ds.Changes, item.(*Change))
+	}
+	for _, item := range s.merges.items {
+		ds.Merges = append(ds.Merges, item.(*Merge))
+	}
+	for _, item := range s.closings.items {
+		ds.Closings = append(ds.Closings, item.(*Closing))
+	}
+	for _, item := range s.adjustments.items {
+		ds.Adjustments = append(ds.Adjustments, item.(*Adjustment))
+	}
+	return ds, errs, nil
+}
+
+//release 用户已经看到载入时的错误，见 csvFile.held
+func (s *CSVStore) release() {
+	for _, f := range s.files() {
+		f.release()
+	}
+}
+
+func (s *CSVStore) Insert(foo *Foo) error {
+	return s.visits.insert(foo)
+}
+
+func (s *CSVStore) Update(foo *Foo) error {
+	return s.visits.update(foo)
+}
+
+func (s *CSVStore) Delete(foo *Foo) error {
+	foo.softDelete()
+	return s.visits.update(foo)
+}
+
+//Purge 彻底删除一条就诊记录及其收款
+func (s *CSVStore) Purge(foo *Foo) error {
+	for _, item := range append([]record{}, s.payments.items...) {
+		if p := item.(*Payment); p.VisitID == foo.ID {
+			if err := s.payments.remove(p.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return s.visits.remove(foo.ID)
+}
+
+//Query 在内存中逐条过滤，返回的记录与 Load 的相同
+func (s *CSVStore) Query(q VisitQuery) ([]*Foo, error) {
+	var foos []*Foo
+	for _, item := range s.visits.items {
+		if foo := item.(*Foo); q.match(foo) {
+			foos = append(foos, foo)
+		}
+	}
+	sort.SliceStable(foos, func(i, j int) bool {
+		return foos[i].Create.Before(foos[j].Create)
+	})
+	return foos, nil
+}
+
+func (s *CSVStore) InsertPatient(p *Patient) error {
+	return s.patients.insert(p)
+}
+
+func (s *CSVStore) UpdatePatient(p *Patient) error {
+	return s.patients.update(p)
+}
+
+func (s *CSVStore) InsertPayment(p *Payment) error {
+	return s.payments.insert(p)
+}
+
+func (s *CSVStore) UpdatePayment(p *Payment) error {
+	return s.payments.update(p)
+}
+
+func (s *CSVStore) InsertChange(c *Change) error {
+	return s.changes.insert(c)
+}
+
+func (s *CSVStore) InsertMerge(g *Merge) error {
+	return s.merges.insert(g)
+}
+
+func (s *CSVStore) UpdateMerge(g *Merge) error {
+	return s.merges.update(g)
+}
+
+func (s *CSVStore) InsertClosing(c *Closing) error {
+	return s.closings.insert(c)
+}
+
+func (s *CSVStore) InsertAdjustment(a *Adjustment) error {
+	return s.adjustments.insert(a)
+}
+
+//Transact 事务中各文件的日志先记在内存里，fn 成功后整批写入 batch.journal 并落盘，
+//再追加到各文件的日志；追加到一半中断时，下次载入由 recover 补齐。
+//fn 失败时什么也不写，但内存中的记录可能已经改了，调用方要重新载入
+func (s *CSVStore) Transact(fn func(store Store) error) error {
+	if s.visits.batch != nil {
+		return fn(s)
+	}
+	var batch []batchEntry
+	for _, f := range s.files() {
+		f.batch = &batch
+	}
+	err := fn(s)
+	for _, f := range s.files() {
+		f.batch = nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.flush(batch)
+}
+
+func (s *CSVStore) batchPath() string {
+	return siblingPath(s.path, "batch.journal")
+}
+
+//file 按文件名查找，见 csvFile.name
+func (s *CSVStore) file(name string) *csvFile {
+	for _, f := range s.files() {
+		if f.name() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+//flush 写入一个事务的日志；只有一条时直接追加，不需要 batch.journal
+func (s *CSVStore) flush(batch []batchEntry) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if len(batch) > 1 {
+		err := replaceFile(s.batchPath(), false, func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(batch)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	//batch.journal 已经落盘，各文件的日志每个只需落盘一次
+	entries := map[string][]entry{}
+	for _, b := range batch {
+		entries[b.File] = append(entries[b.File], b.Entry)
+	}
+	for _, f := range s.files() {
+		if es := entries[f.name()]; len(es) > 0 {
+			if err := f.journal.appendAll(es); err != nil {
+				return err
+			}
+		}
+	}
+	if len(batch) > 1 {
+		if err := os.Remove(s.batchPath()); err != nil {
+			return err
+		}
+	}
+	for _, f := range s.files() {
+		f.maybeCompact()
+	}
+	return nil
+}
+
+//recover 上次写入事务时中断：batch.journal 已经落盘，把各文件日志中还没有的条目补上
+func (s *CSVStore) recover() ([]RowError, error) {
+	buf, err := os.ReadFile(s.batchPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var batch []batchEntry
+	if err := json.Unmarshal(buf, &batch); err != nil {
+		return nil, fmt.Errorf("%s 已损坏: %v", s.batchPath(), err)
+	}
+	var errs []RowError
+	for _, b := range batch {
+		f := s.file(b.File)
+		if f == nil {
+			return nil, fmt.Errorf("%s 中有未知的文件 %s", s.batchPath(), b.File)
+		}
+		if b.Entry.Seq <= f.seq {
+			continue
+		}
+		rerrs, err := f.replay(b.Entry)
+		if err != nil {
+			rerrs = append(rerrs, RowError{Line: b.Entry.Seq, Column: "修改日志", Err: err})
+		}
+		if len(rerrs) > 0 {
+			errs = append(errs, rerrs...)
+			f.held = true
+		}
+		if err := f.journal.append(b.Entry); err != nil {
+			return nil, err
+		}
+		f.seq = b.Entry.Seq
+	}
+	return errs, os.Remove(s.batchPath())
+}
+
+func (s *CSVStore) Close() error {
+	var err error
+	for _, f := range s.files() {
+		if ferr := f.close(); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+func (foo *Foo) key() string {
+	return foo.ID
+}
+
+func (foo *Foo) newKey() {
+	foo.ID = newID(foo.Create)
+}
+
+func (foo *Foo) softDelete() {
+	foo.Deleted = true
+	if foo.DeletedAt.IsZero() {
+		foo.DeletedAt = time.Now()
+	}
+}
+
+//values 按 fooColumns 的顺序编码一条记录
+func (foo *Foo) values() []string {
+	var del string
+	if foo.Deleted {
+		del = "1"
+	} else {
+		del = "0"
+	}
+	return []string{
+		foo.ID,
+		foo.Name,
+		foo.Phone,
+		foo.Create.Format("2006-01-02 15:04:05"),
+		foo.Update.Format("2006-01-02 15:04:05"),
+		foo.Diagnosed,
+		foo.Program,
+		foo.AllFee.String(),
+		foo.RealFee.String(),
+		foo.PaidFee.String(),
+		foo.Address, string(foo.Sex), strconv.Itoa(foo.Age),
+		del,
+		foo.PatientID,
+		formatTime(foo.DeletedAt),
+		foo.PhoneNorm,
+	}
+}
+
+//readFoo 按列名解析一条记录
+func readFoo(r *rowReader) *Foo {
+	return &Foo{
+		ID:        r.str("编号"),
+		Name:      r.str("姓名"),
+		Phone:     r.str("电话"),
+		Create:    r.date("登记时间"),
+		Update:    r.date("最新时间"),
+		Diagnosed: r.str("病例诊断"),
+		Program:   r.str("治疗方案"),
+		AllFee:    r.money("就诊费用"),
+		RealFee:   r.money("实收费用"),
+		PaidFee:   r.money("已付费用"),
+		Address:   r.str("住址"),
+		Sex:       Sex(r.str("性别")),
+		Age:       r.integer("年龄"),
+		Deleted:   r.boolean("是否删除"),
+		PatientID: r.str("患者编号"),
+		DeletedAt: r.date("删除时间"),
+		PhoneNorm: r.str("规范电话"),
+	}
+}
+
+func (p *Patient) key() string {
+	return p.ID
+}
+
+func (p *Patient) newKey() {
+	p.ID = newID(p.Create)
+}
+
+//values 按 patientColumns 的顺序编码
+func (p *Patient) values() []string {
+	return []string{
+		p.ID,
+		p.Name,
+		p.Phone,
+		string(p.Sex),
+		strconv.Itoa(p.Age),
+		p.Address,
+		p.Create.Format("2006-01-02 15:04:05"),
+		p.FollowUp,
+		p.FollowNote,
+		formatTime(p.FollowedAt),
+	}
+}
+
+func readPatient(r *rowReader) *Patient {
+	return &Patient{
+		ID:         r.str("编号"),
+		Name:       r.str("姓名"),
+		Phone:      r.str("电话"),
+		Sex:        Sex(r.str("性别")),
+		Age:        r.integer("年龄"),
+		Address:    r.str("住址"),
+		Create:     r.date("建档时间"),
+		FollowUp:   r.str("回访状态"),
+		FollowNote: r.str("回访备注"),
+		FollowedAt: r.date("回访时间"),
+	}
+}
+
+func (p *Payment) key() string {
+	return p.ID
+}
+
+func (p *Payment) newKey() {
+	p.ID = newID(p.At)
+}
+
+//values 按 paymentColumns 的顺序编码
+func (p *Payment) values() []string {
+	voided := "0"
+	if p.Voided {
+		voided = "1"
+	}
+	return []string{
+		p.ID,
+		p.VisitID,
+		p.Amount.String(),
+		p.At.Format("2006-01-02 15:04:05"),
+		p.Method,
+		p.Note,
+		voided,
+	}
+}
+
+func readPayment(r *rowReader) *Payment {
+	return &Payment{
+		ID:      r.str("编号"),
+		VisitID: r.str("就诊编号"),
+		Amount:  r.money("金额"),
+		At:      r.date("收款时间"),
+		Method:  r.str("方式"),
+		Note:    r.str("备注"),
+		Voided:  r.boolean("是否作废"),
+	}
+}
+
+func (c *Change) key() string {
+	return c.ID
+}
+
+func (c *Change) newKey() {
+	c.ID = newID(c.At)
+}
+
+//values 按 changeColumns 的顺序编码
+func (c *Change) values() []string {
+	return []string{
+		c.ID,
+		c.VisitID,
+		c.Field,
+		c.Old,
+		c.New,
+		c.At.Format("2006-01-02 15:04:05"),
+		c.Operator,
+	}
+}
+
+func readChange(r *rowReader) *Change {
+	return &Change{
+		ID:       r.str("编号"),
+		VisitID:  r.str("就诊编号"),
+		Field:    r.str("字段"),
+		Old:      r.str("原值"),
+		New:      r.str("新值"),
+		At:       r.date("修改时间"),
+		Operator: r.str("操作人"),
+	}
+}
+
+func (g *Merge) key() string {
+	return g.ID
+}
+
+func (g *Merge) newKey() {
+	g.ID = newID(g.At)
+}
+
+//values 按 mergeColumns 的顺序编码
+func (g *Merge) values() []string {
+	return []string{
+		g.ID,
+		g.KeepID,
+		g.MergedID,
+		strings.Join(g.VisitIDs, " "),
+		g.At.Format("2006-01-02 15:04:05"),
+		g.Operator,
+		formatTime(g.UndoneAt),
+	}
+}
+
+func readMerge(r *rowReader) *Merge {
+	return &Merge{
+		ID:       r.str("编号"),
+		KeepID:   r.str("保留档案"),
+		MergedID: r.str("合并档案"),
+		VisitIDs: strings.Fields(r.str("就诊编号")),
+		At:       r.date("合并时间"),
+		Operator: r.str("操作人"),
+		UndoneAt: r.date("撤销时间"),
+	}
+}
+
+func (c *Closing) key() string {
+	return c.ID
+}
+
+func (c *Closing) newKey() {
+	c.ID = newID(c.At)
+}
+
+//values 按 closingColumns 的顺序编码
+func (c *Closing) values() []string {
+	return []string{
+		c.ID,
+		c.Day.Format("2006-01-02 15:04:05"),
+		strconv.Itoa(c.Registered),
+		strconv.Itoa(c.NewPatients),
+		strconv.Itoa(c.Edited),
+		c.AllFee.String(),
+		c.RealFee.String(),
+		c.Collected.String(),
+		c.Receivable.String(),
+		c.Note,
+		c.At.Format("2006-01-02 15:04:05"),
+		c.Operator,
+	}
+}
+
+func readClosing(r *rowReader) *Closing {
+	return &Closing{
+		ID:          r.str("编号"),
+		Day:         r.date("日期"),
+		Registered:  r.integer("新登记"),
+		NewPatients: r.integer("新建档"),
+		Edited:      r.integer("修改"),
+		AllFee:      r.money("就诊费用"),
+		RealFee:     r.money("实收费用"),
+		Collected:   r.money("收款"),
+		Receivable:  r.money("新增欠费"),
+		Note:        r.str("备注"),
+		At:          r.date("日结时间"),
+		Operator:    r.str("操作人"),
+	}
+}
+
+func (a *Adjustment) key() string {
+	return a.ID
+}
+
+func (a *Adjustment) newKey() {
+	a.ID = newID(a.At)
+}
+
+//values 按 adjustmentColumns 的顺序编码
+func (a *Adjustment) values() []string {
+	return []string{
+		a.ID,
+		a.Day.Format("2006-01-02 15:04:05"),
+		a.VisitID,
+		a.Kind,
+		a.Reason,
+		a.RealFee.String(),
+		a.Paid.String(),
+		a.At.Format("2006-01-02 15:04:05"),
+		a.Operator,
+	}
+}
+
+func readAdjustment(r *rowReader) *Adjustment {
+	return &Adjustment{
+		ID:       r.str("编号"),
+		Day:      r.date("日结日期"),
+		VisitID:  r.str("就诊编号"),
+		Kind:     r.str("类型"),
+		Reason:   r.str("原因"),
+		RealFee:  r.money("实收变动"),
+		Paid:     r.money("已付变动"),
+		At:       r.date("调整时间"),
+		Operator: r.str("操作人"),
+	}
+}
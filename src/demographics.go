@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gaoyangtok/medic/src/stats"
+)
+
+//AgeBand 年龄段 [Min, Max]，Max 为 0 表示 Min 岁以上
+type AgeBand struct {
+	Min, Max int
+}
+
+func (b AgeBand) String() string {
+	if b.Max == 0 {
+		return fmt.Sprintf("%d岁以上", b.Min)
+	}
+	return fmt.Sprintf("%d-%d岁", b.Min, b.Max)
+}
+
+//defaultAgeBands 默认的年龄段分界
+const defaultAgeBands = "7,18,30,45,60,75"
+
+var errAgeBands = errors.New("年龄段的分界应为从小到大的整数，如 7,18,30,45,60,75")
+
+//parseAgeBands 由分界的年龄生成年龄段，如 "18,60" 为 0-17、18-59、60 岁以上；分界可以用逗号或空格分隔
+func parseAgeBands(s string) ([]AgeBand, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '，' || r == '、' || r == ' '
+	})
+	bands := []AgeBand{{}}
+	for _, f := range fields {
+		n, err := strconv.Atoi(f)
+		last := &bands[len(bands)-1]
+		if err != nil || n <= last.Min || n > 150 {
+			return nil, errAgeBands
+		}
+		last.Max = n - 1
+		bands = append(bands, AgeBand{Min: n})
+	}
+	if len(bands) < 2 {
+		return nil, errAgeBands
+	}
+	return bands, nil
+}
+
+//formatAgeBands 年龄段的分界，parseAgeBands 的逆运算
+func formatAgeBands(bands []AgeBand) string {
+	bounds := make([]string, 0, len(bands))
+	for _, b := range bands[1:] {
+		bounds = append(bounds, strconv.Itoa(b.Min))
+	}
+	return strings.Join(bounds, ",")
+}
+
+//ageBand 年龄所在的年龄段；年龄为 0 视为没有填写，返回 -1
+func ageBand(bands []AgeBand, age int) int {
+	if age <= 0 {
+		return -1
+	}
+	for i, b := range bands {
+		if b.Max == 0 || age <= b.Max {
+			return i
+		}
+	}
+	return len(bands) - 1
+}
+
+//DemoCell 一个年龄段、一种性别的病人数、就诊次数和收入
+type DemoCell struct {
+	Patients int
+	Visits   int
+	Billed   Money
+	//Collected 期间收到的款项，包括期间之前就诊的欠费
+	Collected Money
+	visits    []stats.Visit
+}
+
+//DemoRow 表格中的一行：一个年龄段，或年龄未填、合计
+type DemoRow struct {
+	Band string
+	//Man、Woman 为男、女，Total 还包括性别未填的
+	Man, Woman, Total DemoCell
+}
+
+func (r *DemoRow) cell(sex Sex) *DemoCell {
+	switch sex {
+	case SexMan:
+		return &r.Man
+	case SexWoman:
+		return &r.Woman
+	}
+	return nil
+}
+
+//demographics 按年龄段和性别汇总 [from, to) 的就诊：年龄、性别按就诊当时的记录；
+//一个病人只计一次，计在期间最后一次就诊所在的格子里。结果依次为各年龄段、年龄未填和合计。需持有读锁
+func (m *FooModel) demographics(bands []AgeBand, from, to time.Time) []*DemoRow {
+	rows := make([]*DemoRow, len(bands)+2)
+	for i, b := range bands {
+		rows[i] = &DemoRow{Band: b.String()}
+	}
+	unknown, total := len(bands), len(bands)+1
+	rows[unknown] = &DemoRow{Band: "年龄未填"}
+	rows[total] = &DemoRow{Band: "合计"}
+
+	//latest 每个病人期间最后一次就诊
+	latest := map[string]*Foo{}
+	for _, item := range m.items {
+		if item.Deleted {
+			continue
+		}
+		row := rows[unknown]
+		if i := ageBand(bands, item.Age); i >= 0 {
+			row = rows[i]
+		}
+		v := statsVisit(item)
+		row.Total.visits = append(row.Total.visits, v)
+		if c := row.cell(item.Sex); c != nil {
+			c.visits = append(c.visits, v)
+		}
+		if v.At.Before(from) || !v.At.Before(to) {
+			continue
+		}
+		key := item.PatientID
+		if key == "" {
+			key = patientKey(item.Name, item.Phone)
+		}
+		if last, ok := latest[key]; !ok || item.Create.After(last.Create) {
+			latest[key] = item
+		}
+	}
+	for _, item := range latest {
+		row := rows[unknown]
+		if i := ageBand(bands, item.Age); i >= 0 {
+			row = rows[i]
+		}
+		row.Total.Patients++
+		if c := row.cell(item.Sex); c != nil {
+			c.Patients++
+		}
+	}
+
+	for _, r := range rows[:total] {
+		for _, c := range []*DemoCell{&r.Man, &r.Woman, &r.Total} {
+			c.sum(from, to)
+		}
+		rows[total].Man.add(r.Man)
+		rows[total].Woman.add(r.Woman)
+		rows[total].Total.add(r.Total)
+	}
+	return rows
+}
+
+func (c *DemoCell) sum(from, to time.Time) {
+	b := stats.Sum(c.visits, from, to)
+	c.Visits, c.Billed, c.Collected = b.Visits, Money(b.Billed), Money(b.Collected)
+	c.visits = nil
+}
+
+func (c *DemoCell) add(o DemoCell) {
+	c.Patients += o.Patients
+	c.Visits += o.Visits
+	c.Billed += o.Billed
+	c.Collected += o.Collected
+}
+
+//demoMetrics 人口金字塔可以显示的数字
+var demoMetrics = []struct {
+	Title string
+	Value func(c DemoCell) int64
+	Count bool
+}{
+	{"病人数", func(c DemoCell) int64 { return int64(c.Patients) }, true},
+	{"就诊次数", func(c DemoCell) int64 { return int64(c.Visits) }, true},
+	{"应收", func(c DemoCell) int64 { return int64(c.Billed) }, false},
+	{"已收", func(c DemoCell) int64 { return int64(c.Collected) }, false},
+}
+
+func demoMetricTitles() []string {
+	titles := make([]string, len(demoMetrics))
+	for i, c := range demoMetrics {
+		titles[i] = c.Title
+	}
+	return titles
+}
+
+//demoHeader、demoRecords 导出的年龄性别分布
+func demoHeader() []string {
+	header := []string{"年龄段"}
+	for _, title := range []string{"男", "女", "合计"} {
+		for _, c := range demoMetrics {
+			header = append(header, title+c.Title)
+		}
+	}
+	return header
+}
+
+func demoRecords(rows []*DemoRow) [][]string {
+	records := make([][]string, 0, len(rows))
+	for _, r := range rows {
+		record := []string{r.Band}
+		for _, c := range []DemoCell{r.Man, r.Woman, r.Total} {
+			record = append(record, strconv.Itoa(c.Patients), strconv.Itoa(c.Visits), c.Billed.String(), c.Collected.String())
+		}
+		records = append(records, record)
+	}
+	return records
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+//DemographicsDialog 按年龄段和性别统计病人数、就诊次数和收入，下方画人口金字塔；日期范围沿用统计窗口的选项
+func DemographicsDialog(owner walk.Form, o chartOptions) (int, error) {
+	var dlg *walk.Dialog
+	var bandsLE *walk.LineEdit
+	var metricCB *walk.ComboBox
+	var summaryLabel *walk.Label
+	var tv *walk.TableView
+	var chart *walk.CustomWidget
+	var rows []*DemoRow
+	from, to := o.span()
+	bands := loadAgeBands()
+
+	refresh := func() {
+		if chart == nil {
+			return
+		}
+		rwLock.RLock()
+		rows = model.demographics(bands, from, to)
+		rwLock.RUnlock()
+		_ = tv.SetModel(rows)
+
+		total := rows[len(rows)-1]
+		text := fmt.Sprintf("%s 至 %s 共 %d 人（男 %d、女 %d），就诊 %d 次，已收 %s 元",
+			from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02"),
+			total.Total.Patients, total.Man.Patients, total.Woman.Patients, total.Total.Visits, total.Total.Collected)
+		if n := rows[len(rows)-2].Total.Patients; n > 0 {
+			text += fmt.Sprintf("；%d 人年龄未填，不在金字塔中", n)
+		}
+		if n := total.Total.Patients - total.Man.Patients - total.Woman.Patients; n > 0 {
+			text += fmt.Sprintf("；%d 人性别未填，只计入合计", n)
+		}
+		_ = summaryLabel.SetText(text)
+		_ = chart.Invalidate()
+	}
+
+	columns := []TableViewColumn{{Name: "Band", Title: "年龄段", Width: 80}}
+	for _, sex := range []struct{ Field, Title string }{{"Man", "男"}, {"Woman", "女"}, {"Total", "合计"}} {
+		columns = append(columns,
+			TableViewColumn{Name: sex.Field + ".Patients", Title: sex.Title + "人数", Alignment: AlignFar, Width: 60},
+			TableViewColumn{Name: sex.Field + ".Visits", Title: sex.Title + "次数", Alignment: AlignFar, Width: 60},
+			TableViewColumn{Name: sex.Field + ".Billed", Title: sex.Title + "应收", Alignment: AlignFar, Width: 80},
+			TableViewColumn{Name: sex.Field + ".Collected", Title: sex.Title + "已收", Alignment: AlignFar, Width: 80},
+		)
+	}
+
+	err := Dialog{
+		AssignTo: &dlg,
+		Title:    "病人年龄性别分布",
+		MinSize:  Size{Width: 1000, Height: 650},
+		Layout:   VBox{},
+		Children: []Widget{
+			Composite{
+				Layout: HBox{MarginsZero: true},
+				Children: []Widget{
+					Label{Text: "年龄段分界:"},
+					LineEdit{
+						AssignTo:    &bandsLE,
+						Text:        formatAgeBands(bands),
+						ToolTipText: "如 18,60 分为 0-17 岁、18-59 岁和 60 岁以上",
+						MinSize:     Size{Width: 200},
+					},
+					PushButton{
+						Text: "应用",
+						OnClicked: func() {
+							parsed, err := parseAgeBands(bandsLE.Text())
+							if err != nil {
+								walk.MsgBox(dlg, "错误", err.Error(), walk.MsgBoxIconError)
+								return
+							}
+							bands = parsed
+							if err := saveAgeBands(bands); err != nil {
+								walk.MsgBox(dlg, "错误", "保存失败："+err.Error(), walk.MsgBoxIconError)
+							}
+							_ = bandsLE.SetText(formatAgeBands(bands))
+							refresh()
+						},
+					},
+					Label{Text: "金字塔:"},
+					ComboBox{
+						AssignTo:     &metricCB,
+						Model:        demoMetricTitles(),
+						CurrentIndex: 0,
+						OnCurrentIndexChanged: func() {
+							if chart != nil {
+								_ = chart.Invalidate()
+							}
+						},
+					},
+					HSpacer{},
+				},
+			},
+			Label{
+				AssignTo: &summaryLabel,
+				Font:     labelFont,
+			},
+			TableView{
+				AssignTo:         &tv,
+				ColumnsOrderable: true,
+				StretchFactor:    1,
+				Columns:          columns,
+			},
+			CustomWidget{
+				AssignTo:            &chart,
+				ClearsBackground:    true,
+				InvalidatesOnResize: true,
+				StretchFactor:       1,
+				MinSize:             Size{Height: 250},
+				Paint: func(canvas *walk.Canvas, updateBounds walk.Rectangle) error {
+					if len(rows) < 2 {
+						return nil
+					}
+					//不画年龄未填和合计两行
+					return paintPyramid(canvas, chart.ClientBounds(), rows[:len(rows)-2], metricCB.CurrentIndex())
+				},
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						Text: "导出",
+						OnClicked: func() {
+							name := fmt.Sprintf("年龄性别分布%s-%s.csv", from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+							exportTo(dlg, "导出年龄性别分布", name, demoHeader(), demoRecords(rows))
+						},
+					},
+					PushButton{
+						Text:      "关闭",
+						OnClicked: func() { dlg.Accept() },
+					},
+				},
+			},
+		},
+	}.Create(owner)
+	if err != nil {
+		return 0, err
+	}
+	cancel := model.watchers.watch(func() {
+		dlg.Synchronize(refresh)
+	})
+	defer cancel()
+	refresh()
+	return dlg.Run(), nil
+}
+
+//pyramidLabel 金字塔中间年龄段名称的宽度；pyramidValue 条形末端数值的宽度
+const (
+	pyramidLabel = 90
+	pyramidValue = 70
+)
+
+//paintPyramid 人口金字塔：每个年龄段一行，年轻的在下面，男在左、女在右，显示第 metric 个数字
+func paintPyramid(canvas *walk.Canvas, bounds walk.Rectangle, rows []*DemoRow, metric int) error {
+	m := demoMetrics[metric]
+	half := (bounds.Width-pyramidLabel)/2 - pyramidValue - 10
+	height := bounds.Height - chartTop - 10
+	if half < 50 || height < 20 || len(rows) == 0 {
+		return nil
+	}
+	var max int64
+	for _, r := range rows {
+		for _, v := range []int64{m.Value(r.Man), m.Value(r.Woman)} {
+			if v > max {
+				max = v
+			}
+		}
+	}
+	unit := int64(Yuan)
+	if m.Count {
+		unit = 1
+	}
+	top := axisTop(max, unit)
+	center := bounds.Width / 2
+	left, right := center-pyramidLabel/2, center+pyramidLabel/2
+	rowHeight := height / len(rows)
+	black := walk.RGB(0, 0, 0)
+
+	value := func(v int64) string {
+		if m.Count {
+			return fmt.Sprint(v)
+		}
+		return strings.TrimSuffix(Money(v).String(), ".00")
+	}
+
+	for _, sex := range []struct {
+		Title string
+		Color walk.Color
+		X     int
+	}{{"男", chartColors[0], left - half}, {"女", chartColors[3], right + half - legendWidth}} {
+		if err := drawLegend(canvas, sex.Title+" "+m.Title, sex.Color, sex.X); err != nil {
+			return err
+		}
+	}
+
+	male, err := walk.NewSolidColorBrush(chartColors[0])
+	if err != nil {
+		return err
+	}
+	defer male.Dispose()
+	female, err := walk.NewSolidColorBrush(chartColors[3])
+	if err != nil {
+		return err
+	}
+	defer female.Dispose()
+
+	for i, r := range rows {
+		//第一个年龄段在最下面
+		y := chartTop + (len(rows)-1-i)*rowHeight
+		bar := rowHeight * 7 / 10
+		barY := y + (rowHeight-bar)/2
+		if err := canvas.DrawText(r.Band, font, black, walk.Rectangle{
+			X: left, Y: y, Width: pyramidLabel, Height: rowHeight,
+		}, walk.TextCenter|walk.TextVCenter|walk.TextSingleLine); err != nil {
+			return err
+		}
+
+		mv, fv := m.Value(r.Man), m.Value(r.Woman)
+		mw, fw := int(mv*int64(half)/top), int(fv*int64(half)/top)
+		if err := canvas.FillRectangle(male, walk.Rectangle{X: left - mw, Y: barY, Width: mw, Height: bar}); err != nil {
+			return err
+		}
+		if err := canvas.FillRectangle(female, walk.Rectangle{X: right, Y: barY, Width: fw, Height: bar}); err != nil {
+			return err
+		}
+		if err := canvas.DrawText(value(mv), font, black, walk.Rectangle{
+			X: left - mw - pyramidValue - 4, Y: y, Width: pyramidValue, Height: rowHeight,
+		}, walk.TextRight|walk.TextVCenter|walk.TextSingleLine); err != nil {
+			return err
+		}
+		if err := canvas.DrawText(value(fv), font, black, walk.Rectangle{
+			X: right + fw + 4, Y: y, Width: pyramidValue, Height: rowHeight,
+		}, walk.TextLeft|walk.TextVCenter|walk.TextSingleLine); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+//exportCSV 导出给 Excel 打开的表格：UTF-8 带 BOM，否则中文会乱码；
+//导出的文件随时可以重新生成，覆盖时不在 backup 中留副本
+func exportCSV(path string, header []string, rows [][]string) error {
+	return replaceFile(path, false, func(w io.Writer) error {
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+		write := csv.NewWriter(w)
+		if err := write.Write(header); err != nil {
+			return err
+		}
+		return write.WriteAll(rows)
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/lxn/walk"
+)
+
+//exportTo 选择导出文件后写入，name 为默认文件名；取消时什么也不做
+func exportTo(owner walk.Form, title, name string, header []string, rows [][]string) {
+	dlg := &walk.FileDialog{
+		Title:    title,
+		Filter:   "CSV 表格 (*.csv)|*.csv",
+		FilePath: name,
+	}
+	if ok, err := dlg.ShowSave(owner); err != nil || !ok {
+		return
+	}
+	path := dlg.FilePath
+	if filepath.Ext(path) == "" {
+		path += ".csv"
+	}
+	if err := exportCSV(path, header, rows); err != nil {
+		walk.MsgBox(owner, "错误", "导出失败："+err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	walk.MsgBox(owner, "完成", "已导出到 "+path, walk.MsgBoxIconInformation)
+}
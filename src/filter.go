@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gaoyangtok/medic/src/pinyin"
+	"github.com/gaoyangtok/medic/src/query"
+)
+
+//visitFields 查询框中可以使用的字段，英文名和中文名都可以
+var visitFields = query.NewSchema([]string{"name", "phone", "diag", "plan", "addr"},
+	query.Field{Name: "name", Aliases: []string{"姓名"}, Kind: query.Text, Match: pinyin.MatchName},
+	query.Field{Name: "phone", Aliases: []string{"电话"}, Kind: query.Text},
+	query.Field{Name: "sex", Aliases: []string{"性别"}, Kind: query.Enum},
+	query.Field{Name: "age", Aliases: []string{"年龄"}, Kind: query.Number},
+	query.Field{Name: "diag", Aliases: []string{"诊断"}, Kind: query.Text},
+	query.Field{Name: "plan", Aliases: []string{"方案"}, Kind: query.Text},
+	query.Field{Name: "addr", Aliases: []string{"住址"}, Kind: query.Text},
+	query.Field{Name: "fee", Aliases: []string{"诊费"}, Kind: query.Number, Scale: int64(Yuan)},
+	query.Field{Name: "real", Aliases: []string{"实收"}, Kind: query.Number, Scale: int64(Yuan)},
+	query.Field{Name: "paid", Aliases: []string{"已付"}, Kind: query.Number, Scale: int64(Yuan)},
+	query.Field{Name: "owed", Aliases: []string{"欠费"}, Kind: query.Number, Scale: int64(Yuan)},
+	query.Field{Name: "created", Aliases: []string{"登记"}, Kind: query.Date},
+	query.Field{Name: "updated", Aliases: []string{"更新"}, Kind: query.Date},
+)
+
+//visitRecord 让查询语言读取 Foo 的字段
+type visitRecord struct {
+	*Foo
+}
+
+func (r visitRecord) Text(field string) string {
+	switch field {
+	case "name":
+		return r.Name
+	case "phone":
+		return r.Phone
+	case "sex":
+		return string(r.Sex)
+	case "diag":
+		return r.Diagnosed
+	case "plan":
+		return r.Program
+	case "addr":
+		return r.Address
+	}
+	return ""
+}
+
+func (r visitRecord) Number(field string) int64 {
+	switch field {
+	case "age":
+		return int64(r.Age)
+	case "fee":
+		return int64(r.AllFee)
+	case "real":
+		return int64(r.RealFee)
+	case "paid":
+		return int64(r.PaidFee)
+	case "owed":
+		return int64(r.RealFee - r.PaidFee)
+	}
+	return 0
+}
+
+func (r visitRecord) Time(field string) time.Time {
+	switch field {
+	case "created":
+		return r.Create
+	case "updated":
+		return r.Update
+	}
+	return time.Time{}
+}
+
+//matchName 姓名框：包含输入的字，或者按拼音、首字母匹配
+func matchName(name, q string) bool {
+	return strings.Contains(name, q) || pinyin.MatchName(name, q)
+}
+
+//matchPhone 电话框：包含输入的内容，或者忽略空格和横线后包含在规范形式中
+func matchPhone(foo *Foo, q string) bool {
+	if strings.Contains(foo.Phone, q) {
+		return true
+	}
+	digits := phoneSeparators.Replace(q)
+	return allDigits(digits) && strings.Contains(foo.PhoneNorm, digits)
+}
+
+//parse 解析查询框中的条件，出错时保留上一次的条件
+func (s *Search) parse() error {
+	n, err := query.Parse(s.Query, visitFields)
+	if err != nil {
+		return err
+	}
+	s.node = n
+	return nil
+}
+
+//filterVisits 按查询条件筛选未删除的记录，ctx 取消时返回 ctx.Err()；需持有读锁
+func filterVisits(ctx context.Context, items []*Foo, s *Search) ([]*Foo, error) {
+	result := []*Foo{}
+	for i, item := range items {
+		//每隔一段检查一次，不必每条都看
+		if i%1024 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if item.Deleted {
+			continue
+		}
+		if !item.Create.After(s.Start) || !item.Create.Before(s.End) {
+			continue
+		}
+		if matchName(item.Name, s.Name) && matchPhone(item, s.Phone) &&
+			query.Match(s.node, visitRecord{item}) {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
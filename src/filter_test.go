@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gaoyangtok/medic/src/query"
+)
+
+func TestVisitQueryDates(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("CST", 8*3600)
+	defer func() { time.Local = local }()
+
+	//从数据文件读入的时间和新记录一样按本地时间解释
+	create, err := parseTime("2023-03-31 23:30:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	update, err := parseTime("2023-04-01 00:30:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := &Foo{Create: create, Update: update}
+	recent := &Foo{
+		Create: time.Date(2023, 3, 31, 23, 30, 0, 0, time.Local),
+		Update: time.Date(2023, 4, 1, 0, 30, 0, 0, time.Local),
+	}
+	var zero Foo
+
+	tests := []struct {
+		q    string
+		want bool
+	}{
+		{"created:2023-03", true},
+		{"created:2023-03-31", true},
+		{"created:2023-04", false},
+		{"created<2023-04", true},
+		{"created>=2023-04-01", false},
+		{"登记:2023", true},
+		{"updated:2023-04-01", true},
+		{"updated:2023-03", false},
+		{"updated>2023-03-31", true},
+		{"created:2023-03 updated:2023-04", true},
+	}
+	for _, tt := range tests {
+		s := &Search{Query: tt.q}
+		if err := s.parse(); err != nil {
+			t.Errorf("parse(%q): %v", tt.q, err)
+			continue
+		}
+		for _, c := range []struct {
+			name string
+			foo  *Foo
+			want bool
+		}{{"loaded", loaded, tt.want}, {"recent", recent, tt.want}, {"zero", &zero, false}} {
+			if got := query.Match(s.node, visitRecord{c.foo}); got != c.want {
+				t.Errorf("Match(%q, %s) = %v, want %v", tt.q, c.name, got, c.want)
+			}
+		}
+	}
+}
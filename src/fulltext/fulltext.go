@@ -0,0 +1,282 @@
+//Package fulltext 内存中的全文索引：中文按相邻两字（二元组）切词，另外收录单字以便查一个字，
+//英文和数字按词，结果按 BM25 相关度排序并给出高亮的摘要。
+//Index 不是并发安全的，由调用方加锁
+package fulltext
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//Field 被索引的一个字段，Weight 是它在相关度中的权重
+type Field struct {
+	Name   string
+	Weight float64
+}
+
+//token 切出的一个词及其在原文中的位置（按字符计，左闭右开）
+type token struct {
+	term       string
+	start, end int
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r)
+}
+
+//tokenize 中文连续的字切成二元组，单独一个字时保留单字，unigrams 为 true 时每个字都另作一个词；
+//英文字母和数字连成一个词并转为小写；其它字符是分隔符。
+//建索引和标摘要时收录单字，查询时不收，多字的查询只按二元组匹配
+func tokenize(text string, unigrams bool) []token {
+	runes := []rune(strings.ToLower(text))
+	var toks []token
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case isCJK(r):
+			j := i
+			for j < len(runes) && isCJK(runes[j]) {
+				j++
+			}
+			for k := i; k < j; k++ {
+				if unigrams || j-i == 1 {
+					toks = append(toks, token{string(runes[k]), k, k + 1})
+				}
+				if k+1 < j {
+					toks = append(toks, token{string(runes[k : k+2]), k, k + 2})
+				}
+			}
+			i = j
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && !isCJK(runes[j]) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			toks = append(toks, token{string(runes[i:j]), i, j})
+			i = j
+		default:
+			i++
+		}
+	}
+	return toks
+}
+
+//posting 一个词在一篇文档一个字段中出现的次数
+type posting struct {
+	doc   string
+	field int
+	freq  int
+}
+
+type doc struct {
+	texts []string
+	//lens 各字段的词数
+	lens []int
+	//terms 文档包含的词，删除时用来清理倒排表
+	terms map[string]bool
+}
+
+//Index 倒排索引
+type Index struct {
+	fields   []Field
+	docs     map[string]*doc
+	postings map[string][]posting
+	//totalLens 各字段的总词数，用来算平均长度
+	totalLens []int
+}
+
+func New(fields ...Field) *Index {
+	return &Index{
+		fields:    fields,
+		docs:      map[string]*doc{},
+		postings:  map[string][]posting{},
+		totalLens: make([]int, len(fields)),
+	}
+}
+
+//Len 索引中的文档数
+func (ix *Index) Len() int {
+	return len(ix.docs)
+}
+
+//Put 加入或更新一篇文档，texts 与 New 时的字段一一对应
+func (ix *Index) Put(id string, texts ...string) {
+	ix.Remove(id)
+	d := &doc{texts: texts, lens: make([]int, len(ix.fields)), terms: map[string]bool{}}
+	for f := range ix.fields {
+		if f >= len(texts) {
+			break
+		}
+		freqs := map[string]int{}
+		toks := tokenize(texts[f], true)
+		for _, t := range toks {
+			freqs[t.term]++
+		}
+		for term, n := range freqs {
+			ix.postings[term] = append(ix.postings[term], posting{doc: id, field: f, freq: n})
+			d.terms[term] = true
+		}
+		d.lens[f] = len(toks)
+		ix.totalLens[f] += len(toks)
+	}
+	ix.docs[id] = d
+}
+
+//Remove 删除一篇文档，不存在时什么也不做
+func (ix *Index) Remove(id string) {
+	d, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for term := range d.terms {
+		ps := ix.postings[term][:0]
+		for _, p := range ix.postings[term] {
+			if p.doc != id {
+				ps = append(ps, p)
+			}
+		}
+		if len(ps) == 0 {
+			delete(ix.postings, term)
+		} else {
+			ix.postings[term] = ps
+		}
+	}
+	for f, n := range d.lens {
+		ix.totalLens[f] -= n
+	}
+	delete(ix.docs, id)
+}
+
+//Hit 一条查询结果，Snippets 与字段一一对应，没有命中的字段为空
+type Hit struct {
+	ID       string
+	Score    float64
+	Snippets []string
+}
+
+//BM25 的参数
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+//Search 按相关度返回最多 limit 条结果，limit <= 0 时不限；
+//没有包含全部查询词的文档按包含的比例降低相关度
+func (ix *Index) Search(q string, limit int) []Hit {
+	terms := map[string]bool{}
+	for _, t := range tokenize(q, false) {
+		terms[t.term] = true
+	}
+	if len(terms) == 0 || len(ix.docs) == 0 {
+		return nil
+	}
+
+	n := float64(len(ix.docs))
+	scores := map[string]float64{}
+	matched := map[string]int{}
+	for term := range terms {
+		ps := ix.postings[term]
+		docs := map[string]bool{}
+		for _, p := range ps {
+			docs[p.doc] = true
+		}
+		df := float64(len(docs))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range ps {
+			d := ix.docs[p.doc]
+			avg := float64(ix.totalLens[p.field]) / n
+			norm := 1.0
+			if avg > 0 {
+				norm = 1 - b + b*float64(d.lens[p.field])/avg
+			}
+			tf := float64(p.freq)
+			scores[p.doc] += ix.fields[p.field].Weight * idf * tf * (k1 + 1) / (tf + k1*norm)
+		}
+		for id := range docs {
+			matched[id]++
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, s := range scores {
+		cover := float64(matched[id]) / float64(len(terms))
+		hits = append(hits, Hit{ID: id, Score: s * cover * cover})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID > hits[j].ID
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	for i := range hits {
+		d := ix.docs[hits[i].ID]
+		hits[i].Snippets = make([]string, len(ix.fields))
+		for f := range ix.fields {
+			if f < len(d.texts) {
+				hits[i].Snippets[f] = Snippet(d.texts[f], terms, 12)
+			}
+		}
+	}
+	return hits
+}
+
+//Open、Close 摘要中高亮的标记
+const (
+	Open  = "【"
+	Close = "】"
+)
+
+//Snippet 截取第一个命中处前后各 radius 个字，命中的词用【】标出；
+//没有命中时返回空串
+func Snippet(text string, terms map[string]bool, radius int) string {
+	runes := []rune(text)
+	hit := make([]bool, len(runes))
+	first := -1
+	for _, t := range tokenize(text, true) {
+		if terms[t.term] {
+			for i := t.start; i < t.end; i++ {
+				hit[i] = true
+			}
+			if first < 0 {
+				first = t.start
+			}
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+	start, end := first-radius, first+radius*2
+	if start < 0 {
+		start = 0
+	}
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var s strings.Builder
+	if start > 0 {
+		s.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if hit[i] && (i == start || !hit[i-1]) {
+			s.WriteString(Open)
+		}
+		if unicode.IsSpace(runes[i]) {
+			s.WriteRune(' ')
+		} else {
+			s.WriteRune(runes[i])
+		}
+		if hit[i] && (i == end-1 || !hit[i+1]) {
+			s.WriteString(Close)
+		}
+	}
+	if end < len(runes) {
+		s.WriteString("…")
+	}
+	return s.String()
+}
@@ -0,0 +1,142 @@
+package fulltext
+
+import (
+	"strings"
+	"testing"
+)
+
+func terms(toks []token) string {
+	var s []string
+	for _, t := range toks {
+		s = append(s, t.term)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text     string
+		query    string
+		unigrams string
+	}{
+		{"", "", ""},
+		{"痛", "痛", "痛"},
+		{"头痛", "头痛", "头 头痛 痛"},
+		{"头痛发热", "头痛 痛发 发热", "头 头痛 痛 痛发 发 发热 热"},
+		{"腰痛，L4-5", "腰痛 l4 5", "腰 腰痛 痛 l4 5"},
+		{"CT正常", "ct 正常", "ct 正 正常 常"},
+		{"左 膝", "左 膝", "左 膝"},
+	}
+	for _, tt := range tests {
+		if got := terms(tokenize(tt.text, false)); got != tt.query {
+			t.Errorf("tokenize(%q, false) = %q, want %q", tt.text, got, tt.query)
+		}
+		if got := terms(tokenize(tt.text, true)); got != tt.unigrams {
+			t.Errorf("tokenize(%q, true) = %q, want %q", tt.text, got, tt.unigrams)
+		}
+	}
+}
+
+func testIndex() *Index {
+	ix := New(Field{Name: "diag", Weight: 2}, Field{Name: "plan", Weight: 1})
+	ix.Put("a", "头痛发热", "休息")
+	ix.Put("b", "腰痛", "针灸 推拿")
+	ix.Put("c", "发热三天", "退热")
+	ix.Put("d", "颈椎病", "头部牵引")
+	return ix
+}
+
+func ids(hits []Hit) string {
+	var s []string
+	for _, h := range hits {
+		s = append(s, h.ID)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestSearch(t *testing.T) {
+	ix := testIndex()
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"", ""},
+		{"，", ""},
+		{"痛", "b a"},
+		{"头", "a d"},
+		{"热", "c a"},
+		{"头痛", "a"},
+		{"痛头", ""},
+		{"发热", "c a"},
+		{"针灸", "b"},
+		{"推拿 腰痛", "b"},
+		{"头痛 颈椎", "d a"},
+		{"感冒", ""},
+	}
+	for _, tt := range tests {
+		if got := ids(ix.Search(tt.q, 0)); got != tt.want {
+			t.Errorf("Search(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+	if got := ids(ix.Search("痛", 1)); got != "b" {
+		t.Errorf("Search(痛, 1) = %q, want b", got)
+	}
+}
+
+func TestSearchSnippets(t *testing.T) {
+	ix := testIndex()
+	hits := ix.Search("痛", 0)
+	if len(hits) != 2 {
+		t.Fatalf("Search(痛) = %d hits, want 2", len(hits))
+	}
+	if got := hits[1].Snippets; got[0] != "头【痛】发热" || got[1] != "" {
+		t.Errorf("Search(痛) snippets = %q", got)
+	}
+	hits = ix.Search("头痛", 0)
+	if got := hits[0].Snippets[0]; got != "【头痛】发热" {
+		t.Errorf("Search(头痛) snippet = %q", got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	ix := testIndex()
+	ix.Remove("a")
+	ix.Remove("x")
+	if ix.Len() != 3 {
+		t.Errorf("Len = %d, want 3", ix.Len())
+	}
+	if got := ids(ix.Search("痛", 0)); got != "b" {
+		t.Errorf("Search(痛) = %q, want b", got)
+	}
+	ix.Put("b", "头痛", "")
+	if got := ids(ix.Search("腰", 0)); got != "" {
+		t.Errorf("Search(腰) after Put = %q, want none", got)
+	}
+	if got := ids(ix.Search("头", 0)); got != "b d" {
+		t.Errorf("Search(头) after Put = %q, want b d", got)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		text  string
+		terms []string
+		want  string
+	}{
+		{"头痛发热", []string{"发热"}, "头痛【发热】"},
+		{"头痛发热", []string{"痛"}, "头【痛】发热"},
+		{"头痛发热", []string{"头痛", "发热"}, "【头痛发热】"},
+		{"头痛发热", []string{"咳嗽"}, ""},
+		{"一二三四五六七八九十头痛", []string{"头痛"}, "…三四五六七八九十【头痛】"},
+		{"头痛\n发热", []string{"发热"}, "头痛 【发热】"},
+	}
+	for _, tt := range tests {
+		set := map[string]bool{}
+		for _, s := range tt.terms {
+			set[s] = true
+		}
+		if got := Snippet(tt.text, set, 8); got != tt.want {
+			t.Errorf("Snippet(%q, %v) = %q, want %q", tt.text, tt.terms, got, tt.want)
+		}
+	}
+}
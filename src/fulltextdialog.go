@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+//textLimit 全文检索最多列出的条数
+const textLimit = 200
+
+//FullTextDialog 在诊断、治疗方案和住址中检索，按相关度列出，双击可修改
+func FullTextDialog(owner walk.Form) (int, error) {
+	var dlg *walk.Dialog
+	var tv *walk.TableView
+	var qLE *walk.LineEdit
+	var countLabel *walk.Label
+	var hits []*TextHit
+
+	search := func() {
+		rwLock.RLock()
+		hits = model.fullText(qLE.Text(), textLimit)
+		rwLock.RUnlock()
+		_ = tv.SetModel(hits)
+		_ = countLabel.SetText(fmt.Sprintf("共 %d 条", len(hits)))
+	}
+
+	return Dialog{
+		AssignTo: &dlg,
+		Title:    "全文检索",
+		MinSize:  Size{Width: 900, Height: 500},
+		Layout:   VBox{},
+		Children: []Widget{
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					Label{Text: "关键词:", Font: labelFont},
+					LineEdit{
+						AssignTo:    &qLE,
+						ToolTipText: "在病理诊断、治疗方案和住址中查找，如：腰椎间盘 针灸",
+						OnKeyPress: func(key walk.Key) {
+							if key == walk.KeyReturn {
+								search()
+							}
+						},
+					},
+					PushButton{
+						Text:      "检索",
+						OnClicked: search,
+					},
+					Label{AssignTo: &countLabel},
+				},
+			},
+			TableView{
+				AssignTo:         &tv,
+				ColumnsOrderable: true,
+				Columns: []TableViewColumn{
+					{Name: "Name", Title: "姓名", Width: 60},
+					{Name: "Create", Title: "登记时间", Format: "2006-01-02", Width: 90},
+					{Name: "Score", Title: "相关度", Alignment: AlignFar, Precision: 2, Width: 60},
+					{Name: "DiagSnippet", Title: "病理诊断", Width: 220},
+					{Name: "ProgramSnippet", Title: "治疗方案", Width: 220},
+					{Name: "AddressSnippet", Title: "住址", Width: 160},
+				},
+				OnItemActivated: func() {
+					if index := tv.CurrentIndex(); index >= 0 {
+						if cmd, err := AddDialog(dlg, hits[index].Foo); err == nil && cmd == walk.DlgCmdOK {
+							model.ResetRows()
+							search()
+						}
+					}
+				},
+			},
+		},
+	}.Run(owner)
+}
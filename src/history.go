@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+//HistoryDialog 列出一个病人的全部就诊记录，双击可修改
+func HistoryDialog(owner walk.Form, patientID string) (int, error) {
+	rwLock.RLock()
+	p, ok := model.patients[patientID]
+	var patient Patient
+	if ok {
+		patient = *p
+	}
+	rwLock.RUnlock()
+	if !ok {
+		walk.MsgBox(owner, "提示", "这条记录还没有归档到病人档案", walk.MsgBoxIconInformation)
+		return walk.DlgCmdCancel, nil
+	}
+	p = &patient
+
+	var dlg *walk.Dialog
+	var tv *walk.TableView
+	visits, live := historyRows(patientID)
+
+	return Dialog{
+		AssignTo: &dlg,
+		Title:    p.Name + " " + p.Phone + " 的就诊记录",
+		MinSize:  Size{Width: 800, Height: 400},
+		Layout:   VBox{},
+		Children: []Widget{
+			Label{
+				Text: string(p.Sex) + "  " + p.Address,
+				Font: labelFont,
+			},
+			TableView{
+				AssignTo:         &tv,
+				ColumnsOrderable: true,
+				Columns: []TableViewColumn{
+					{Name: "Create", Title: "登记时间", Format: "2006-01-02", Width: 100},
+					{Name: "Age", Title: "年龄", Alignment: AlignFar, Width: 40},
+					{Name: "Diagnosed", Title: "病理诊断", Width: 180},
+					{Name: "Program", Title: "治疗方案", Width: 180},
+					{Name: "AllFee", Title: "诊费", Alignment: AlignFar, Width: 70},
+					{Name: "RealFee", Title: "实收", Alignment: AlignFar, Width: 70},
+					{Name: "PaidFee", Title: "已付", Alignment: AlignFar, Width: 70},
+				},
+				Model: visits,
+				OnItemActivated: func() {
+					if index := tv.CurrentIndex(); index >= 0 {
+						if cmd, err := AddDialog(dlg, live[index]); err == nil && cmd == walk.DlgCmdOK {
+							model.ResetRows()
+							visits, live = historyRows(patientID)
+							_ = tv.SetModel(visits)
+						}
+					}
+				},
+			},
+		},
+	}.Run(owner)
+}
+
+//historyRows 在读锁内取出病人就诊记录的副本供表格显示；live 为对应的模型中的记录，修改时交给 AddDialog
+func historyRows(patientID string) (rows, live []*Foo) {
+	rwLock.RLock()
+	defer rwLock.RUnlock()
+	live = model.visitsOf(patientID)
+	for _, foo := range live {
+		copied := *foo
+		rows = append(rows, &copied)
+	}
+	return rows, live
+}
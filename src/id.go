@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+//crockford ULID 使用的 Base32 字母表，去掉了易混淆的 I L O U
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var idMu sync.Mutex
+var lastMs uint64
+var lastRand [10]byte
+
+//newID 生成 ULID：48 位毫秒时间戳加 80 位随机数，共 26 个字符，
+//字典序即时间顺序；同一毫秒内随机部分递增，保证本进程内不重复且有序
+func newID(t time.Time) string {
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	if t.IsZero() || t.Before(time.Unix(0, 0)) {
+		ms = 0
+	}
+
+	idMu.Lock()
+	defer idMu.Unlock()
+	if ms == lastMs {
+		for i := len(lastRand) - 1; i >= 0; i-- {
+			lastRand[i]++
+			if lastRand[i] != 0 {
+				break
+			}
+		}
+	} else {
+		if _, err := rand.Read(lastRand[:]); err != nil {
+			panic(err)
+		}
+		lastMs = ms
+	}
+
+	var b [16]byte
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> uint(40-8*i))
+	}
+	copy(b[6:], lastRand[:])
+	return encodeID(b)
+}
+
+//encodeID 把 128 位按 5 位一组编码，首字符只占 3 位
+func encodeID(b [16]byte) string {
+	var out [26]byte
+	var acc uint32
+	bits := 2 //128 = 2 + 25*5，最高位补两个 0
+	i := 0
+	for _, c := range b {
+		acc = acc<<8 | uint32(c)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[i] = crockford[(acc>>uint(bits))&31]
+			i++
+		}
+	}
+	return string(out[:])
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+//compactEvery 日志累计多少条后在后台合并为新的快照
+const compactEvery = 200
+
+const (
+	opInsert = "insert"
+	opUpdate = "update"
+	opDelete = "delete"
+	//opPurge 彻底删除，快照中不再保留这条记录
+	opPurge = "purge"
+)
+
+//entry 日志中的一条修改，Row 按列名保存整行，与快照文件的表头一致
+type entry struct {
+	Seq int               `json:"seq"`
+	Op  string            `json:"op"`
+	ID  string            `json:"id,omitempty"`
+	Row map[string]string `json:"row,omitempty"`
+}
+
+//batchEntry 事务中的一条日志及其所属的文件，见 CSVStore.Transact
+type batchEntry struct {
+	File  string `json:"file"`
+	Entry entry  `json:"entry"`
+}
+
+//journal 追加写入的修改日志，每条一行并立即落盘，
+//写到一半崩溃只会丢失最后一条
+type journal struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int
+}
+
+var errJournalLine = errors.New("无法解析，已跳过")
+
+//readJournal 读取日志中序号大于 after 的条目。没有换行结尾的最后一行是写到一半的，截掉；
+//中间无法解析的行跳过并报告，行号为日志中的行号
+func readJournal(path string, after int) (*journal, []entry, []RowError, error) {
+	j := &journal{path: path}
+	buf, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, nil, err
+	}
+
+	var entries []entry
+	var errs []RowError
+	lines := splitLines(buf)
+	for i, line := range lines {
+		var e entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			errs = append(errs, RowError{Line: i + 1, Column: "修改日志", Err: errJournalLine})
+			continue
+		}
+		j.size++
+		if e.Seq > after {
+			entries = append(entries, e)
+		}
+	}
+	if n := len(buf) - bytes.LastIndexByte(buf, '\n') - 1; n > 0 {
+		if err := os.Truncate(path, int64(len(buf)-n)); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return j, entries, errs, nil
+}
+
+//splitLines 按换行切开日志，不含没有换行结尾的最后一行，空行忽略
+func splitLines(buf []byte) [][]byte {
+	var lines [][]byte
+	for {
+		n := bytes.IndexByte(buf, '\n')
+		if n < 0 {
+			return lines
+		}
+		if n > 0 {
+			lines = append(lines, buf[:n])
+		}
+		buf = buf[n+1:]
+	}
+}
+
+//append 写入一条日志
+func (j *journal) append(e entry) error {
+	return j.appendAll([]entry{e})
+}
+
+//appendAll 依次写入多条日志，全部写完后落盘一次
+func (j *journal) appendAll(es []entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, os.ModePerm)
+		if err != nil {
+			return err
+		}
+		j.file = f
+	}
+	var buf bytes.Buffer
+	for _, e := range es {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	if _, err := j.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.size += len(es)
+	return nil
+}
+
+//trim 去掉已经写进快照（序号不大于 seq）的条目
+func (j *journal) trim(seq int) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file != nil {
+		//Windows 下文件打开时不能被替换
+		j.file.Close()
+		j.file = nil
+	}
+	buf, err := os.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var keep [][]byte
+	for _, line := range splitLines(buf) {
+		var e entry
+		if json.Unmarshal(line, &e) == nil && e.Seq > seq {
+			keep = append(keep, line)
+		}
+	}
+
+	err = replaceFile(j.path, false, func(w io.Writer) error {
+		for _, line := range keep {
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		j.size = len(keep)
+	}
+	return err
+}
+
+//count 日志中的条目数
+func (j *journal) count() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.size
+}
+
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+//snapshotSeq 快照文件记录的最后一条日志序号
+func snapshotSeq(t *table) int {
+	seq, _ := strconv.Atoi(t.meta["seq"])
+	return seq
+}
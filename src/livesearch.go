@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//searchDelay 输入停顿多久后提交查询条件，见 DataBinder 的 AutoSubmitDelay
+const searchDelay = 300 * time.Millisecond
+
+//searcher 边输入边查询：在后台筛选，新的查询取消还没完成的旧查询
+type searcher struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	//gen 每次发起或取消查询加一，结果只在 gen 未变时采用
+	gen int
+}
+
+//stop 取消进行中的查询，返回新的 gen；需持有 mu
+func (sr *searcher) stop() int {
+	if sr.cancel != nil {
+		sr.cancel()
+		sr.cancel = nil
+	}
+	sr.gen++
+	return sr.gen
+}
+
+//current gen 是否仍是最新的查询
+func (sr *searcher) current(gen int) bool {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.gen == gen
+}
+
+//searchAsync 按 s 在后台筛选，post 把结果交回界面线程（如 Form.Synchronize）
+func (m *FooModel) searchAsync(s Search, post func(func())) {
+	sr := &m.searcher
+	sr.mu.Lock()
+	gen := sr.stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	sr.cancel = cancel
+	sr.mu.Unlock()
+
+	go func() {
+		rwLock.RLock()
+		items, err := filterVisits(ctx, m.items, &s)
+		rwLock.RUnlock()
+		if err != nil {
+			return
+		}
+		post(func() {
+			//与 commit 一样持有写锁再换结果，检查 gen 之后不会再有写入插进来
+			rwLock.Lock()
+			defer rwLock.Unlock()
+			if sr.current(gen) {
+				m.sItems = items
+				m.page = 0
+				m.ResetRows()
+			}
+		})
+	}()
+}
+
+//cancelSearch 放弃还没完成的后台查询，在同步查询前调用
+func (m *FooModel) cancelSearch() {
+	m.searcher.mu.Lock()
+	m.searcher.stop()
+	m.searcher.mu.Unlock()
+}
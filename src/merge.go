@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gaoyangtok/medic/src/pinyin"
+)
+
+//Merge 一次病人档案的合并：MergedID 的就诊归到 KeepID 下。
+//VisitIDs 是当时移动的就诊，撤销时移回；UndoneAt 非零表示已撤销
+type Merge struct {
+	ID       string
+	KeepID   string
+	MergedID string
+	VisitIDs []string
+	At       time.Time
+	Operator string
+	UndoneAt time.Time
+}
+
+//DupPair 可能是同一个人的两份档案，A 建档较早
+type DupPair struct {
+	A, B *Patient
+	//Score 相似程度，不低于 dupThreshold 才列出
+	Score int
+	//Reasons 判断的依据，如“电话相同、姓名同音”
+	Reasons string
+}
+
+//dupThreshold 列为疑似重复的最低分
+const dupThreshold = 55
+
+//dupScore 两份档案的相似程度：电话、姓名（相同、同音或只差一个字）加分，
+//性别或按建档时年龄推算的出生年份不一致减分
+func dupScore(a, b *Patient) (int, []string) {
+	score := 0
+	var reasons []string
+	if pa := normalizePhone(a.Phone); pa != "" && pa == normalizePhone(b.Phone) {
+		score += 50
+		reasons = append(reasons, "电话相同")
+	}
+	na, nb := normalizeName(a.Name), normalizeName(b.Name)
+	switch {
+	case na == "" || nb == "":
+	case na == nb:
+		score += 40
+		reasons = append(reasons, "姓名相同")
+	case pinyin.SoundKey(na) == pinyin.SoundKey(nb):
+		score += 30
+		reasons = append(reasons, "姓名同音")
+	case oneRuneApart(na, nb):
+		score += 20
+		reasons = append(reasons, "姓名相差一字")
+	}
+	if a.Sex != "" && b.Sex != "" {
+		if a.Sex == b.Sex {
+			score += 5
+		} else {
+			score -= 30
+			reasons = append(reasons, "性别不同")
+		}
+	}
+	if a.Age > 0 && b.Age > 0 {
+		diff := (a.Create.Year() - a.Age) - (b.Create.Year() - b.Age)
+		if diff < 0 {
+			diff = -diff
+		}
+		switch {
+		case diff <= 2:
+			score += 10
+			reasons = append(reasons, "年龄相符")
+		case diff > 5:
+			score -= 20
+			reasons = append(reasons, "年龄不符")
+		}
+	}
+	return score, reasons
+}
+
+//oneRuneApart 两个至少两个字、长度相同的姓名是否只有一个字不同
+func oneRuneApart(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) != len(rb) || len(ra) < 2 {
+		return false
+	}
+	diff := 0
+	for i := range ra {
+		if ra[i] != rb[i] {
+			diff++
+		}
+	}
+	return diff == 1
+}
+
+//findDuplicates 找出疑似重复的档案，按相似程度从高到低排列。
+//只比较电话、姓名或姓名读音相同的档案，避免两两比较；ctx 取消时返回 ctx.Err()
+func findDuplicates(ctx context.Context, patients []*Patient) ([]*DupPair, error) {
+	blocks := map[string][]*Patient{}
+	for _, p := range patients {
+		if phone := normalizePhone(p.Phone); len(phone) >= 7 {
+			blocks["phone:"+phone] = append(blocks["phone:"+phone], p)
+		}
+		if name := normalizeName(p.Name); name != "" {
+			blocks["name:"+name] = append(blocks["name:"+name], p)
+			if key := pinyin.SoundKey(name); key != "" {
+				blocks["sound:"+key] = append(blocks["sound:"+key], p)
+			}
+		}
+	}
+
+	seen := map[[2]string]bool{}
+	var pairs []*DupPair
+	for _, block := range blocks {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for i := 0; i < len(block); i++ {
+			for j := i + 1; j < len(block); j++ {
+				a, b := block[i], block[j]
+				if b.Create.Before(a.Create) || b.Create.Equal(a.Create) && b.ID < a.ID {
+					a, b = b, a
+				}
+				key := [2]string{a.ID, b.ID}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				if score, reasons := dupScore(a, b); score >= dupThreshold {
+					pairs = append(pairs, &DupPair{A: a, B: b, Score: score, Reasons: strings.Join(reasons, "、")})
+				}
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score > pairs[j].Score
+		}
+		return pairs[i].A.Create.Before(pairs[j].A.Create)
+	})
+	return pairs, nil
+}
+
+//activePatients 没有被合并掉的档案的副本，在读锁内取出后可以在后台比较
+func (m *FooModel) activePatients() []*Patient {
+	var patients []*Patient
+	for id, p := range m.patients {
+		if _, merged := m.mergedInto[id]; !merged {
+			copied := *p
+			patients = append(patients, &copied)
+		}
+	}
+	return patients
+}
+
+//attachMerges 载入合并记录，让被合并档案的姓名和电话指向保留的档案；
+//在载入档案之后、归档就诊之前调用
+func (m *FooModel) attachMerges(merges []*Merge) {
+	m.merges = merges
+	m.mergedInto = map[string]string{}
+	for _, g := range merges {
+		if g.UndoneAt.IsZero() {
+			m.mergedInto[g.MergedID] = g.KeepID
+		}
+	}
+	for id := range m.mergedInto {
+		if p, ok := m.patients[id]; ok {
+			m.setKey(p, m.resolve(p))
+		}
+	}
+}
+
+//resolve 沿合并记录找到最终保留的档案
+func (m *FooModel) resolve(p *Patient) *Patient {
+	for i := 0; i <= len(m.mergedInto); i++ {
+		id, ok := m.mergedInto[p.ID]
+		if !ok {
+			return p
+		}
+		next, ok := m.patients[id]
+		if !ok {
+			return p
+		}
+		p = next
+	}
+	return p
+}
+
+//merge 把 drop 的全部就诊（含回收站中的）归到 keep 下，并记下合并以便撤销
+func (m *FooModel) merge(store Store, keep, drop *Patient) (*Merge, error) {
+	if keep.ID == drop.ID {
+		return nil, fmt.Errorf("不能与自己合并")
+	}
+	if _, ok := m.mergedInto[keep.ID]; ok {
+		return nil, fmt.Errorf("%s 的档案已经合并到别的档案", keep.Name)
+	}
+	if _, ok := m.mergedInto[drop.ID]; ok {
+		return nil, fmt.Errorf("%s 的档案已经合并到别的档案", drop.Name)
+	}
+	g := &Merge{KeepID: keep.ID, MergedID: drop.ID, At: time.Now(), Operator: operator}
+	var visits []*Foo
+	for _, item := range m.items {
+		if item.PatientID == drop.ID {
+			visits = append(visits, item)
+			g.VisitIDs = append(g.VisitIDs, item.ID)
+		}
+	}
+	//先记下合并，中途失败时也能按记录撤销已经移动的就诊
+	if err := store.InsertMerge(g); err != nil {
+		return nil, err
+	}
+	m.merges = append(m.merges, g)
+	m.mergedInto[drop.ID] = keep.ID
+	m.setKey(drop, keep)
+	for _, foo := range visits {
+		if err := m.movePatient(store, foo, keep.ID, "并入 "+keep.Name+" 的档案"); err != nil {
+			return g, err
+		}
+	}
+	return g, nil
+}
+
+//undoMerge 撤销一次合并，把当时移动、之后没有再改过病人的就诊移回原档案
+func (m *FooModel) undoMerge(store Store, g *Merge) error {
+	if !g.UndoneAt.IsZero() {
+		return fmt.Errorf("这次合并已经撤销")
+	}
+	drop, ok := m.patients[g.MergedID]
+	if !ok {
+		return fmt.Errorf("被合并的档案 %s 不存在", g.MergedID)
+	}
+	if _, ok := m.mergedInto[g.KeepID]; ok {
+		return fmt.Errorf("保留的档案之后又合并到了别的档案，请先撤销之后的合并")
+	}
+	moved := map[string]bool{}
+	for _, id := range g.VisitIDs {
+		moved[id] = true
+	}
+	for _, item := range m.items {
+		if moved[item.ID] && item.PatientID == g.KeepID {
+			if err := m.movePatient(store, item, drop.ID, "撤销合并，移回 "+drop.Name+" 的档案"); err != nil {
+				return err
+			}
+		}
+	}
+	before := *g
+	g.UndoneAt = time.Now()
+	if err := store.UpdateMerge(g); err != nil {
+		*g = before
+		return err
+	}
+	delete(m.mergedInto, drop.ID)
+	m.setKey(drop, drop)
+	return nil
+}
+
+//movePatient 把一次就诊归到另一个档案下，并记入修改记录；
+//就诊已日结时以 reason 登记调整
+func (m *FooModel) movePatient(store Store, foo *Foo, patientID, reason string) error {
+	before := *foo
+	foo.PatientID = patientID
+	if err := store.Update(foo); err != nil {
+		*foo = before
+		return err
+	}
+	if err := m.audit(store, &before, foo); err != nil {
+		return err
+	}
+	return m.adjustAuto(store, adjustMerge, reason, &before, foo)
+}
+
+//activeMerges 没有撤销的合并记录，最近的在前
+func (m *FooModel) activeMerges() []*Merge {
+	var merges []*Merge
+	for _, g := range m.merges {
+		if g.UndoneAt.IsZero() {
+			merges = append(merges, g)
+		}
+	}
+	sort.SliceStable(merges, func(i, j int) bool {
+		return merges[i].At.After(merges[j].At)
+	})
+	return merges
+}
+
+//visitCount 档案下未删除的就诊次数
+func (m *FooModel) visitCount(patientID string) int {
+	n := 0
+	for _, item := range m.items {
+		if item.PatientID == patientID && !item.Deleted {
+			n++
+		}
+	}
+	return n
+}
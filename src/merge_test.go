@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDupScore(t *testing.T) {
+	year := func(y int) time.Time {
+		return time.Date(y, 6, 1, 0, 0, 0, 0, time.Local)
+	}
+	base := Patient{Name: "张伟", Phone: "13800138000", Sex: SexMan, Age: 30, Create: year(2020)}
+	tests := []struct {
+		name    string
+		b       Patient
+		score   int
+		reasons string
+	}{
+		{"全部相同", base, 105, "电话相同 姓名相同 年龄相符"},
+		{"电话格式不同", Patient{Name: "张 伟", Phone: "+86 138-0013-8000"}, 90, "电话相同 姓名相同"},
+		{"只有电话", Patient{Name: "李强", Phone: "13800138000"}, 50, "电话相同"},
+		{"同音", Patient{Name: "章伟"}, 30, "姓名同音"},
+		{"同音不同字数", Patient{Name: "张伟伟"}, 0, ""},
+		{"相差一字", Patient{Name: "张强"}, 20, "姓名相差一字"},
+		{"没有姓名", Patient{Phone: "13800138000"}, 50, "电话相同"},
+		{"性别不同", Patient{Name: "张伟", Sex: SexWoman}, 10, "姓名相同 性别不同"},
+		{"出生年份差两年", Patient{Name: "张伟", Age: 35, Create: year(2023)}, 50, "姓名相同 年龄相符"},
+		{"出生年份差四年", Patient{Name: "张伟", Age: 37, Create: year(2023)}, 40, "姓名相同"},
+		{"出生年份差六年", Patient{Name: "张伟", Age: 39, Create: year(2023)}, 20, "姓名相同 年龄不符"},
+		{"都不符", Patient{Name: "李强", Phone: "13900139000", Sex: SexWoman, Age: 60, Create: year(2020)}, -50, "性别不同 年龄不符"},
+	}
+	for _, tt := range tests {
+		a, b := base, tt.b
+		score, reasons := dupScore(&a, &b)
+		if score != tt.score || strings.Join(reasons, " ") != tt.reasons {
+			t.Errorf("%s: dupScore = %d %q, want %d %q", tt.name, score, reasons, tt.score, tt.reasons)
+		}
+		if score2, _ := dupScore(&b, &a); score2 != score {
+			t.Errorf("%s: dupScore 不对称: %d, %d", tt.name, score, score2)
+		}
+	}
+}
+
+func TestOneRuneApart(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"张伟", "张强", true},
+		{"王小明", "王大明", true},
+		{"王小明", "李小明", true},
+		{"王小明", "王小明", false},
+		{"王小明", "李大明", false},
+		{"张伟", "张伟伟", false},
+		{"张", "李", false},
+		{"", "", false},
+		{"ab", "ac", true},
+	}
+	for _, tt := range tests {
+		if got := oneRuneApart(tt.a, tt.b); got != tt.want {
+			t.Errorf("oneRuneApart(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
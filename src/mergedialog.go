@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+//dupRow 疑似重复列表的一行，左侧为建档较早的档案
+type dupRow struct {
+	Pair                *DupPair
+	AName, APhone, ASex string
+	AAge, AVisits       int
+	BName, BPhone, BSex string
+	BAge, BVisits       int
+	Score               int
+	Reasons             string
+}
+
+//mergeRow 合并记录列表的一行
+type mergeRow struct {
+	Merge    *Merge
+	At       time.Time
+	Keep     string
+	Merged   string
+	Visits   int
+	Operator string
+}
+
+//dupRows 需持有读锁
+func dupRows(pairs []*DupPair) []*dupRow {
+	rows := make([]*dupRow, len(pairs))
+	for i, d := range pairs {
+		rows[i] = &dupRow{
+			Pair:  d,
+			AName: d.A.Name, APhone: d.A.Phone, ASex: string(d.A.Sex), AAge: d.A.Age, AVisits: model.visitCount(d.A.ID),
+			BName: d.B.Name, BPhone: d.B.Phone, BSex: string(d.B.Sex), BAge: d.B.Age, BVisits: model.visitCount(d.B.ID),
+			Score:   d.Score,
+			Reasons: d.Reasons,
+		}
+	}
+	return rows
+}
+
+//mergeRows 需持有读锁
+func mergeRows() []*mergeRow {
+	var rows []*mergeRow
+	for _, g := range model.activeMerges() {
+		r := &mergeRow{Merge: g, At: g.At, Visits: len(g.VisitIDs), Operator: g.Operator, Keep: g.KeepID, Merged: g.MergedID}
+		if p, ok := model.patients[g.KeepID]; ok {
+			r.Keep = p.Name + " " + p.Phone
+		}
+		if p, ok := model.patients[g.MergedID]; ok {
+			r.Merged = p.Name + " " + p.Phone
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+//DuplicatesDialog 合并档案：在后台查找可能是同一个人的档案，确认后合并，合并记录可以撤销
+func DuplicatesDialog(owner walk.Form) (int, error) {
+	var dlg *walk.Dialog
+	var statusLabel *walk.Label
+	var dupTV, mergeTV *walk.TableView
+	var rows []*dupRow
+	var merges []*mergeRow
+	//ignored 本次确认不是同一人的档案对
+	ignored := map[[2]string]bool{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	//gen 每次查找加一，只采用最近一次查找的结果
+	gen := 0
+
+	showMerges := func() {
+		rwLock.RLock()
+		merges = mergeRows()
+		rwLock.RUnlock()
+		_ = mergeTV.SetModel(merges)
+	}
+
+	//detect 在后台查找，结果交回界面线程
+	detect := func() {
+		_ = statusLabel.SetText("正在查找疑似重复的档案…")
+		gen++
+		current := gen
+		go func() {
+			rwLock.RLock()
+			patients := model.activePatients()
+			rwLock.RUnlock()
+			pairs, err := findDuplicates(ctx, patients)
+			if err != nil {
+				return
+			}
+			dlg.Synchronize(func() {
+				if ctx.Err() != nil || current != gen {
+					return
+				}
+				var kept []*DupPair
+				for _, d := range pairs {
+					if !ignored[[2]string{d.A.ID, d.B.ID}] {
+						kept = append(kept, d)
+					}
+				}
+				rwLock.RLock()
+				rows = dupRows(kept)
+				rwLock.RUnlock()
+				_ = dupTV.SetModel(rows)
+				_ = statusLabel.SetText(fmt.Sprintf("找到 %d 对疑似重复的档案，合并后可以在下方撤销", len(rows)))
+			})
+		}()
+	}
+
+	//mergeSelected keepLeft 为真时保留左侧档案，把右侧档案合并进来
+	mergeSelected := func(keepLeft bool) {
+		index := dupTV.CurrentIndex()
+		if index < 0 {
+			return
+		}
+		d := rows[index].Pair
+		keepID, dropID := d.A.ID, d.B.ID
+		if !keepLeft {
+			keepID, dropID = dropID, keepID
+		}
+		var keep, drop *Patient
+		rwLock.RLock()
+		keep, drop = model.patients[keepID], model.patients[dropID]
+		rwLock.RUnlock()
+		if keep == nil || drop == nil {
+			return
+		}
+		msg := fmt.Sprintf("把 %s %s 的就诊合并到 %s %s 的档案下？\n合并后可以撤销。", drop.Name, drop.Phone, keep.Name, keep.Phone)
+		if walk.MsgBox(dlg, "合并档案", msg, walk.MsgBoxYesNo|walk.MsgBoxIconQuestion) != walk.DlgCmdYes {
+			return
+		}
+		err := model.commit(func(store Store) error {
+			_, err := model.merge(store, keep, drop)
+			return err
+		})
+		if err != nil {
+			walk.MsgBox(dlg, "错误", "合并失败："+err.Error(), walk.MsgBoxIconError)
+		}
+		showMerges()
+		detect()
+	}
+
+	err := Dialog{
+		AssignTo: &dlg,
+		Title:    "合并档案",
+		MinSize:  Size{Width: 1000, Height: 600},
+		Layout:   VBox{},
+		Children: []Widget{
+			Label{
+				AssignTo: &statusLabel,
+				Font:     labelFont,
+			},
+			TableView{
+				AssignTo:         &dupTV,
+				ColumnsOrderable: true,
+				Columns: []TableViewColumn{
+					{Name: "AName", Title: "姓名", Width: 60},
+					{Name: "APhone", Title: "电话", Width: 100},
+					{Name: "ASex", Title: "性别", Width: 40},
+					{Name: "AAge", Title: "年龄", Alignment: AlignFar, Width: 40},
+					{Name: "AVisits", Title: "就诊", Alignment: AlignFar, Width: 40},
+					{Name: "BName", Title: "姓名", Width: 60},
+					{Name: "BPhone", Title: "电话", Width: 100},
+					{Name: "BSex", Title: "性别", Width: 40},
+					{Name: "BAge", Title: "年龄", Alignment: AlignFar, Width: 40},
+					{Name: "BVisits", Title: "就诊", Alignment: AlignFar, Width: 40},
+					{Name: "Score", Title: "相似度", Alignment: AlignFar, Width: 50},
+					{Name: "Reasons", Title: "依据", Width: 220},
+				},
+				OnItemActivated: func() {
+					if index := dupTV.CurrentIndex(); index >= 0 {
+						_, _ = HistoryDialog(dlg, rows[index].Pair.A.ID)
+					}
+				},
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					PushButton{
+						Text:      "保留左侧档案",
+						OnClicked: func() { mergeSelected(true) },
+					},
+					PushButton{
+						Text:      "保留右侧档案",
+						OnClicked: func() { mergeSelected(false) },
+					},
+					PushButton{
+						Text: "不是同一人",
+						OnClicked: func() {
+							index := dupTV.CurrentIndex()
+							if index < 0 {
+								return
+							}
+							d := rows[index].Pair
+							ignored[[2]string{d.A.ID, d.B.ID}] = true
+							rows = append(rows[:index:index], rows[index+1:]...)
+							_ = dupTV.SetModel(rows)
+						},
+					},
+					HSpacer{},
+					PushButton{
+						Text:        "规范电话",
+						ToolTipText: "统一已有记录的电话格式，格式不一会漏掉重复的档案",
+						OnClicked: func() {
+							normalizePhonesAction(dlg)
+							detect()
+						},
+					},
+					PushButton{
+						Text:      "重新查找",
+						OnClicked: detect,
+					},
+				},
+			},
+			Label{Text: "合并记录:"},
+			TableView{
+				AssignTo: &mergeTV,
+				MaxSize:  Size{Height: 160},
+				Columns: []TableViewColumn{
+					{Name: "At", Title: "合并时间", Format: "2006-01-02 15:04", Width: 130},
+					{Name: "Keep", Title: "保留档案", Width: 160},
+					{Name: "Merged", Title: "合并档案", Width: 160},
+					{Name: "Visits", Title: "就诊数", Alignment: AlignFar, Width: 60},
+					{Name: "Operator", Title: "操作人", Width: 80},
+				},
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						Text: "撤销合并",
+						OnClicked: func() {
+							index := mergeTV.CurrentIndex()
+							if index < 0 {
+								return
+							}
+							g := merges[index].Merge
+							err := model.commit(func(store Store) error {
+								return model.undoMerge(store, g)
+							})
+							if err != nil {
+								walk.MsgBox(dlg, "错误", "撤销失败："+err.Error(), walk.MsgBoxIconError)
+							}
+							showMerges()
+							detect()
+						},
+					},
+					PushButton{
+						Text:      "关闭",
+						OnClicked: func() { dlg.Accept() },
+					},
+				},
+			},
+		},
+	}.Create(owner)
+	if err != nil {
+		return 0, err
+	}
+	showMerges()
+	detect()
+	return dlg.Run(), nil
+}
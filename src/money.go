@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//Money 金额，以分为单位的定点数，避免浮点累加的误差；
+//取整规则：超过两位的小数和由浮点数换算时，一律按分四舍五入（0.5 分远离零进位）
+type Money int64
+
+//Yuan 一元
+const Yuan Money = 100
+
+var errMoney = errors.New("金额格式不正确")
+
+//ParseMoney 按十进制字符串精确解析金额，不经过浮点数，
+//如 "12"、"12.5"、"-0.05"，第三位小数起按四舍五入进到分
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	//最多一个正负号
+	neg := strings.HasPrefix(s, "-")
+	if neg || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i+1:]
+	}
+	if intPart == "" && frac == "" {
+		return 0, errMoney
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	for _, c := range intPart + frac {
+		if c < '0' || c > '9' {
+			return 0, errMoney
+		}
+	}
+	yuan, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil || yuan > math.MaxInt64/100-1 {
+		return 0, errMoney
+	}
+	frac += "000"
+	fen, _ := strconv.Atoi(frac[:2])
+	m := Money(yuan)*Yuan + Money(fen)
+	if frac[2] >= '5' {
+		m++
+	}
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+//MoneyOf 把以元为单位的浮点数换算成金额，按分四舍五入；
+//只用于界面上 NumberEdit 输入的值
+func MoneyOf(yuan float64) Money {
+	return Money(math.Round(yuan * 100))
+}
+
+//Yuan 换算成以元为单位的浮点数，只用于显示和绘图
+func (m Money) Yuan() float64 {
+	return float64(m) / 100
+}
+
+//String 固定两位小数，如 "1234.50"，也是 CSV 中的保存格式
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	fen := strconv.FormatInt(int64(m%Yuan), 10)
+	if len(fen) < 2 {
+		fen = "0" + fen
+	}
+	return sign + strconv.FormatInt(int64(m/Yuan), 10) + "." + fen
+}
+
+//moneyField 让 NumberEdit 以元为单位绑定 Money 字段，
+//实现 walk.DataField，写回时按分四舍五入
+type moneyField struct {
+	m *Money
+}
+
+func (f moneyField) CanSet() bool {
+	return true
+}
+
+func (f moneyField) Get() interface{} {
+	return f.m.Yuan()
+}
+
+func (f moneyField) Set(v interface{}) error {
+	yuan, ok := v.(float64)
+	if !ok {
+		return errMoney
+	}
+	*f.m = MoneyOf(yuan)
+	return nil
+}
+
+func (f moneyField) Zero() interface{} {
+	return 0.0
+}
+
+//AllFeeYuan 等供 AddDialog 中 Bind 使用
+func (foo *Foo) AllFeeYuan() moneyField {
+	return moneyField{&foo.AllFee}
+}
+
+func (foo *Foo) RealFeeYuan() moneyField {
+	return moneyField{&foo.RealFee}
+}
+
+func (foo *Foo) PaidFeeYuan() moneyField {
+	return moneyField{&foo.PaidFee}
+}
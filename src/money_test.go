@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Money
+	}{
+		{"0", 0},
+		{"12", 1200},
+		{" 12.5 ", 1250},
+		{"12.34", 1234},
+		{".5", 50},
+		{"3.", 300},
+		{"+1.01", 101},
+		{"-0.05", -5},
+		{"0.004", 0},
+		{"0.005", 1},
+		{"0.0049", 0},
+		{"1.995", 200},
+		{"-1.995", -200},
+		{"-0.004", 0},
+		{"199.994999", 19999},
+		{"0.015", 2},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.s)
+		if err != nil {
+			t.Errorf("ParseMoney(%q): %v", tt.s, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseMoneyErrors(t *testing.T) {
+	for _, s := range []string{"", " ", ".", "-", "1.2.3", "1,000", "12元", "--1", "-+1", "+-1", "++1", "1e3", "99999999999999999999"} {
+		if m, err := ParseMoney(s); err == nil {
+			t.Errorf("ParseMoney(%q) = %d, want error", s, m)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{-5, "-0.05"},
+		{1250, "12.50"},
+		{-123456, "-1234.56"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.m), got, tt.want)
+		}
+	}
+}
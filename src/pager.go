@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strconv"
+)
+
+//pageSizes 可选的每页条数，0 表示不分页
+var pageSizes = []int{50, 100, 200, 500, 0}
+
+//defaultPageSize 默认每页条数
+const defaultPageSize = 100
+
+func pageSizeTitle(size int) string {
+	if size == 0 {
+		return "全部"
+	}
+	return strconv.Itoa(size) + " 条/页"
+}
+
+//pageSizeIndex size 在 pageSizes 中的下标，不在其中时为默认值的下标
+func pageSizeIndex(size int) int {
+	for i, s := range pageSizes {
+		if s == size {
+			return i
+		}
+	}
+	return pageSizeIndex(defaultPageSize)
+}
+
+func pageSizeTitles() []string {
+	titles := make([]string, len(pageSizes))
+	for i, size := range pageSizes {
+		titles[i] = pageSizeTitle(size)
+	}
+	return titles
+}
+
+//pageCount 总页数，没有记录时也算一页
+func (m *FooModel) pageCount() int {
+	if m.pageSize <= 0 || len(m.sItems) == 0 {
+		return 1
+	}
+	return (len(m.sItems) + m.pageSize - 1) / m.pageSize
+}
+
+//offset 当前页第一行在 sItems 中的下标
+func (m *FooModel) offset() int {
+	return m.page * m.pageSize
+}
+
+//pageRows 当前页的记录；排序和查询都作用于全部 sItems，分页只是取其中一段
+func (m *FooModel) pageRows() []*Foo {
+	if m.pageSize <= 0 {
+		return m.sItems
+	}
+	end := m.offset() + m.pageSize
+	if end > len(m.sItems) {
+		end = len(m.sItems)
+	}
+	return m.sItems[m.offset():end]
+}
+
+//row 当前页的第 i 行
+func (m *FooModel) row(i int) *Foo {
+	return m.sItems[m.offset()+i]
+}
+
+//clampPage 记录变少后把页码限制在范围内
+func (m *FooModel) clampPage() {
+	if n := m.pageCount(); m.page >= n {
+		m.page = n - 1
+	}
+	if m.page < 0 {
+		m.page = 0
+	}
+}
+
+//setPage 翻到第 page 页（从 0 开始），超出范围时翻到首页或末页，返回页码是否变化
+func (m *FooModel) setPage(page int) bool {
+	old := m.page
+	m.page = page
+	m.clampPage()
+	return m.page != old
+}
+
+//setPageSize 修改每页条数，尽量保持当前页第一行仍然可见
+func (m *FooModel) setPageSize(size int) {
+	first := m.offset()
+	m.pageSize = size
+	m.page = 0
+	if size > 0 {
+		m.page = first / size
+	}
+	m.clampPage()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//Patient 病人档案，一个病人可以有多次就诊（Foo）；
+//Foo 中的姓名、电话等是就诊当时的信息，档案保存最新的信息
+type Patient struct {
+	ID      string
+	Name    string
+	Phone   string
+	Sex     Sex
+	Age     int
+	Address string
+	Create  time.Time
+	//FollowUp 欠费回访的状态，空为未联系；FollowNote、FollowedAt 为最近一次回访的备注和时间
+	FollowUp   string
+	FollowNote string
+	FollowedAt time.Time
+}
+
+//normalizePhone 电话的规范形式，见 parsePhone；无法识别的号码只保留数字，去掉 +86/86 国家码
+func normalizePhone(phone string) string {
+	if norm, _, err := parsePhone(phone); err == nil {
+		return norm
+	}
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(digits) == 13 && strings.HasPrefix(digits, "861") {
+		digits = digits[2:]
+	}
+	return digits
+}
+
+//normalizeName 去掉姓名中的空白
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(name), "")
+}
+
+//patientKey 用规范化的电话和姓名识别同一个病人
+func patientKey(name, phone string) string {
+	return normalizePhone(phone) + "|" + normalizeName(name)
+}
+
+//patientOf 由一次就诊的信息生成新的档案
+func patientOf(foo *Foo) *Patient {
+	return &Patient{
+		Name:    normalizeName(foo.Name),
+		Phone:   foo.Phone,
+		Sex:     foo.Sex,
+		Age:     foo.Age,
+		Address: foo.Address,
+		Create:  foo.Create,
+	}
+}
+
+//fill 用档案填写新就诊中还空着的病人信息
+func (p *Patient) fill(foo *Foo) {
+	if foo.Name == "" {
+		foo.Name = p.Name
+	}
+	if foo.Phone == "" {
+		foo.Phone = p.Phone
+	}
+	if foo.Address == "" {
+		foo.Address = p.Address
+	}
+	if foo.Age == 0 {
+		foo.Age = p.Age
+	}
+	foo.Sex = p.Sex
+}
+
+//refresh 用较新的一次就诊更新档案，返回档案是否有变化
+func (p *Patient) refresh(foo *Foo) bool {
+	changed := p.Phone != foo.Phone || p.Sex != foo.Sex || p.Age != foo.Age || p.Address != foo.Address
+	p.Phone, p.Sex, p.Age, p.Address = foo.Phone, foo.Sex, foo.Age, foo.Address
+	return changed
+}
+
+func (m *FooModel) addPatient(p *Patient) {
+	m.patients[p.ID] = p
+	m.setKey(p, p)
+}
+
+//setKey 按 p 的电话和姓名登记归档到的档案 to；没有电话的不登记，同名的人不能只凭姓名认作一人
+func (m *FooModel) setKey(p, to *Patient) {
+	if normalizePhone(p.Phone) != "" {
+		m.byKey[patientKey(p.Name, p.Phone)] = to
+	}
+}
+
+//link 把一次就诊归到对应的病人档案下，没有档案时新建；这次就诊是档案中最新的一次时用它更新档案。
+//没有电话时不按姓名归档：已经归档的留在原档案下，否则单独建档，重复的可以在“查重”中合并。
+//档案已被合并时归到保留的档案下
+func (m *FooModel) link(store Store, foo *Foo) error {
+	var p *Patient
+	if normalizePhone(foo.Phone) != "" {
+		p = m.byKey[patientKey(foo.Name, foo.Phone)]
+	} else {
+		p = m.patients[foo.PatientID]
+	}
+	if p == nil {
+		p = patientOf(foo)
+		if err := store.InsertPatient(p); err != nil {
+			return err
+		}
+		m.addPatient(p)
+	} else if p = m.resolve(p); m.latest(p, foo) && p.refresh(foo) {
+		if err := store.UpdatePatient(p); err != nil {
+			return err
+		}
+	}
+	foo.PatientID = p.ID
+	return nil
+}
+
+//latest foo 是否不早于档案中其它未删除的就诊，修改旧的就诊不会覆盖档案中较新的信息
+func (m *FooModel) latest(p *Patient, foo *Foo) bool {
+	for _, item := range m.items {
+		if item.ID != foo.ID && item.PatientID == p.ID && !item.Deleted && item.Create.After(foo.Create) {
+			return false
+		}
+	}
+	return true
+}
+
+//linkAll 为还没有归档的就诊按登记时间先后归档，档案保留最近一次的信息；在设置 items 之后调用
+func (m *FooModel) linkAll(store Store, foos []*Foo) error {
+	var todo []*Foo
+	for _, foo := range foos {
+		if _, ok := m.patients[foo.PatientID]; !ok {
+			todo = append(todo, foo)
+		}
+	}
+	sort.SliceStable(todo, func(i, j int) bool {
+		return todo[i].Create.Before(todo[j].Create)
+	})
+	for _, foo := range todo {
+		if err := m.link(store, foo); err != nil {
+			return err
+		}
+		if err := store.Update(foo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//findPatient 按电话查找病人档案，同一电话有多人时优先姓名相同的
+func (m *FooModel) findPatient(name, phone string) *Patient {
+	if p, ok := m.byKey[patientKey(name, phone)]; ok {
+		return m.resolve(p)
+	}
+	phone = normalizePhone(phone)
+	if phone == "" {
+		return nil
+	}
+	var found *Patient
+	for _, p := range m.patients {
+		if normalizePhone(p.Phone) == phone && (found == nil || p.Create.After(found.Create)) {
+			found = p
+		}
+	}
+	if found != nil {
+		found = m.resolve(found)
+	}
+	return found
+}
+
+//visitsOf 病人的全部就诊，最近的在前
+func (m *FooModel) visitsOf(patientID string) []*Foo {
+	var foos []*Foo
+	for _, item := range m.items {
+		if item.PatientID == patientID && !item.Deleted {
+			foos = append(foos, item)
+		}
+	}
+	sort.SliceStable(foos, func(i, j int) bool {
+		return foos[i].Create.After(foos[j].Create)
+	})
+	return foos
+}
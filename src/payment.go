@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+//payMethods 收款方式
+var payMethods = []string{"现金", "微信", "支付宝", "刷卡", "其他"}
+
+//Payment 一次就诊下的一笔收款，作废的收款保留在账上但不计入已付
+type Payment struct {
+	ID      string
+	VisitID string
+	Amount  Money
+	At      time.Time
+	Method  string
+	Note    string
+	Voided  bool
+}
+
+//paid 按收款记录重新计算已付费用
+func (foo *Foo) paid() {
+	foo.PaidFee = 0
+	for _, p := range foo.Payments {
+		if !p.Voided {
+			foo.PaidFee += p.Amount
+		}
+	}
+}
+
+//attachPayments 把收款挂到各自的就诊下；旧数据只有已付费用，
+//按登记时间补记一笔方式不明的收款
+func (m *FooModel) attachPayments(store Store, visits []*Foo, payments []*Payment) error {
+	byID := map[string]*Foo{}
+	for _, foo := range visits {
+		byID[foo.ID] = foo
+	}
+	for _, p := range payments {
+		if foo, ok := byID[p.VisitID]; ok {
+			foo.Payments = append(foo.Payments, p)
+		}
+	}
+	for _, foo := range visits {
+		if len(foo.Payments) == 0 && foo.PaidFee != 0 {
+			p := &Payment{VisitID: foo.ID, Amount: foo.PaidFee, At: foo.Create, Note: "历史数据"}
+			if err := store.InsertPayment(p); err != nil {
+				return err
+			}
+			foo.Payments = append(foo.Payments, p)
+		}
+		sort.SliceStable(foo.Payments, func(i, j int) bool {
+			return foo.Payments[i].At.Before(foo.Payments[j].At)
+		})
+		foo.paid()
+	}
+	return nil
+}
+
+//pay 为一次就诊记一笔收款
+func (m *FooModel) pay(store Store, foo *Foo, amount Money, method, note string) error {
+	p := &Payment{VisitID: foo.ID, Amount: amount, At: time.Now(), Method: method, Note: note}
+	if err := store.InsertPayment(p); err != nil {
+		return err
+	}
+	foo.Payments = append(foo.Payments, p)
+	foo.paid()
+	return store.Update(foo)
+}
+
+//void 作废一笔收款
+func (m *FooModel) void(store Store, foo *Foo, p *Payment) error {
+	p.Voided = true
+	if err := store.UpdatePayment(p); err != nil {
+		p.Voided = false
+		return err
+	}
+	foo.paid()
+	return store.Update(foo)
+}
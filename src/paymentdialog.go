@@ -0,0 +1,88 @@
+package main
+
+import (
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+//PaymentsDialog 列出一次就诊的全部收款，可以作废选中的收款
+func PaymentsDialog(owner walk.Form, foo *Foo) (int, error) {
+	var dlg *walk.Dialog
+	var tv *walk.TableView
+	var voidPB *walk.PushButton
+
+	return Dialog{
+		AssignTo: &dlg,
+		Title:    foo.Name + " 的收费记录",
+		MinSize:  Size{Width: 500, Height: 300},
+		Layout:   VBox{},
+		Children: []Widget{
+			TableView{
+				AssignTo: &tv,
+				Columns: []TableViewColumn{
+					{Name: "At", Title: "收款时间", Format: "2006-01-02 15:04", Width: 130},
+					{Name: "Amount", Title: "金额", Alignment: AlignFar, Width: 70},
+					{Name: "Method", Title: "方式", Width: 60},
+					{Name: "Note", Title: "备注", Width: 120},
+					{Name: "Voided", Title: "已作废", Width: 60},
+				},
+				Model: foo.Payments,
+				OnCurrentIndexChanged: func() {
+					index := tv.CurrentIndex()
+					voidPB.SetEnabled(index >= 0 && !foo.Payments[index].Voided)
+				},
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						AssignTo: &voidPB,
+						Text:     "作废",
+						Enabled:  false,
+						OnClicked: func() {
+							index := tv.CurrentIndex()
+							if index < 0 {
+								return
+							}
+							p := foo.Payments[index]
+							rwLock.RLock()
+							closing := model.closingOf(p.At)
+							rwLock.RUnlock()
+							//已日结那天的收款要说明作废的原因
+							var reason string
+							if closing != nil {
+								var ok bool
+								if reason, ok = adjustReason(dlg, "这笔收款在 "+dayKey(closing.Day)+"，已经日结，作废将记为调整。"); !ok {
+									return
+								}
+							} else if walk.MsgBox(dlg, "作废", "确定作废这笔收款吗？", walk.MsgBoxYesNo|walk.MsgBoxIconQuestion) != walk.DlgCmdYes {
+								return
+							}
+							err := model.commit(func(store Store) error {
+								before := *foo
+								if err := model.void(store, foo, p); err != nil {
+									return err
+								}
+								if closing != nil {
+									return model.adjust(store, closing, adjustVoid, reason, &before, foo)
+								}
+								return nil
+							})
+							if err != nil {
+								walk.MsgBox(dlg, "错误", "保存失败："+err.Error(), walk.MsgBoxIconError)
+								return
+							}
+							_ = tv.SetModel(foo.Payments)
+							voidPB.SetEnabled(false)
+						},
+					},
+					PushButton{
+						Text:      "关闭",
+						OnClicked: func() { dlg.Accept() },
+					},
+				},
+			},
+		},
+	}.Run(owner)
+}
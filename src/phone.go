@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+//phoneWidth 全角数字和符号对应的半角字符
+var phoneWidth = strings.NewReplacer(
+	"０", "0", "１", "1", "２", "2", "３", "3", "４", "4",
+	"５", "5", "６", "6", "７", "7", "８", "8", "９", "9",
+	"＋", "+", "－", "-", "（", "(", "）", ")", "＃", "#", "，", ",", "　", " ",
+)
+
+//phoneSeparators 号码中可以忽略的分隔符
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+
+//phoneExtMarks 分机号前的标记，如 010-12345678 转 123
+var phoneExtMarks = []string{"转", "ext", "EXT", "x", "X", "#", ","}
+
+var (
+	errPhoneChars    = errors.New("电话号码只能包含数字、空格、横线和括号")
+	errPhoneCountry  = errors.New("只支持中国大陆的电话号码")
+	errPhoneMobile   = errors.New("手机号码应为 1 开头的 11 位数字，第二位为 3-9")
+	errPhoneLandline = errors.New("固定电话应为区号加 7 到 8 位号码，如 010-12345678、0571-8765432")
+	errPhoneExt      = errors.New("分机号应为 1 到 6 位数字，手机号码不能带分机")
+)
+
+//parsePhone 解析中国大陆的手机号码和固定电话，忽略空格、横线、括号、全角字符和 +86/0086 国家码。
+//norm 为规范形式，只有数字，固定电话含区号、不含分机号，用于查找和识别同一个病人；
+//display 为显示形式：手机号码为 11 位数字，固定电话为“区号-号码”，有分机时再加“-分机”。
+//没有区号的本地固定电话原样保留号码。空串不算错误
+func parsePhone(s string) (norm, display string, err error) {
+	s = strings.TrimSpace(phoneWidth.Replace(s))
+	if s == "" {
+		return "", "", nil
+	}
+	var ext string
+	for _, mark := range phoneExtMarks {
+		if i := strings.Index(s, mark); i > 0 {
+			s, ext = s[:i], strings.TrimSpace(phoneSeparators.Replace(s[i+len(mark):]))
+			if ext == "" || len(ext) > 6 || !allDigits(ext) {
+				return "", "", errPhoneExt
+			}
+			break
+		}
+	}
+	s = phoneSeparators.Replace(s)
+	intl := true
+	switch {
+	case strings.HasPrefix(s, "+86"):
+		s = s[3:]
+	case strings.HasPrefix(s, "+"):
+		return "", "", errPhoneCountry
+	case strings.HasPrefix(s, "0086"):
+		s = s[4:]
+	case strings.HasPrefix(s, "861") && len(s) == 13:
+		s = s[2:]
+	default:
+		intl = false
+	}
+	//国际格式的固定电话省略了区号前的 0，如 +86 10 62345678
+	if intl && len(s) > 8 && (strings.HasPrefix(s, "10") || s[0] >= '2' && s[0] <= '9') {
+		s = "0" + s
+	}
+	if !allDigits(s) {
+		return "", "", errPhoneChars
+	}
+
+	switch {
+	case s[0] == '1':
+		if len(s) != 11 || s[1] < '3' {
+			return "", "", errPhoneMobile
+		}
+		if ext != "" {
+			return "", "", errPhoneExt
+		}
+		return s, s, nil
+	case s[0] == '0':
+		//北京 010 和 02x 的区号为三位，其余为四位
+		area := 4
+		if strings.HasPrefix(s, "010") || strings.HasPrefix(s, "02") {
+			area = 3
+		}
+		if len(s) <= area || !validLocal(s[area:]) {
+			return "", "", errPhoneLandline
+		}
+		display = s[:area] + "-" + s[area:]
+	default:
+		if !validLocal(s) {
+			return "", "", errPhoneLandline
+		}
+		display = s
+	}
+	if ext != "" {
+		display += "-" + ext
+	}
+	return s, display, nil
+}
+
+//validLocal 不含区号的固定电话号码：7 到 8 位，不以 0 或 1 开头
+func validLocal(s string) bool {
+	return (len(s) == 7 || len(s) == 8) && s[0] != '0' && s[0] != '1'
+}
+
+func allDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//cleanPhone 把电话改为显示形式并填入规范形式，返回电话是否有变化；
+//无法识别的号码原样保留，规范形式只保留其中的数字
+func (foo *Foo) cleanPhone() bool {
+	old := foo.Phone
+	if norm, display, err := parsePhone(foo.Phone); err == nil {
+		foo.Phone, foo.PhoneNorm = display, norm
+	} else {
+		foo.Phone, foo.PhoneNorm = strings.TrimSpace(foo.Phone), normalizePhone(foo.Phone)
+	}
+	return foo.Phone != old
+}
+
+//PhoneIssue 批量规范电话时无法识别的号码
+type PhoneIssue struct {
+	Foo *Foo
+	Err error
+}
+
+//normalizePhones 把全部就诊（含回收站中的）的电话改为显示形式，并记入修改记录，已日结的登记调整；
+//返回改动了电话的条数和无法识别的号码，后者原样保留，由前台核对后手工修改
+func (m *FooModel) normalizePhones(store Store) (int, []PhoneIssue, error) {
+	changed := 0
+	var issues []PhoneIssue
+	for _, item := range m.items {
+		if _, _, err := parsePhone(item.Phone); err != nil {
+			issues = append(issues, PhoneIssue{Foo: item, Err: err})
+		}
+		before := *item
+		phoneChanged := item.cleanPhone()
+		if !phoneChanged && item.PhoneNorm == before.PhoneNorm {
+			continue
+		}
+		if err := store.Update(item); err != nil {
+			*item = before
+			return changed, issues, err
+		}
+		if err := m.audit(store, &before, item); err != nil {
+			return changed, issues, err
+		}
+		if phoneChanged {
+			if err := m.adjustAuto(store, adjustPhone, "批量规范电话", &before, item); err != nil {
+				return changed, issues, err
+			}
+			changed++
+		}
+	}
+	for _, p := range m.patients {
+		_, display, err := parsePhone(p.Phone)
+		if err != nil || display == p.Phone {
+			continue
+		}
+		before := *p
+		p.Phone = display
+		if err := store.UpdatePatient(p); err != nil {
+			*p = before
+			return changed, issues, err
+		}
+	}
+	return changed, issues, nil
+}
+
+//phoneIssueHeader 无法识别的号码导出时的表头
+func phoneIssueHeader() []string {
+	return []string{"姓名", "电话", "登记时间", "原因", "就诊编号"}
+}
+
+func phoneIssueRecords(issues []PhoneIssue) [][]string {
+	records := make([][]string, len(issues))
+	for i, is := range issues {
+		records[i] = []string{is.Foo.Name, is.Foo.Phone, is.Foo.Create.Format("2006-01-02"), is.Err.Error(), is.Foo.ID}
+	}
+	return records
+}
@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func TestParsePhone(t *testing.T) {
+	tests := []struct {
+		s             string
+		norm, display string
+	}{
+		{"", "", ""},
+		{"  ", "", ""},
+		{"13800138000", "13800138000", "13800138000"},
+		{"138 0013 8000", "13800138000", "13800138000"},
+		{"138-0013-8000", "13800138000", "13800138000"},
+		{"１３８００１３８０００", "13800138000", "13800138000"},
+		{"+86 138 0013 8000", "13800138000", "13800138000"},
+		{"+86-13800138000", "13800138000", "13800138000"},
+		{"＋８６１３８００１３８０００", "13800138000", "13800138000"},
+		{"0086 13800138000", "13800138000", "13800138000"},
+		{"008613800138000", "13800138000", "13800138000"},
+		{"8613800138000", "13800138000", "13800138000"},
+		{"010-62345678", "01062345678", "010-62345678"},
+		{"(010)62345678", "01062345678", "010-62345678"},
+		{"（010）62345678", "01062345678", "010-62345678"},
+		{"+86 10 6234 5678", "01062345678", "010-62345678"},
+		{"0086-0571-8765432", "05718765432", "0571-8765432"},
+		{"021 6123 4567", "02161234567", "021-61234567"},
+		{"0571-87654321", "057187654321", "0571-87654321"},
+		{"87654321", "87654321", "87654321"},
+		{"010-62345678转801", "01062345678", "010-62345678-801"},
+		{"021 6123 4567 转 801", "02161234567", "021-61234567-801"},
+		{"010-62345678 ext 12", "01062345678", "010-62345678-12"},
+		{"010-62345678x123456", "01062345678", "010-62345678-123456"},
+		{"010-62345678#8", "01062345678", "010-62345678-8"},
+		{"0571-8765432，1-2", "05718765432", "0571-8765432-12"},
+		{"+86 571 8765 4321", "057187654321", "0571-87654321"},
+	}
+	for _, tt := range tests {
+		norm, display, err := parsePhone(tt.s)
+		if err != nil {
+			t.Errorf("parsePhone(%q): %v", tt.s, err)
+			continue
+		}
+		if norm != tt.norm || display != tt.display {
+			t.Errorf("parsePhone(%q) = %q, %q, want %q, %q", tt.s, norm, display, tt.norm, tt.display)
+		}
+	}
+}
+
+func TestParsePhoneErrors(t *testing.T) {
+	tests := []struct {
+		s   string
+		err error
+	}{
+		{"138abc", errPhoneChars},
+		{"电话 13800138000", errPhoneChars},
+		{"+1 202 555 0100", errPhoneCountry},
+		{"+8613800138000 9", errPhoneMobile},
+		{"1380013800", errPhoneMobile},
+		{"12800138000", errPhoneMobile},
+		{"0086 1280013800", errPhoneMobile},
+		{"13800138000转1", errPhoneExt},
+		{"+86 13800138000 ext 2", errPhoneExt},
+		{"010-62345678转", errPhoneExt},
+		{"010-62345678转1234567", errPhoneExt},
+		{"010-62345678 ext 1a", errPhoneExt},
+		{"010-123", errPhoneLandline},
+		{"0571-0765432", errPhoneLandline},
+		{"0123", errPhoneLandline},
+		{"12345", errPhoneMobile},
+		{"234567", errPhoneLandline},
+	}
+	for _, tt := range tests {
+		norm, display, err := parsePhone(tt.s)
+		if err != tt.err {
+			t.Errorf("parsePhone(%q) = %q, %q, %v, want %v", tt.s, norm, display, err, tt.err)
+		}
+	}
+}
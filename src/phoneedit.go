@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lxn/walk"
+)
+
+//phoneValidator 检查电话号码，错误由 DataBinder 的 ErrorPresenter 显示；
+//与 keep 相同的号码不检查，旧记录的电话没改时仍可保存其他内容
+type phoneValidator struct {
+	keep string
+}
+
+func (pv phoneValidator) Validate(v interface{}) error {
+	s, _ := v.(string)
+	if pv.keep != "" && strings.TrimSpace(s) == strings.TrimSpace(pv.keep) {
+		return nil
+	}
+	if _, _, err := parsePhone(s); err != nil {
+		return walk.NewValidationError("电话号码有误", err.Error())
+	}
+	return nil
+}
+
+//PhoneNumber 电话号码的声明式验证器，用法 Bind("Phone", PhoneNumber{Keep: 原来的号码})
+type PhoneNumber struct {
+	//Keep 修改前的号码，未改动时不检查
+	Keep string
+}
+
+func (pn PhoneNumber) Create() (walk.Validator, error) {
+	return phoneValidator{keep: pn.Keep}, nil
+}
+
+//normalizePhonesAction 批量规范已有记录的电话，无法识别的号码可以导出核对
+func normalizePhonesAction(owner walk.Form) {
+	msg := "把全部记录的电话改为统一的格式（如 13800138000、010-12345678）？\n改动会记入修改记录。"
+	if walk.MsgBox(owner, "规范电话", msg, walk.MsgBoxYesNo|walk.MsgBoxIconQuestion) != walk.DlgCmdYes {
+		return
+	}
+	var changed int
+	var issues []PhoneIssue
+	err := model.commit(func(store Store) error {
+		var err error
+		changed, issues, err = model.normalizePhones(store)
+		return err
+	})
+	if err != nil {
+		walk.MsgBox(owner, "错误", "保存失败："+err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	if len(issues) == 0 {
+		walk.MsgBox(owner, "完成", fmt.Sprintf("已规范 %d 条记录的电话", changed), walk.MsgBoxIconInformation)
+		return
+	}
+	msg = fmt.Sprintf("已规范 %d 条记录的电话，另有 %d 条无法识别，保持原样。\n导出这些记录以便核对？", changed, len(issues))
+	if walk.MsgBox(owner, "完成", msg, walk.MsgBoxYesNo|walk.MsgBoxIconWarning) == walk.DlgCmdYes {
+		name := "电话待核对" + time.Now().Format("20060102") + ".csv"
+		exportTo(owner, "导出无法识别的电话", name, phoneIssueHeader(), phoneIssueRecords(issues))
+	}
+}
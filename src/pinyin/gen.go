@@ -0,0 +1,125 @@
+//go:build ignore
+// +build ignore
+
+//gen 由 pinyin-data（https://github.com/mozillazg/pinyin-data，MIT 协议，
+//数据来自 Unicode Unihan 等）的 pinyin.txt 生成 table.go：
+//
+//	go run gen.go pinyin.txt
+//
+//只收基本区汉字 U+4E00..U+9FA5，去掉声调，ü 写作 v
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//toneless 带调元音到无调字母
+var toneless = strings.NewReplacer(
+	"ā", "a", "á", "a", "ǎ", "a", "à", "a",
+	"ē", "e", "é", "e", "ě", "e", "è", "e", "ế", "e", "ề", "e", "ê", "e",
+	"ī", "i", "í", "i", "ǐ", "i", "ì", "i",
+	"ō", "o", "ó", "o", "ǒ", "o", "ò", "o",
+	"ū", "u", "ú", "u", "ǔ", "u", "ù", "u",
+	"ǖ", "v", "ǘ", "v", "ǚ", "v", "ǜ", "v", "ü", "v",
+	"ń", "n", "ň", "n", "ǹ", "n", "ḿ", "m",
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("用法: go run gen.go pinyin.txt")
+	}
+	in, err := os.Open(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+
+	//primary 每个字最常用的读音，extra 其余读音
+	primary := map[string][]rune{}
+	extra := map[string][]rune{}
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "U+") {
+			continue
+		}
+		code, err := strconv.ParseUint(strings.TrimSpace(parts[0])[2:], 16, 32)
+		if err != nil || code < 0x4E00 || code > 0x9FA5 {
+			continue
+		}
+		r := rune(code)
+		seen := map[string]bool{}
+		for i, py := range strings.Split(strings.TrimSpace(parts[1]), ",") {
+			py = strip(toneless.Replace(strings.TrimSpace(py)))
+			if py == "" || seen[py] {
+				continue
+			}
+			seen[py] = true
+			if i == 0 {
+				primary[py] = append(primary[py], r)
+			} else {
+				extra[py] = append(extra[py], r)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	out, err := os.Create("table.go")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, "// Code generated by gen.go from pinyin-data; DO NOT EDIT.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "package pinyin")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "//primaryTable 每行一个无调拼音及以它为最常用读音的字")
+	writeTable(w, "primaryTable", primary)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "//extraTable 多音字的其它读音")
+	writeTable(w, "extraTable", extra)
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+//strip 去掉单独的组合声调符号，如 ê̄、m̀
+func strip(py string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 0x300 && r <= 0x36F {
+			return -1
+		}
+		return r
+	}, py)
+}
+
+func writeTable(w *bufio.Writer, name string, groups map[string][]rune) {
+	var keys []string
+	for py := range groups {
+		keys = append(keys, py)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(w, "const %s = \"\" +\n", name)
+	for i, py := range keys {
+		chars := groups[py]
+		sort.Slice(chars, func(i, j int) bool { return chars[i] < chars[j] })
+		sep := " +"
+		if i == len(keys)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(w, "\t%q%s\n", py+" "+string(chars)+"\n", sep)
+	}
+}
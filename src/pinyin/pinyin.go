@@ -0,0 +1,263 @@
+//Package pinyin 按拼音匹配中文姓名，支持全拼、首字母、两者混写
+//和常见的模糊音，字表随程序打包（由 gen.go 生成 table.go），不需要联网
+package pinyin
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//surnames 多音字作姓时的读音，姓名的第一个字只按这些读音匹配
+var surnames = map[rune][]string{
+	'单': {"shan"}, '曾': {"zeng"}, '区': {"ou"}, '仇': {"qiu"}, '解': {"xie"},
+	'查': {"zha"}, '朴': {"piao"}, '尉': {"wei", "yu"}, '乐': {"yue", "le"},
+	'覃': {"qin", "tan"}, '翟': {"zhai", "di"}, '盖': {"ge", "gai"}, '缪': {"miao"},
+	'召': {"shao"}, '秘': {"bi"}, '繁': {"po"}, '员': {"yun"}, '种': {"chong"},
+	'折': {"she"}, '句': {"gou"}, '宓': {"mi", "fu"}, '牟': {"mou"}, '那': {"na"},
+	'薄': {"bo"}, '冼': {"xian"}, '粘': {"nian"}, '阚': {"kan"}, '柏': {"bai"},
+	'沈': {"shen"}, '长': {"chang"}, '重': {"chong"}, '任': {"ren"}, '隗': {"wei", "kui"},
+	'万': {"wan"}, '卜': {"bu"}, '藏': {"zang"}, '谌': {"chen"}, '蕃': {"pi", "fan"},
+	'令': {"ling"}, '贲': {"ben"}, '都': {"du"}, '朝': {"chao"}, '澹': {"tan"},
+}
+
+//compoundSurnames 读音特殊的复姓
+var compoundSurnames = map[string][]string{
+	"万俟": {"mo", "qi"},
+	"尉迟": {"yu", "chi"},
+	"单于": {"chan", "yu"},
+	"长孙": {"zhang", "sun"},
+	"澹台": {"tan", "tai"},
+}
+
+var (
+	once     sync.Once
+	readings map[rune][]string
+	//variants 每个拼音连同模糊音的全部写法
+	variants map[string][]string
+)
+
+func load() {
+	readings = map[rune][]string{}
+	variants = map[string][]string{}
+	for _, t := range []string{primaryTable, extraTable} {
+		for _, line := range strings.Split(t, "\n") {
+			i := strings.IndexByte(line, ' ')
+			if i < 0 {
+				continue
+			}
+			py := line[:i]
+			for _, r := range line[i+1:] {
+				readings[r] = append(readings[r], py)
+			}
+			if _, ok := variants[py]; !ok {
+				variants[py] = fuzzy(py)
+			}
+		}
+	}
+}
+
+//Readings 一个字的全部无调读音，最常用的在前，ü 写作 v；不是汉字时返回 nil
+func Readings(r rune) []string {
+	once.Do(load)
+	return readings[r]
+}
+
+//initialPairs 容易混淆的声母
+var initialPairs = [][2]string{{"zh", "z"}, {"ch", "c"}, {"sh", "s"}, {"l", "n"}, {"h", "f"}}
+
+//finalPairs 容易混淆的韵母
+var finalPairs = [][2]string{{"ang", "an"}, {"eng", "en"}, {"ing", "in"}}
+
+//fuzzy 生成一个拼音的模糊写法：zh/z、ch/c、sh/s、l/n、h/f 不分，
+//ang/an、eng/en、ing/in 不分，v 也可以写作 u
+func fuzzy(py string) []string {
+	forms := []string{py}
+	add := func(s string) {
+		for _, f := range forms {
+			if f == s {
+				return
+			}
+		}
+		forms = append(forms, s)
+	}
+	if strings.Contains(py, "v") {
+		add(strings.Replace(py, "v", "u", 1))
+	}
+	for _, f := range append([]string{}, forms...) {
+		for _, p := range initialPairs {
+			if strings.HasPrefix(f, p[0]) {
+				add(p[1] + f[len(p[0]):])
+			} else if strings.HasPrefix(f, p[1]) && !strings.HasPrefix(f, p[1]+"h") {
+				add(p[0] + f[len(p[1]):])
+			}
+		}
+	}
+	for _, f := range append([]string{}, forms...) {
+		for _, p := range finalPairs {
+			if strings.HasSuffix(f, p[0]) {
+				add(f[:len(f)-len(p[0])] + p[1])
+			} else if strings.HasSuffix(f, p[1]) {
+				add(f[:len(f)-len(p[1])] + p[0])
+			}
+		}
+	}
+	return forms
+}
+
+//syllables 每个字可以匹配的写法，name 为 true 时第一个字按姓氏读音；
+//不是汉字的字母和数字按原样，其它字符为 nil，匹配不能跨过它
+func syllables(text string, name bool) [][]string {
+	once.Do(load)
+	runes := []rune(text)
+	syls := make([][]string, 0, len(runes))
+	if name {
+		for compound, pys := range compoundSurnames {
+			if strings.HasPrefix(text, compound) {
+				for _, py := range pys {
+					syls = append(syls, variants[py])
+				}
+				runes = runes[len([]rune(compound)):]
+				name = false
+				break
+			}
+		}
+	}
+	for i, r := range runes {
+		pys := readings[r]
+		if sur, ok := surnames[r]; ok && name && i == 0 {
+			pys = sur
+		}
+		switch {
+		case pys != nil:
+			var forms []string
+			for _, py := range pys {
+				forms = append(forms, variants[py]...)
+			}
+			syls = append(syls, forms)
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			syls = append(syls, []string{strings.ToLower(string(r))})
+		default:
+			syls = append(syls, nil)
+		}
+	}
+	return syls
+}
+
+//normalize 小写并去掉空格和隔音符号，含有字母和数字以外的字符时返回空串
+func normalize(q string) string {
+	q = strings.ToLower(q)
+	q = strings.NewReplacer(" ", "", "'", "", "ü", "v").Replace(q)
+	for _, r := range q {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return ""
+		}
+	}
+	return q
+}
+
+//Match text 中是否有连续的字与 q 的拼音相符，如“张三丰”可以用
+//zhangsan、zs、zhangs、zsf、sanfeng 找到，也可以用 zansan 等模糊音
+func Match(text, q string) bool {
+	return match(syllables(text, false), normalize(q))
+}
+
+//MatchName 与 Match 相同，但姓名的第一个字按姓氏的读音，
+//如“单”读 shan、“曾”读 zeng，复姓“尉迟”读 yuchi
+func MatchName(name, q string) bool {
+	return match(syllables(name, true), normalize(q))
+}
+
+func match(syls [][]string, q string) bool {
+	if q == "" {
+		return false
+	}
+	for start := range syls {
+		if matchFrom(syls[start:], q) {
+			return true
+		}
+	}
+	return false
+}
+
+//matchFrom 从第一个字开始，每个字消耗 q 的全拼或声母，
+//q 的最后一段可以只是某个字拼音的开头
+func matchFrom(syls [][]string, q string) bool {
+	if q == "" {
+		return true
+	}
+	if len(syls) == 0 || syls[0] == nil {
+		return false
+	}
+	for _, form := range syls[0] {
+		if strings.HasPrefix(form, q) {
+			return true
+		}
+		if strings.HasPrefix(q, form) && matchFrom(syls[1:], q[len(form):]) {
+			return true
+		}
+		if initial := initialOf(form); strings.HasPrefix(q, initial) && matchFrom(syls[1:], q[len(initial):]) {
+			return true
+		}
+		if strings.HasPrefix(q, form[:1]) && matchFrom(syls[1:], q[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+//initialOf 拼音的声母，zh、ch、sh 是两个字母，零声母时为首字母
+func initialOf(py string) string {
+	for _, p := range []string{"zh", "ch", "sh"} {
+		if strings.HasPrefix(py, p) {
+			return p
+		}
+	}
+	return py[:1]
+}
+
+//SoundKey 姓名的读音，每个字取最常用的读音（姓按姓氏的读音），模糊音归为同一写法，
+//读音相同的姓名如“张三”“章珊”得到相同的结果；不是汉字的字母和数字按原样，其它字符忽略
+func SoundKey(name string) string {
+	once.Do(load)
+	var pys []string
+	runes := []rune(name)
+	for compound, sur := range compoundSurnames {
+		if strings.HasPrefix(name, compound) {
+			pys = append(pys, sur[0], sur[1])
+			runes = runes[len([]rune(compound)):]
+			break
+		}
+	}
+	for i, r := range runes {
+		switch {
+		case len(pys) == 0 && i == 0 && surnames[r] != nil:
+			pys = append(pys, surnames[r][0])
+		case readings[r] != nil:
+			pys = append(pys, readings[r][0])
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			pys = append(pys, strings.ToLower(string(r)))
+		}
+	}
+	for i, py := range pys {
+		pys[i] = canonical(py)
+	}
+	return strings.Join(pys, " ")
+}
+
+//canonical 把模糊音归为同一写法：按 initialPairs、finalPairs 统一写成后一种
+func canonical(py string) string {
+	py = strings.Replace(py, "v", "u", 1)
+	for _, p := range initialPairs {
+		if strings.HasPrefix(py, p[0]) {
+			py = p[1] + py[len(p[0]):]
+			break
+		}
+	}
+	for _, p := range finalPairs {
+		if strings.HasSuffix(py, p[0]) {
+			return py[:len(py)-len(p[0])] + p[1]
+		}
+	}
+	return py
+}
@@ -0,0 +1,117 @@
+package pinyin
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		text, q string
+		want    bool
+	}{
+		{"张三丰", "zhangsanfeng", true},
+		{"张三丰", "zsf", true},
+		{"张三丰", "zhangs", true},
+		{"张三丰", "zhsf", true},
+		{"张三丰", "sanfeng", true},
+		{"张三丰", "zhang san", true},
+		{"张三丰", "Zhang'San", true},
+		{"张三丰", "zh", true},
+		{"张三丰", "zansan", true},
+		{"张三丰", "zhangsanfen", true},
+		{"张三丰", "zf", false},
+		{"张三丰", "zhangsi", false},
+		{"张三丰", "", false},
+		{"张三丰", "张", false},
+		{"刘丽", "niuli", true},
+		{"刘丽", "ll", true},
+		{"黄芳", "fuangfang", true},
+		{"吕明", "lvming", true},
+		{"吕明", "luming", true},
+		{"吕明", "lumin", true},
+		{"王芳", "wangfan", true},
+		{"重庆", "cq", true},
+		{"重庆", "zq", true},
+		{"张三·丰", "sanfeng", false},
+		{"张三·丰", "zhangsan", true},
+		{"A座3号", "a3", false},
+		{"A座3号", "azuo3", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.text, tt.q); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.text, tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestMatchName(t *testing.T) {
+	tests := []struct {
+		name, q    string
+		want, text bool
+	}{
+		{"单田芳", "shantianfang", true, true},
+		{"单田芳", "stf", true, true},
+		{"单田芳", "dantianfang", false, true},
+		{"曾小贤", "zengxiaoxian", true, true},
+		{"曾小贤", "cengxiaoxian", false, true},
+		{"解晓东", "xiexiaodong", true, true},
+		{"解晓东", "jiexiaodong", false, true},
+		{"仇英", "qiuying", true, true},
+		{"仇英", "chouying", false, true},
+		{"朴成", "piaocheng", true, true},
+		{"尉缭", "weiliao", true, true},
+		{"尉缭", "yuliao", true, true},
+		{"长孙无忌", "zhangsunwuji", true, true},
+		{"长孙无忌", "zswj", true, true},
+		{"长孙无忌", "changsun", false, true},
+		{"尉迟恭", "yuchigong", true, true},
+		{"尉迟恭", "weichigong", false, true},
+		{"万俟卨", "moqi", true, true},
+		{"单于", "chanyu", true, true},
+		{"小单", "xiaoshan", true, true},
+		{"小单", "xiaodan", true, true},
+		{"曾曾", "zengceng", true, true},
+		{"沈丽", "senli", true, true},
+		{"沈丽", "shenni", true, true},
+		{"沈丽", "sl", true, true},
+	}
+	for _, tt := range tests {
+		if got := MatchName(tt.name, tt.q); got != tt.want {
+			t.Errorf("MatchName(%q, %q) = %v, want %v", tt.name, tt.q, got, tt.want)
+		}
+		if got := Match(tt.name, tt.q); got != tt.text {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.name, tt.q, got, tt.text)
+		}
+	}
+}
+
+func TestSoundKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"张三", "章珊", true},
+		{"张三", "张 三", true},
+		{"刘丽", "牛力", true},
+		{"王芳", "汪方", true},
+		{"王芳", "万方", true},
+		{"陈晨", "程成", true},
+		{"黄海", "皇海", true},
+		{"吕明", "卢敏", true},
+		{"单田芳", "山甜方", true},
+		{"单田芳", "丹田芳", false},
+		{"曾小贤", "增晓娴", true},
+		{"曾小贤", "层晓娴", false},
+		{"尉迟恭", "玉池工", true},
+		{"长孙", "张孙", true},
+		{"张三", "张四", false},
+		{"张三", "张三丰", false},
+	}
+	for _, tt := range tests {
+		ka, kb := SoundKey(tt.a), SoundKey(tt.b)
+		if (ka == kb) != tt.same {
+			t.Errorf("SoundKey(%q) = %q, SoundKey(%q) = %q, same = %v", tt.a, ka, tt.b, kb, tt.same)
+		}
+	}
+	if got := SoundKey("张三"); got != "zan san" {
+		t.Errorf(`SoundKey("张三") = %q, want "zan san"`, got)
+	}
+}
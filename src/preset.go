@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//firstDay 查询默认的开始日期，早于最早的记录
+var firstDay = time.Date(2018, 12, 1, 0, 0, 0, 0, time.Local)
+
+//Preset 保存的查询条件。Start、End 为 2006-01-02 的日期，
+//或 -30d 这样相对今天的天数；Start 为空从 firstDay 起，End 为空到今天为止
+type Preset struct {
+	Name    string `json:"name"`
+	Patient string `json:"patient,omitempty"`
+	Phone   string `json:"phone,omitempty"`
+	Start   string `json:"start,omitempty"`
+	End     string `json:"end,omitempty"`
+	Query   string `json:"query,omitempty"`
+}
+
+//defaultPresets 第一次运行时提供的常用条件
+var defaultPresets = []Preset{
+	{Name: "近7天就诊", Start: "-7d"},
+	{Name: "欠费超30天", End: "-30d", Query: "owed>0"},
+}
+
+func today(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+//newPreset 把当前的查询存为条件，relative 时日期存为相对今天的天数；
+//结束日期不早于今天时存为空，以后使用时仍然查到当天
+func newPreset(name string, s *Search, relative bool, now time.Time) Preset {
+	p := Preset{Name: name, Patient: s.Name, Phone: s.Phone, Query: s.Query}
+	day := today(now)
+	if !today(s.Start).Equal(today(firstDay)) {
+		p.Start = formatDay(s.Start, day, relative)
+	}
+	//End 是查询的上界（不含），今天的记录要求 End 在明天或之后
+	if today(s.End).Before(day.AddDate(0, 0, 1)) {
+		p.End = formatDay(s.End, day, relative)
+	}
+	return p
+}
+
+func formatDay(t, day time.Time, relative bool) string {
+	if !relative {
+		return t.Format("2006-01-02")
+	}
+	//按小时换算再取整，避开夏令时那一天只有 23 或 25 小时
+	days := int(math.Round(today(t).Sub(day).Hours() / 24))
+	return fmt.Sprintf("%+dd", days)
+}
+
+//parseDay 解析 Preset 中的日期，empty 为空串时的值
+func parseDay(v string, day, empty time.Time) (time.Time, error) {
+	if v == "" {
+		return empty, nil
+	}
+	if strings.HasSuffix(v, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s 不是有效的天数", v)
+		}
+		return day.AddDate(0, 0, n), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, day.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s 不是有效的日期", v)
+	}
+	return t, nil
+}
+
+//apply 把条件填入 s 并解析其中的查询语言，出错时不修改 s
+func (p Preset) apply(s *Search, now time.Time) error {
+	day := today(now)
+	start, err := parseDay(p.Start, day, firstDay)
+	if err != nil {
+		return err
+	}
+	end, err := parseDay(p.End, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	next := Search{Name: p.Patient, Phone: p.Phone, Start: start, End: end, Query: p.Query}
+	if err := next.parse(); err != nil {
+		return err
+	}
+	*s = next
+	return nil
+}
+
+//putPreset 按名称加入或替换条件
+func putPreset(presets []Preset, p Preset) []Preset {
+	for i := range presets {
+		if presets[i].Name == p.Name {
+			presets[i] = p
+			return presets
+		}
+	}
+	return append(presets, p)
+}
+
+//removePreset 按名称删除条件
+func removePreset(presets []Preset, name string) []Preset {
+	var kept []Preset
+	for _, p := range presets {
+		if p.Name != name {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+func presetNames(presets []Preset) []string {
+	names := make([]string, len(presets))
+	for i, p := range presets {
+		names[i] = p.Name
+	}
+	return names
+}
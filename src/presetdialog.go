@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+//PresetDialog 把当前查询存为常用条件，或删除已有的条件，返回修改后的全部条件
+func PresetDialog(owner walk.Form, presets []Preset) []Preset {
+	var dlg *walk.Dialog
+	var nameCB *walk.ComboBox
+	var relativeCB *walk.CheckBox
+	var acceptPB, cancelPB *walk.PushButton
+
+	save := func(next []Preset) {
+		if err := savePresets(next); err != nil {
+			walk.MsgBox(dlg, "错误", "保存设置失败："+err.Error(), walk.MsgBoxIconError)
+			return
+		}
+		presets = next
+		dlg.Accept()
+	}
+
+	_, _ = Dialog{
+		AssignTo:      &dlg,
+		Title:         "常用条件",
+		DefaultButton: &acceptPB,
+		CancelButton:  &cancelPB,
+		MinSize:       Size{Width: 300},
+		Layout:        VBox{},
+		Children: []Widget{
+			Composite{
+				Layout: Grid{Columns: 2},
+				Children: []Widget{
+					Label{Text: "名称:"},
+					ComboBox{
+						AssignTo: &nameCB,
+						Editable: true,
+						Model:    presetNames(presets),
+					},
+					Label{Text: "日期:"},
+					CheckBox{
+						AssignTo:    &relativeCB,
+						Text:        "按相对今天的天数保存",
+						ToolTipText: "如开始日期为 7 天前，以后使用时仍从使用当天的 7 天前开始",
+					},
+				},
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						AssignTo: &acceptPB,
+						Text:     "保存",
+						OnClicked: func() {
+							name := strings.TrimSpace(nameCB.Text())
+							if name == "" {
+								walk.MsgBox(dlg, "提示", "请填写名称", walk.MsgBoxIconInformation)
+								return
+							}
+							p := newPreset(name, model.search, relativeCB.Checked(), time.Now())
+							save(putPreset(append([]Preset{}, presets...), p))
+						},
+					},
+					PushButton{
+						Text: "删除",
+						OnClicked: func() {
+							save(removePreset(presets, strings.TrimSpace(nameCB.Text())))
+						},
+					},
+					PushButton{
+						AssignTo:  &cancelPB,
+						Text:      "取消",
+						OnClicked: func() { dlg.Cancel() },
+					},
+				},
+			},
+		},
+	}.Run(owner)
+	return presets
+}
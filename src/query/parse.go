@@ -0,0 +1,443 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+//SyntaxError 查询写错的位置，Pos 从 0 开始按字符计
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("第 %d 个字符：%s", e.Pos+1, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokTerm
+	tokLParen
+	tokRParen
+	tokNot
+	tokOr
+)
+
+type token struct {
+	kind tokenKind
+	pos  int
+	//text 条件的原文，引号已去掉
+	text string
+	//quoted 值用引号括起来，不再识别字段和运算符
+	quoted bool
+}
+
+//lex 把查询切分成条件、括号和 OR
+func lex(q string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(q) {
+		r, size := utf8.DecodeRuneInString(q[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(':
+			toks = append(toks, token{kind: tokLParen, pos: i})
+			i++
+		case r == ')':
+			toks = append(toks, token{kind: tokRParen, pos: i})
+			i++
+		case r == '-' && i+1 < len(q) && !isSpace(q[i+1:]):
+			toks = append(toks, token{kind: tokNot, pos: i})
+			i++
+		default:
+			start := i
+			var b strings.Builder
+			quoted := false
+			for i < len(q) {
+				r, size := utf8.DecodeRuneInString(q[i:])
+				if unicode.IsSpace(r) || r == '(' || r == ')' {
+					break
+				}
+				if r != '"' {
+					b.WriteRune(r)
+					i += size
+					continue
+				}
+				end := strings.IndexByte(q[i+1:], '"')
+				if end < 0 {
+					return nil, errorAt(q, i, "引号没有配对")
+				}
+				quoted = true
+				b.WriteString(q[i+1 : i+1+end])
+				i += end + 2
+			}
+			text := b.String()
+			if !quoted && (text == "OR" || text == "或") {
+				toks = append(toks, token{kind: tokOr, pos: start})
+			} else if quoted || text != "AND" {
+				toks = append(toks, token{kind: tokTerm, pos: start, text: text, quoted: quoted && strings.HasPrefix(q[start:], `"`)})
+			}
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(q)}), nil
+}
+
+func isSpace(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsSpace(r)
+}
+
+func errorAt(q string, pos int, format string, args ...interface{}) error {
+	return &SyntaxError{Pos: utf8.RuneCountInString(q[:pos]), Msg: fmt.Sprintf(format, args...)}
+}
+
+type parser struct {
+	q      string
+	toks   []token
+	next   int
+	schema *Schema
+}
+
+//Parse 把查询解析成语法树，空查询返回 nil
+func Parse(q string, schema *Schema) (Node, error) {
+	toks, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{q: q, toks: toks, schema: schema}
+	if p.peek().kind == tokEOF {
+		return nil, nil
+	}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorAt(t, "多余的右括号")
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.toks[p.next]
+	if t.kind != tokEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) errorAt(t token, format string, args ...interface{}) error {
+	return errorAt(p.q, t.pos, format, args...)
+}
+
+//or := and { OR and }
+func (p *parser) or() (Node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.take()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+//and := unary { unary }
+func (p *parser) and() (Node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek().kind {
+		case tokEOF, tokOr, tokRParen:
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &And{Left: left, Right: right}
+	}
+}
+
+//unary := - unary | ( or ) | term
+func (p *parser) unary() (Node, error) {
+	t := p.take()
+	switch t.kind {
+	case tokNot:
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{X: x}, nil
+	case tokLParen:
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if r := p.take(); r.kind != tokRParen {
+			return nil, p.errorAt(t, "括号没有配对")
+		}
+		return x, nil
+	case tokTerm:
+		return p.term(t)
+	case tokOr:
+		return nil, p.errorAt(t, "OR 前后都要有条件")
+	case tokRParen:
+		return nil, p.errorAt(t, "多余的右括号")
+	}
+	return nil, p.errorAt(t, "缺少条件")
+}
+
+//ops 按先长后短的顺序匹配
+var ops = []string{">=", "<=", ":", "=", ">", "<"}
+
+//term 解析一个条件：字段 运算符 值，或者不带字段的词
+func (p *parser) term(t token) (Node, error) {
+	if !t.quoted {
+		for i, r := range t.text {
+			for _, op := range ops {
+				if !strings.HasPrefix(t.text[i:], op) {
+					continue
+				}
+				name := t.text[:i]
+				if name == "" {
+					return nil, p.errorAt(t, "%s 前缺少字段名", op)
+				}
+				f, ok := p.schema.lookup(name)
+				if !ok {
+					return nil, p.errorAt(t, "未知的字段 %s", name)
+				}
+				value := t.text[i+len(op):]
+				if value == "" {
+					return nil, p.errorAt(t, "%s 后缺少值", name+op)
+				}
+				n, err := p.compare(f, op, value)
+				if err != nil {
+					return nil, p.errorAt(t, "%s", err)
+				}
+				return n, nil
+			}
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+				break
+			}
+		}
+	}
+	n := &Contains{Fields: p.schema.defaults, Value: strings.ToLower(t.text)}
+	for _, name := range n.Fields {
+		f, _ := p.schema.lookup(name)
+		n.match = append(n.match, f.Match)
+	}
+	return n, nil
+}
+
+func (p *parser) compare(f Field, op, value string) (Node, error) {
+	switch f.Kind {
+	case Text, Enum:
+		if op != ":" && op != "=" {
+			return nil, fmt.Errorf("%s 只能用 : 或 =", f.Name)
+		}
+		return &Contains{
+			Fields: []string{f.Name},
+			Value:  strings.ToLower(value),
+			Exact:  op == "=" || f.Kind == Enum,
+			match:  []func(text, value string) bool{f.Match},
+		}, nil
+	case Number:
+		return p.numberRange(f, op, value)
+	case Date:
+		return p.period(f, op, value)
+	}
+	return nil, fmt.Errorf("字段 %s 的类型未知", f.Name)
+}
+
+//splitRange 拆分 a..b，不是范围时 ok 为 false
+func splitRange(op, value string) (lo, hi string, ok bool) {
+	if op != ":" {
+		return "", "", false
+	}
+	i := strings.Index(value, "..")
+	if i < 0 {
+		return "", "", false
+	}
+	return value[:i], value[i+2:], true
+}
+
+func (p *parser) numberRange(f Field, op, value string) (Node, error) {
+	n := &Range{Field: f.Name}
+	if lo, hi, ok := splitRange(op, value); ok {
+		if lo == "" && hi == "" {
+			return nil, fmt.Errorf("范围 %s 两端都是空的", value)
+		}
+		var err error
+		if lo != "" {
+			if n.Lo, err = parseScaled(lo, f.Scale); err != nil {
+				return nil, err
+			}
+			n.HasLo = true
+		}
+		if hi != "" {
+			if n.Hi, err = parseScaled(hi, f.Scale); err != nil {
+				return nil, err
+			}
+			n.HasHi = true
+		}
+		if n.HasLo && n.HasHi && n.Lo > n.Hi {
+			return nil, fmt.Errorf("范围 %s 的下限大于上限", value)
+		}
+		return n, nil
+	}
+
+	v, err := parseScaled(value, f.Scale)
+	if err != nil {
+		return nil, err
+	}
+	//都是整数，> 和 < 可以换成包含端点的范围
+	switch op {
+	case ":", "=":
+		n.Lo, n.Hi, n.HasLo, n.HasHi = v, v, true, true
+	case ">":
+		n.Lo, n.HasLo = v+1, true
+	case ">=":
+		n.Lo, n.HasLo = v, true
+	case "<":
+		n.Hi, n.HasHi = v-1, true
+	case "<=":
+		n.Hi, n.HasHi = v, true
+	}
+	return n, nil
+}
+
+//parseScaled 按十进制精确解析，小数位数不能超过 scale 能表示的位数
+func parseScaled(s string, scale int64) (int64, error) {
+	if scale == 0 {
+		scale = 1
+	}
+	neg := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	intPart, frac := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		intPart, frac = digits[:i], digits[i+1:]
+	}
+	bad := fmt.Errorf("%s 不是有效的数值", s)
+	if intPart == "" {
+		return 0, bad
+	}
+	v, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil {
+		return 0, bad
+	}
+	v *= scale
+	unit := scale
+	for _, c := range frac {
+		unit /= 10
+		if c < '0' || c > '9' {
+			return 0, bad
+		}
+		if unit == 0 {
+			return 0, fmt.Errorf("%s 的小数位数太多", s)
+		}
+		v += int64(c-'0') * unit
+	}
+	if neg {
+		v = -v
+	}
+	return v, nil
+}
+
+func (p *parser) period(f Field, op, value string) (Node, error) {
+	n := &Period{Field: f.Name}
+	loc := p.schema.location()
+	if lo, hi, ok := splitRange(op, value); ok {
+		if lo == "" && hi == "" {
+			return nil, fmt.Errorf("范围 %s 两端都是空的", value)
+		}
+		if lo != "" {
+			from, _, err := parseDate(lo, loc)
+			if err != nil {
+				return nil, err
+			}
+			n.From = from
+		}
+		if hi != "" {
+			_, to, err := parseDate(hi, loc)
+			if err != nil {
+				return nil, err
+			}
+			n.To = to
+		}
+		if !n.From.IsZero() && !n.To.IsZero() && !n.From.Before(n.To) {
+			return nil, fmt.Errorf("范围 %s 的开始晚于结束", value)
+		}
+		return n, nil
+	}
+
+	from, to, err := parseDate(value, loc)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case ":", "=":
+		n.From, n.To = from, to
+	case ">":
+		n.From = to
+	case ">=":
+		n.From = from
+	case "<":
+		n.To = from
+	case "<=":
+		n.To = to
+	}
+	return n, nil
+}
+
+//parseDate 解析 2023、2023-06 或 2023-06-15（也可以用 /），
+//返回这段时间的开始和下一段的开始
+func parseDate(s string, loc *time.Location) (from, to time.Time, err error) {
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == '-' || r == '/' })
+	if len(parts) == 0 || len(parts) > 3 || strings.Count(s, "-")+strings.Count(s, "/") != len(parts)-1 {
+		return from, to, fmt.Errorf("%s 不是有效的日期", s)
+	}
+	nums := []int{0, 1, 1}
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return from, to, fmt.Errorf("%s 不是有效的日期", s)
+		}
+		nums[i] = v
+	}
+	if nums[1] < 1 || nums[1] > 12 || nums[2] < 1 || nums[2] > 31 {
+		return from, to, fmt.Errorf("%s 不是有效的日期", s)
+	}
+	from = time.Date(nums[0], time.Month(nums[1]), nums[2], 0, 0, 0, 0, loc)
+	if from.Day() != nums[2] {
+		return from, to, fmt.Errorf("%s 不是有效的日期", s)
+	}
+	switch len(parts) {
+	case 1:
+		to = from.AddDate(1, 0, 0)
+	case 2:
+		to = from.AddDate(0, 1, 0)
+	default:
+		to = from.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
@@ -0,0 +1,213 @@
+//Package query 就诊记录的查询语言。
+//
+//查询由空格分隔的条件组成，条件之间是“并且”，OR 表示“或者”，
+//前缀 - 表示“不是”，括号改变优先级：
+//
+//	diag:腰痛 sex:女 age:30..40 owed>0 fee>=200 created:2023-01..2023-06
+//	(name:张 OR name:李) -addr:城关
+//
+//字段:值 对文本字段是包含，对数值和日期是等于或范围（两端都包含，可省略一端）；
+//>、>=、<、<= 比较数值和日期，日期可以写到年、月或日，按整段时间比较；
+//不带字段的词在默认字段中查找包含；含空格的值用双引号括起来。
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//Kind 字段的类型
+type Kind int
+
+const (
+	//Text 文本，字段:值 为包含，= 为相等，不区分大小写
+	Text Kind = iota
+	//Enum 枚举，如性别，字段:值 为相等
+	Enum
+	//Number 整数或定点小数，按 Scale 换算成整数比较
+	Number
+	//Date 时间，按年、月或日为单位比较
+	Date
+)
+
+//Field 可以查询的一个字段
+type Field struct {
+	Name    string
+	Aliases []string
+	Kind    Kind
+	//Scale 定点小数的倍数，如金额以分保存时为 100，0 表示整数
+	Scale int64
+	//Match 文本字段在包含之外的匹配方式，如姓名的拼音，value 已转为小写
+	Match func(text, value string) bool
+}
+
+//Schema 可以查询的全部字段
+type Schema struct {
+	fields   map[string]Field
+	defaults []string
+	//Location 日期按哪个时区解释，nil 为本地时区
+	Location *time.Location
+}
+
+//NewSchema defaults 是不带字段的词所查找的文本字段
+func NewSchema(defaults []string, fields ...Field) *Schema {
+	s := &Schema{fields: map[string]Field{}, defaults: defaults}
+	for _, f := range fields {
+		s.fields[strings.ToLower(f.Name)] = f
+		for _, a := range f.Aliases {
+			s.fields[strings.ToLower(a)] = f
+		}
+	}
+	return s
+}
+
+func (s *Schema) lookup(name string) (Field, bool) {
+	f, ok := s.fields[strings.ToLower(name)]
+	return f, ok
+}
+
+func (s *Schema) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
+}
+
+//Record 被查询的一条记录，field 为 Field.Name
+type Record interface {
+	Text(field string) string
+	Number(field string) int64
+	Time(field string) time.Time
+}
+
+//Node 查询语法树的节点
+type Node interface {
+	Eval(r Record) bool
+	String() string
+}
+
+//Match 空查询匹配全部记录
+func Match(n Node, r Record) bool {
+	return n == nil || n.Eval(r)
+}
+
+//And 两个条件都满足
+type And struct {
+	Left, Right Node
+}
+
+func (n *And) Eval(r Record) bool {
+	return n.Left.Eval(r) && n.Right.Eval(r)
+}
+
+func (n *And) String() string {
+	return "(" + n.Left.String() + " AND " + n.Right.String() + ")"
+}
+
+//Or 满足任一条件
+type Or struct {
+	Left, Right Node
+}
+
+func (n *Or) Eval(r Record) bool {
+	return n.Left.Eval(r) || n.Right.Eval(r)
+}
+
+func (n *Or) String() string {
+	return "(" + n.Left.String() + " OR " + n.Right.String() + ")"
+}
+
+//Not 不满足条件
+type Not struct {
+	X Node
+}
+
+func (n *Not) Eval(r Record) bool {
+	return !n.X.Eval(r)
+}
+
+func (n *Not) String() string {
+	return "NOT " + n.X.String()
+}
+
+//Contains 任一字段包含 Value，Exact 时要求相等；Value 已转为小写
+type Contains struct {
+	Fields []string
+	Value  string
+	Exact  bool
+	//match 与 Fields 对应的 Field.Match
+	match []func(text, value string) bool
+}
+
+func (n *Contains) Eval(r Record) bool {
+	for i, f := range n.Fields {
+		text := r.Text(f)
+		v := strings.ToLower(text)
+		if n.Exact && v == n.Value || !n.Exact && strings.Contains(v, n.Value) {
+			return true
+		}
+		if !n.Exact && i < len(n.match) && n.match[i] != nil && n.match[i](text, n.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Contains) String() string {
+	op := "~"
+	if n.Exact {
+		op = "="
+	}
+	return strings.Join(n.Fields, "|") + op + fmt.Sprintf("%q", n.Value)
+}
+
+//Range 数值在 [Lo, Hi] 之间，HasLo/HasHi 为 false 的一端不限
+type Range struct {
+	Field        string
+	Lo, Hi       int64
+	HasLo, HasHi bool
+}
+
+func (n *Range) Eval(r Record) bool {
+	v := r.Number(n.Field)
+	return (!n.HasLo || v >= n.Lo) && (!n.HasHi || v <= n.Hi)
+}
+
+func (n *Range) String() string {
+	s := n.Field + ":"
+	if n.HasLo {
+		s += fmt.Sprint(n.Lo)
+	}
+	s += ".."
+	if n.HasHi {
+		s += fmt.Sprint(n.Hi)
+	}
+	return s
+}
+
+//Period 时间在 [From, To) 之间，零值的一端不限；零值时间不满足任何 Period
+type Period struct {
+	Field    string
+	From, To time.Time
+}
+
+func (n *Period) Eval(r Record) bool {
+	t := r.Time(n.Field)
+	if t.IsZero() {
+		return false
+	}
+	return (n.From.IsZero() || !t.Before(n.From)) && (n.To.IsZero() || t.Before(n.To))
+}
+
+func (n *Period) String() string {
+	s := n.Field + ":["
+	if !n.From.IsZero() {
+		s += n.From.Format("2006-01-02")
+	}
+	s += ","
+	if !n.To.IsZero() {
+		s += n.To.Format("2006-01-02")
+	}
+	return s + ")"
+}
@@ -0,0 +1,224 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+var testSchema = NewSchema([]string{"name", "diag"},
+	Field{Name: "name", Aliases: []string{"姓名"}, Kind: Text, Match: initials},
+	Field{Name: "diag", Aliases: []string{"诊断"}, Kind: Text},
+	Field{Name: "sex", Kind: Enum},
+	Field{Name: "age", Kind: Number},
+	Field{Name: "fee", Kind: Number, Scale: 100},
+	Field{Name: "owed", Kind: Number, Scale: 100},
+	Field{Name: "created", Kind: Date},
+)
+
+func init() {
+	testSchema.Location = time.UTC
+}
+
+type rec struct {
+	text    map[string]string
+	num     map[string]int64
+	created time.Time
+}
+
+//initials 测试用的匹配方式，代替拼音首字母
+func initials(text, value string) bool {
+	return map[string]string{"王芳": "wf", "李强": "lq"}[text] == value
+}
+
+func (r rec) Text(f string) string    { return r.text[f] }
+func (r rec) Number(f string) int64   { return r.num[f] }
+func (r rec) Time(f string) time.Time { return r.created }
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"", "<nil>"},
+		{"  ", "<nil>"},
+		{"张三", `name|diag~"张三"`},
+		{"diag:腰痛", `diag~"腰痛"`},
+		{"诊断:腰痛", `diag~"腰痛"`},
+		{"name=张三", `name="张三"`},
+		{"sex:女", `sex="女"`},
+		{"age:30..40", "age:30..40"},
+		{"age:30..", "age:30.."},
+		{"age:..40", "age:..40"},
+		{"age>30", "age:31.."},
+		{"age<30", "age:..29"},
+		{"owed>0", "owed:1.."},
+		{"fee>=200", "fee:20000.."},
+		{"fee<=199.5", "fee:..19950"},
+		{"fee:12.34", "fee:1234..1234"},
+		{"created:2023-01..2023-06", "created:[2023-01-01,2023-07-01)"},
+		{"created:2023", "created:[2023-01-01,2024-01-01)"},
+		{"created>2023-02", "created:[2023-03-01,)"},
+		{"created<=2023/02/28", "created:[,2023-03-01)"},
+		{"created<2023-02-28", "created:[,2023-02-28)"},
+		{"a b", `(name|diag~"a" AND name|diag~"b")`},
+		{"a AND b", `(name|diag~"a" AND name|diag~"b")`},
+		{"a OR b c", `(name|diag~"a" OR (name|diag~"b" AND name|diag~"c"))`},
+		{"a 或 b", `(name|diag~"a" OR name|diag~"b")`},
+		{"-a", `NOT name|diag~"a"`},
+		{"-(a OR b)", `NOT (name|diag~"a" OR name|diag~"b")`},
+		{"(a OR b) c", `((name|diag~"a" OR name|diag~"b") AND name|diag~"c")`},
+		{`diag:"腰 痛"`, `diag~"腰 痛"`},
+		{`"age:30"`, `name|diag~"age:30"`},
+		{"Diag:ABC", `diag~"abc"`},
+		{"1.5", `name|diag~"1.5"`},
+		{"a - b", `((name|diag~"a" AND name|diag~"-") AND name|diag~"b")`},
+	}
+	for _, tt := range tests {
+		n, err := Parse(tt.q, testSchema)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.q, err)
+			continue
+		}
+		got := "<nil>"
+		if n != nil {
+			got = n.String()
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		q   string
+		pos int
+	}{
+		{"foo:1", 0},
+		{"age>abc", 0},
+		{"age:40..30", 0},
+		{"age:..", 0},
+		{"fee>1.234", 0},
+		{"sex>女", 0},
+		{"created:2023-13", 0},
+		{"created:2023-02-30", 0},
+		{"created:2023-06..2023-01", 0},
+		{"a (b", 2},
+		{"a )", 2},
+		{"a OR", 4},
+		{"OR a", 0},
+		{`diag:"腰痛`, 5},
+		{"诊断:", 0},
+		{">3", 0},
+		{"张三 年龄:3", 3},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.q, testSchema)
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("Parse(%q) error = %v, want SyntaxError", tt.q, err)
+			continue
+		}
+		if se.Pos != tt.pos {
+			t.Errorf("Parse(%q) error at %d, want %d: %v", tt.q, se.Pos, tt.pos, se)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	day := func(s string) time.Time {
+		v, _ := time.Parse("2006-01-02 15:04", s)
+		return v
+	}
+	a := rec{
+		text:    map[string]string{"name": "王芳", "diag": "腰痛 L4", "sex": "女"},
+		num:     map[string]int64{"age": 35, "fee": 20000, "owed": 5000},
+		created: day("2023-03-31 23:59"),
+	}
+	b := rec{
+		text:    map[string]string{"name": "李强", "diag": "颈椎病", "sex": "男"},
+		num:     map[string]int64{"age": 52, "fee": 19999, "owed": 0},
+		created: day("2023-07-01 00:00"),
+	}
+	var zero rec
+
+	tests := []struct {
+		q          string
+		a, b, zero bool
+	}{
+		{"", true, true, true},
+		{"diag:腰痛 sex:女 age:30..40 owed>0 fee>=200 created:2023-01..2023-06", true, false, false},
+		{"diag:l4", true, false, false},
+		{"王", true, false, false},
+		{"sex:男", false, true, false},
+		{"sex:男 OR age:35", true, true, false},
+		{"-sex:男", true, false, true},
+		{"fee>=200", true, false, false},
+		{"fee<200", false, true, true},
+		{"owed:0", false, true, true},
+		{"age:..35", true, false, true},
+		{"age:35..", true, true, false},
+		{"created:2023-03", true, false, false},
+		{"created>2023-06", false, true, false},
+		{"created>=2023-07-01", false, true, false},
+		{"created<2023-04", true, false, false},
+		{"created:..2023-06", true, false, false},
+		{"-created:2023", false, false, true},
+		{"(name:王 OR name:李) -颈椎", true, false, false},
+		{"name=王芳", true, false, false},
+		{"name=王", false, false, false},
+		{"name:wf", true, false, false},
+		{"wf", true, false, false},
+		{"name=wf", false, false, false},
+	}
+	for _, tt := range tests {
+		n, err := Parse(tt.q, testSchema)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.q, err)
+			continue
+		}
+		for _, c := range []struct {
+			name string
+			r    rec
+			want bool
+		}{{"a", a, tt.a}, {"b", b, tt.b}, {"zero", zero, tt.zero}} {
+			if got := Match(n, c.r); got != c.want {
+				t.Errorf("Match(%q, %s) = %v, want %v", tt.q, c.name, got, c.want)
+			}
+		}
+	}
+}
+
+func TestEvalLocation(t *testing.T) {
+	cst := time.FixedZone("CST", 8*3600)
+	schema := *testSchema
+	schema.Location = cst
+	//同一时刻：北京时间 4 月 1 日 07:30，UTC 3 月 31 日 23:30
+	r := rec{created: time.Date(2023, 3, 31, 23, 30, 0, 0, time.UTC)}
+
+	tests := []struct {
+		q        string
+		loc, utc bool
+	}{
+		{"created:2023-03", false, true},
+		{"created:2023-04", true, false},
+		{"created:2023-04-01", true, false},
+		{"created<2023-04", false, true},
+	}
+	for _, tt := range tests {
+		for _, c := range []struct {
+			name   string
+			schema *Schema
+			want   bool
+		}{{"CST", &schema, tt.loc}, {"UTC", testSchema, tt.utc}} {
+			n, err := Parse(tt.q, c.schema)
+			if err != nil {
+				t.Errorf("Parse(%q): %v", tt.q, err)
+				continue
+			}
+			if got := Match(n, r); got != c.want {
+				t.Errorf("Match(%q) in %s = %v, want %v", tt.q, c.name, got, c.want)
+			}
+		}
+	}
+}
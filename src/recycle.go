@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+//retainDays 回收站中的记录保留多少天，之后启动时彻底删除；0 表示一直保留
+var retainDays int
+
+//trash 回收站中的记录，最近删除的在前
+func (m *FooModel) trash() []*Foo {
+	var foos []*Foo
+	for _, item := range m.items {
+		if item.Deleted {
+			foos = append(foos, item)
+		}
+	}
+	sort.SliceStable(foos, func(i, j int) bool {
+		return foos[i].DeletedAt.After(foos[j].DeletedAt)
+	})
+	return foos
+}
+
+//restore 把记录从回收站恢复，之后由调用方重新查询
+func (m *FooModel) restore(store Store, foo *Foo) error {
+	before := *foo
+	foo.Deleted = false
+	foo.DeletedAt = time.Time{}
+	foo.Checked = false
+	if err := store.Update(foo); err != nil {
+		*foo = before
+		return err
+	}
+	m.reindex(foo)
+	return m.audit(store, &before, foo)
+}
+
+//purge 彻底删除回收站中的记录，修改记录仍然保留并记下这次清除；
+//就诊或收款所在的日期已日结时拒绝，见 lockedBy
+func (m *FooModel) purge(store Store, foo *Foo) error {
+	if c := m.lockedBy(foo); c != nil {
+		return fmt.Errorf("%s 的记录涉及已日结的 %s，不能彻底删除", foo.Name, dayKey(c.Day))
+	}
+	if err := store.Purge(foo); err != nil {
+		return err
+	}
+	for i, item := range m.items {
+		if item == foo {
+			m.items = append(m.items[:i], m.items[i+1:]...)
+			break
+		}
+	}
+	m.index.Remove(foo.ID)
+	c := &Change{VisitID: foo.ID, Field: "记录", Old: "回收站", New: "彻底删除", At: time.Now(), Operator: operator}
+	if err := store.InsertChange(c); err != nil {
+		return err
+	}
+	m.changes[c.VisitID] = append(m.changes[c.VisitID], c)
+	return nil
+}
+
+//purgeExpired 彻底删除 before 之前删除的记录，返回删除的条数；涉及已日结日期的记录一直保留
+func (m *FooModel) purgeExpired(store Store, before time.Time) (int, error) {
+	var n int
+	for _, foo := range m.trash() {
+		if foo.DeletedAt.Before(before) && m.lockedBy(foo) == nil {
+			if err := m.purge(store, foo); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gaoyangtok/medic/src/stats"
+)
+
+//statsVisits 未删除的就诊及其有效收款，交给 stats 统计；需持有读锁
+func (m *FooModel) statsVisits() []stats.Visit {
+	var visits []stats.Visit
+	for _, item := range m.items {
+		if item.Deleted {
+			continue
+		}
+		visits = append(visits, statsVisit(item))
+	}
+	return visits
+}
+
+func statsVisit(item *Foo) stats.Visit {
+	v := stats.Visit{At: item.Create, Billed: int64(item.RealFee)}
+	for _, p := range item.Payments {
+		if !p.Voided {
+			v.Payments = append(v.Payments, stats.Payment{At: p.At, Amount: int64(p.Amount)})
+		}
+	}
+	return v
+}
+
+//chartPeriods 统计窗口可选的时间段
+var chartPeriods = []stats.Period{stats.Day, stats.Week, stats.Month, stats.Quarter, stats.Year}
+
+//chartMaxBuckets 一张图最多的时间段数，再多柱子就挤在一起了
+const chartMaxBuckets = 400
+
+//chartMetric 统计图可以显示的金额
+type chartMetric struct {
+	Title string
+	Value func(b stats.Bucket) int64
+	//Balance 是期末余额而不是期间发生额，合计时取最后一段
+	Balance bool
+	//Count 是次数而不是金额
+	Count bool
+}
+
+var chartMetrics = []chartMetric{
+	{Title: "已收", Value: func(b stats.Bucket) int64 { return b.Collected }},
+	{Title: "应收", Value: func(b stats.Bucket) int64 { return b.Billed }},
+	{Title: "欠费余额", Value: func(b stats.Bucket) int64 { return b.Outstanding }, Balance: true},
+}
+
+func chartMetricTitles() []string {
+	titles := make([]string, len(chartMetrics))
+	for i, c := range chartMetrics {
+		titles[i] = c.Title
+	}
+	return titles
+}
+
+func chartPeriodTitles() []string {
+	titles := make([]string, len(chartPeriods))
+	for i, p := range chartPeriods {
+		titles[i] = "按" + p.String()
+	}
+	return titles
+}
+
+//chartOptions 统计窗口的选项，From、To 为起止日期，两天都包含在内
+type chartOptions struct {
+	From, To time.Time
+	Period   stats.Period
+	Metric   chartMetric
+	//YearAgo 同比，与去年同期比较；Previous 环比，与上一个时间段比较
+	YearAgo  bool
+	Previous bool
+}
+
+//统计图中数据的种类
+const (
+	seriesCurrent = iota
+	seriesYearAgo
+	seriesPrevious
+)
+
+//chartSeries 统计图中的一组数据
+type chartSeries struct {
+	Kind    int
+	Title   string
+	Buckets []stats.Bucket
+}
+
+//values 按金额取出各时间段的值
+func (s chartSeries) values(metric chartMetric) []int64 {
+	values := make([]int64, len(s.Buckets))
+	for i, b := range s.Buckets {
+		values[i] = metric.Value(b)
+	}
+	return values
+}
+
+//total 整个日期范围的合计，余额取最后一段的
+func (s chartSeries) total(metric chartMetric) int64 {
+	if metric.Balance {
+		return metric.Value(stats.Total(s.Buckets))
+	}
+	var sum int64
+	for _, v := range s.values(metric) {
+		sum += v
+	}
+	return sum
+}
+
+//span 起止日期对应的时间范围 [from, to)，起止颠倒时交换
+func (o chartOptions) span() (from, to time.Time) {
+	from, to = o.From, o.To
+	if to.Before(from) {
+		from, to = to, from
+	}
+	return stats.Day.Floor(from, time.Local), stats.Day.Next(stats.Day.Floor(to, time.Local))
+}
+
+//chartData 按选项计算统计图的数据：第一组为本期，之后依次为勾选的同比、环比；需持有读锁
+func (m *FooModel) chartData(o chartOptions) ([]chartSeries, error) {
+	from, to := o.span()
+	visits := m.statsVisits()
+	current := stats.Series(visits, o.Period, from, to, time.Local)
+	if len(current) > chartMaxBuckets {
+		return nil, fmt.Errorf("共 %d 个时间段，太多了，请缩短日期范围或按更长的时间段统计", len(current))
+	}
+	series := []chartSeries{{Kind: seriesCurrent, Title: "本期", Buckets: current}}
+	if o.YearAgo {
+		series = append(series, chartSeries{Kind: seriesYearAgo, Title: "去年同期", Buckets: stats.YearAgo(visits, current)})
+	}
+	if o.Previous {
+		series = append(series, chartSeries{Kind: seriesPrevious, Title: "上一" + o.Period.String(), Buckets: stats.Previous(visits, current, o.Period)})
+	}
+	return series, nil
+}
+
+//growthText 增长百分比，无法比较时为“—”
+func growthText(cur, prior int64) string {
+	pct, ok := stats.Growth(cur, prior)
+	if !ok {
+		return "—"
+	}
+	return fmt.Sprintf("%+.1f%%", pct)
+}
+
+//chartSummary 图下方的说明：本期合计，同比按整个日期范围比较，环比按最后一个时间段比较
+func chartSummary(series []chartSeries, o chartOptions) string {
+	if len(series) == 0 || len(series[0].Buckets) == 0 {
+		return "没有数据"
+	}
+	cur := series[0]
+	first, last := cur.Buckets[0], cur.Buckets[len(cur.Buckets)-1]
+	parts := []string{fmt.Sprintf("%s 至 %s %s合计 %s 元",
+		o.Period.Label(first.Start), o.Period.Label(last.Start), o.Metric.Title, Money(cur.total(o.Metric)))}
+	for _, s := range series[1:] {
+		switch s.Kind {
+		case seriesYearAgo:
+			parts = append(parts, "同比 "+growthText(cur.total(o.Metric), s.total(o.Metric)))
+		case seriesPrevious:
+			values, prior := cur.values(o.Metric), s.values(o.Metric)
+			parts = append(parts, fmt.Sprintf("%s 环比 %s", o.Period.Label(last.Start), growthText(values[len(values)-1], prior[len(prior)-1])))
+		}
+	}
+	return strings.Join(parts, "，")
+}
+
+//axisTop 纵轴的最大刻度：不小于 v 的 1、2、5 乘以 10 的整数次幂，至少为 unit；金额的 unit 为 100 分
+func axisTop(v, unit int64) int64 {
+	if v <= 0 {
+		return unit
+	}
+	for step := unit; ; step *= 10 {
+		for _, k := range []int64{1, 2, 5} {
+			if k*step >= v {
+				return k * step
+			}
+		}
+	}
+}
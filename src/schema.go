@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//schemaTag 数据文件首行的版本标记，形如 #medic,version=2
+const schemaTag = "#medic"
+
+//schema 一种数据文件的当前版本及其升级步骤
+type schema struct {
+	version    int
+	migrations map[int]migration
+}
+
+func newSchema(version int) *schema {
+	return &schema{version: version, migrations: map[int]migration{}}
+}
+
+//visitSchema 就诊记录文件 data.csv
+var visitSchema = newSchema(7)
+
+//patientSchema 病人档案文件 patients.csv
+var patientSchema = newSchema(2)
+
+//paymentSchema 收款记录文件 payments.csv
+var paymentSchema = newSchema(2)
+
+//changeSchema 修改记录文件 changes.csv
+var changeSchema = newSchema(1)
+
+//mergeSchema 档案合并记录文件 merges.csv
+var mergeSchema = newSchema(1)
+
+//closingSchema 日结记录文件 closings.csv
+var closingSchema = newSchema(1)
+
+//adjustmentSchema 调整记录文件 adjustments.csv
+var adjustmentSchema = newSchema(1)
+
+//table 按表头列名访问的一张 CSV 表
+type table struct {
+	version int
+	//upgraded 读入后升级过，from 为升级前的版本
+	upgraded bool
+	from     int
+	meta     map[string]string
+	header   []string
+	rows     [][]string
+}
+
+func newTable(sc *schema, header []string) *table {
+	return &table{
+		version: sc.version,
+		meta:    map[string]string{},
+		header:  header,
+	}
+}
+
+//col 返回列名所在的下标，不存在时返回 -1
+func (t *table) col(name string) int {
+	for i, h := range t.header {
+		if h == name {
+			return i
+		}
+	}
+	return -1
+}
+
+//get 按列名取一行中的值，缺列或行过短时返回空串
+func (t *table) get(row []string, name string) string {
+	i := t.col(name)
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+//addColumn 追加一列，已有行填入默认值；列已存在时不做处理
+func (t *table) addColumn(name, def string) {
+	if t.col(name) >= 0 {
+		return
+	}
+	t.header = append(t.header, name)
+	for i, row := range t.rows {
+		for len(row) < len(t.header)-1 {
+			row = append(row, "")
+		}
+		t.rows[i] = append(row, def)
+	}
+}
+
+//migration 把 from 版本的表升级到 from+1 版本
+type migration struct {
+	from int
+	desc string
+	up   func(t *table) error
+}
+
+//register 注册一个版本升级步骤
+func (sc *schema) register(from int, desc string, up func(t *table) error) {
+	if _, ok := sc.migrations[from]; ok {
+		panic(fmt.Sprintf("重复注册版本 %d 的升级", from))
+	}
+	sc.migrations[from] = migration{from: from, desc: desc, up: up}
+}
+
+func init() {
+	//版本 1：没有版本标记的旧文件，早期的文件还没有“是否删除”一列
+	visitSchema.register(1, "补齐是否删除列", func(t *table) error {
+		t.addColumn("是否删除", "0")
+		return nil
+	})
+	//版本 2：按登记时间为每条记录分配编号
+	visitSchema.register(2, "分配记录编号", func(t *table) error {
+		t.addColumn("编号", "")
+		id := t.col("编号")
+		for _, row := range t.rows {
+			create, err := time.ParseInLocation("2006-01-02 15:04:05", t.get(row, "登记时间"), time.Local)
+			if err != nil {
+				create = time.Now()
+			}
+			row[id] = newID(create)
+		}
+		return nil
+	})
+	//版本 3：增加患者编号，留空的由程序在载入后按电话和姓名归档
+	visitSchema.register(3, "增加患者编号", func(t *table) error {
+		t.addColumn("患者编号", "")
+		return nil
+	})
+	//版本 4：金额由一位小数的浮点数改为精确到分的两位小数
+	visitSchema.register(4, "金额精确到分", func(t *table) error {
+		return t.normalizeMoney("就诊费用", "实收费用", "已付费用")
+	})
+	//版本 5：增加删除时间，已删除的旧记录以最新时间作为删除时间
+	visitSchema.register(5, "增加删除时间", func(t *table) error {
+		t.addColumn("删除时间", "")
+		at := t.col("删除时间")
+		for _, row := range t.rows {
+			if t.get(row, "是否删除") == "1" {
+				row[at] = t.get(row, "最新时间")
+			}
+		}
+		return nil
+	})
+	//版本 6：增加电话的规范形式
+	visitSchema.register(6, "增加规范电话", func(t *table) error {
+		t.addColumn("规范电话", "")
+		norm := t.col("规范电话")
+		for _, row := range t.rows {
+			row[norm] = normalizePhone(t.get(row, "电话"))
+		}
+		return nil
+	})
+	//版本 1：增加欠费回访的状态、备注和时间
+	patientSchema.register(1, "增加回访记录", func(t *table) error {
+		t.addColumn("回访状态", "")
+		t.addColumn("回访备注", "")
+		t.addColumn("回访时间", "")
+		return nil
+	})
+	paymentSchema.register(1, "金额精确到分", func(t *table) error {
+		return t.normalizeMoney("金额")
+	})
+}
+
+//normalizeMoney 按十进制字符串重写金额列，不经过浮点数；
+//解析不了的值原样保留，载入时作为行错误报告
+func (t *table) normalizeMoney(cols ...string) error {
+	for _, col := range cols {
+		i := t.col(col)
+		if i < 0 {
+			continue
+		}
+		for _, row := range t.rows {
+			if i >= len(row) {
+				continue
+			}
+			if m, err := ParseMoney(row[i]); err == nil {
+				row[i] = m.String()
+			}
+		}
+	}
+	return nil
+}
+
+//migrate 依次执行升级直到当前版本
+func (t *table) migrate(sc *schema) error {
+	if t.version > sc.version {
+		return fmt.Errorf("数据文件版本 %d 高于程序支持的版本 %d，请升级程序", t.version, sc.version)
+	}
+	t.from = t.version
+	for t.version < sc.version {
+		m, ok := sc.migrations[t.version]
+		if !ok {
+			return fmt.Errorf("缺少版本 %d 的升级步骤", t.version)
+		}
+		if err := m.up(t); err != nil {
+			return fmt.Errorf("升级版本 %d（%s）失败: %v", t.version, m.desc, err)
+		}
+		t.version++
+		t.upgraded = true
+	}
+	return nil
+}
+
+//readTable 读取数据文件并升级到当前版本
+func readTable(name string, sc *schema) (*table, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	read := csv.NewReader(file)
+	read.FieldsPerRecord = -1
+	records, err := read.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &table{version: 1, meta: map[string]string{}}
+	if len(records) > 0 && len(records[0]) > 0 && records[0][0] == schemaTag {
+		for _, kv := range records[0][1:] {
+			if i := strings.Index(kv, "="); i > 0 {
+				t.meta[kv[:i]] = kv[i+1:]
+			}
+		}
+		if t.version, err = strconv.Atoi(t.meta["version"]); err != nil {
+			return nil, fmt.Errorf("无法识别的数据文件版本: %q", t.meta["version"])
+		}
+		delete(t.meta, "version")
+		records = records[1:]
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("数据文件缺少表头")
+	}
+	t.header = records[0]
+	for i, h := range t.header {
+		t.header[i] = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+	}
+	t.rows = records[1:]
+
+	if err := t.migrate(sc); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+//writeTable 写入带版本标记的数据文件
+func writeTable(name string, t *table) error {
+	stamp := []string{schemaTag, "version=" + strconv.Itoa(t.version)}
+	keys := make([]string, 0, len(t.meta))
+	for k := range t.meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		stamp = append(stamp, k+"="+t.meta[k])
+	}
+	return writeAtomic(name, func(w io.Writer) error {
+		write := csv.NewWriter(w)
+		if err := write.Write(stamp); err != nil {
+			return err
+		}
+		if err := write.Write(t.header); err != nil {
+			return err
+		}
+		return write.WriteAll(t.rows)
+	})
+}
+
+//RowError 读取数据时某一行的错误，出错的字段按零值载入
+type RowError struct {
+	Line   int
+	Column string
+	Err    error
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("第 %d 行 %s: %v", e.Line, e.Column, e.Err)
+}
+
+//rowReader 逐字段解析一行，并收集解析失败的字段
+type rowReader struct {
+	t    *table
+	row  []string
+	line int
+	errs []RowError
+}
+
+func (r *rowReader) fail(col string, err error) {
+	r.errs = append(r.errs, RowError{Line: r.line, Column: col, Err: err})
+}
+
+func (r *rowReader) str(col string) string {
+	return r.t.get(r.row, col)
+}
+
+func (r *rowReader) integer(col string) int {
+	s := r.str(col)
+	if s == "" {
+		return 0
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		r.fail(col, err)
+	}
+	return v
+}
+
+func (r *rowReader) money(col string) Money {
+	s := r.str(col)
+	if s == "" {
+		return 0
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		r.fail(col, err)
+	}
+	return v
+}
+
+func (r *rowReader) date(col string) time.Time {
+	s := r.str(col)
+	if s == "" {
+		return time.Time{}
+	}
+	v, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+	if err != nil {
+		r.fail(col, err)
+	}
+	return v
+}
+
+func (r *rowReader) boolean(col string) bool {
+	switch s := r.str(col); s {
+	case "", "0":
+		return false
+	case "1":
+		return true
+	default:
+		r.fail(col, fmt.Errorf("无效的取值 %q", s))
+		return false
+	}
+}
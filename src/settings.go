@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/lxn/walk"
+)
+
+//settingsFile 设置文件，与数据文件放在同一目录
+const settingsFile = "medic.ini"
+
+//设置中的键，查询条件的值为 JSON
+const (
+	keyPresets    = "search.presets"
+	keyLastSearch = "search.last"
+	keyPageSize   = "table.pageSize"
+	keySynonyms   = "stats.synonyms"
+	keyAgeBands   = "stats.ageBands"
+)
+
+var settings *walk.IniFileSettings
+
+//openSettings 读取设置，文件不存在时为空
+func openSettings() error {
+	app := walk.App()
+	app.SetOrganizationName("gaoyangtok")
+	app.SetProductName("medic")
+	settings = walk.NewIniFileSettings(settingsFile)
+	settings.SetPortable(true)
+	if err := settings.Load(); err != nil {
+		return err
+	}
+	app.SetSettings(settings)
+	return nil
+}
+
+//loadPresets 保存的查询条件，从未保存过时为 defaultPresets
+func loadPresets() []Preset {
+	v, ok := settings.Get(keyPresets)
+	if !ok {
+		return append([]Preset{}, defaultPresets...)
+	}
+	var presets []Preset
+	if err := json.Unmarshal([]byte(v), &presets); err != nil {
+		return append([]Preset{}, defaultPresets...)
+	}
+	return presets
+}
+
+func savePresets(presets []Preset) error {
+	b, err := json.Marshal(presets)
+	if err != nil {
+		return err
+	}
+	if err := settings.Put(keyPresets, string(b)); err != nil {
+		return err
+	}
+	return settings.Save()
+}
+
+//restoreSearch 恢复上次使用的查询条件，没有或无法识别时保持默认
+func restoreSearch(s *Search) {
+	v, ok := settings.Get(keyLastSearch)
+	if !ok {
+		return
+	}
+	var p Preset
+	if err := json.Unmarshal([]byte(v), &p); err != nil {
+		return
+	}
+	_ = p.apply(s, time.Now())
+}
+
+//rememberSearch 记下本次使用的查询条件，退出时随设置一起保存
+func rememberSearch(s *Search) {
+	b, err := json.Marshal(newPreset("", s, false, time.Now()))
+	if err == nil {
+		_ = settings.Put(keyLastSearch, string(b))
+	}
+}
+
+//loadPageSize 主表每页的条数，不是可选的值时为默认值
+func loadPageSize() int {
+	if v, ok := settings.Get(keyPageSize); ok {
+		if size, err := strconv.Atoi(v); err == nil {
+			return pageSizes[pageSizeIndex(size)]
+		}
+	}
+	return defaultPageSize
+}
+
+func savePageSize(size int) {
+	_ = settings.Put(keyPageSize, strconv.Itoa(size))
+}
+
+//loadSynonyms 诊断和治疗方案的同义词表，标准名到别名
+func loadSynonyms() map[string][]string {
+	synonyms := map[string][]string{}
+	if v, ok := settings.Get(keySynonyms); ok {
+		_ = json.Unmarshal([]byte(v), &synonyms)
+	}
+	return synonyms
+}
+
+func saveSynonyms(synonyms map[string][]string) error {
+	b, err := json.Marshal(synonyms)
+	if err != nil {
+		return err
+	}
+	if err := settings.Put(keySynonyms, string(b)); err != nil {
+		return err
+	}
+	return settings.Save()
+}
+
+//loadAgeBands 年龄性别分布的年龄段，没有设置或无法识别时为 defaultAgeBands
+func loadAgeBands() []AgeBand {
+	if v, ok := settings.Get(keyAgeBands); ok {
+		if bands, err := parseAgeBands(v); err == nil {
+			return bands
+		}
+	}
+	bands, _ := parseAgeBands(defaultAgeBands)
+	return bands
+}
+
+func saveAgeBands(bands []AgeBand) error {
+	if err := settings.Put(keyAgeBands, formatAgeBands(bands)); err != nil {
+		return err
+	}
+	return settings.Save()
+}
@@ -0,0 +1,811 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteData = "medic.db"
+
+//sqlMigration 在事务中执行的一步数据库升级
+type sqlMigration func(tx *sql.Tx) error
+
+//execSQL 只需执行语句的升级
+func execSQL(stmt string) sqlMigration {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(stmt)
+		return err
+	}
+}
+
+//sqlMigrations 数据库的升级步骤，下标+1 即升级后的 user_version
+var sqlMigrations = []sqlMigration{
+	execSQL(`CREATE TABLE visits (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		name      TEXT NOT NULL DEFAULT '',
+		phone     TEXT NOT NULL DEFAULT '',
+		created   TEXT NOT NULL DEFAULT '',
+		updated   TEXT NOT NULL DEFAULT '',
+		diagnosed TEXT NOT NULL DEFAULT '',
+		program   TEXT NOT NULL DEFAULT '',
+		all_fee   REAL NOT NULL DEFAULT 0,
+		real_fee  REAL NOT NULL DEFAULT 0,
+		paid_fee  REAL NOT NULL DEFAULT 0,
+		address   TEXT NOT NULL DEFAULT '',
+		sex       TEXT NOT NULL DEFAULT '',
+		age       INTEGER NOT NULL DEFAULT 0,
+		deleted   INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX visits_created ON visits(created);
+	CREATE INDEX visits_phone ON visits(phone);`),
+	//按登记时间为已有记录分配编号
+	func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`ALTER TABLE visits ADD COLUMN uid TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+		rows, err := tx.Query(`SELECT id, created FROM visits`)
+		if err != nil {
+			return err
+		}
+		ids := map[int64]string{}
+		for rows.Next() {
+			var id int64
+			var created string
+			if err := rows.Scan(&id, &created); err != nil {
+				rows.Close()
+				return err
+			}
+			create, err := parseTime(created)
+			if err != nil {
+				create = time.Now()
+			}
+			ids[id] = newID(create)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for id, uid := range ids {
+			if _, err := tx.Exec(`UPDATE visits SET uid = ? WHERE id = ?`, uid, id); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`CREATE UNIQUE INDEX visits_uid ON visits(uid)`)
+		return err
+	},
+	//病人档案，已有记录的 patient_id 留空，由程序在载入后归档
+	execSQL(`CREATE TABLE patients (
+		uid     TEXT PRIMARY KEY,
+		name    TEXT NOT NULL DEFAULT '',
+		phone   TEXT NOT NULL DEFAULT '',
+		sex     TEXT NOT NULL DEFAULT '',
+		age     INTEGER NOT NULL DEFAULT 0,
+		address TEXT NOT NULL DEFAULT '',
+		created TEXT NOT NULL DEFAULT ''
+	);
+	ALTER TABLE visits ADD COLUMN patient_id TEXT NOT NULL DEFAULT '';
+	CREATE INDEX visits_patient ON visits(patient_id);`),
+	//收款记录，旧数据的已付费用由程序在载入后补记
+	execSQL(`CREATE TABLE payments (
+		uid      TEXT PRIMARY KEY,
+		visit_id TEXT NOT NULL,
+		amount   REAL NOT NULL DEFAULT 0,
+		paid_at  TEXT NOT NULL DEFAULT '',
+		method   TEXT NOT NULL DEFAULT '',
+		note     TEXT NOT NULL DEFAULT '',
+		voided   INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX payments_visit ON payments(visit_id);
+	CREATE INDEX payments_paid_at ON payments(paid_at);`),
+	//金额改为以分为单位的整数；SQLite 不能修改列类型，只能重建表
+	execSQL(`CREATE TABLE visits_new (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		uid        TEXT NOT NULL DEFAULT '',
+		name       TEXT NOT NULL DEFAULT '',
+		phone      TEXT NOT NULL DEFAULT '',
+		created    TEXT NOT NULL DEFAULT '',
+		updated    TEXT NOT NULL DEFAULT '',
+		diagnosed  TEXT NOT NULL DEFAULT '',
+		program    TEXT NOT NULL DEFAULT '',
+		all_fee    INTEGER NOT NULL DEFAULT 0,
+		real_fee   INTEGER NOT NULL DEFAULT 0,
+		paid_fee   INTEGER NOT NULL DEFAULT 0,
+		address    TEXT NOT NULL DEFAULT '',
+		sex        TEXT NOT NULL DEFAULT '',
+		age        INTEGER NOT NULL DEFAULT 0,
+		deleted    INTEGER NOT NULL DEFAULT 0,
+		patient_id TEXT NOT NULL DEFAULT ''
+	);
+	INSERT INTO visits_new (id, uid, name, phone, created, updated, diagnosed, program,
+			all_fee, real_fee, paid_fee, address, sex, age, deleted, patient_id)
+		SELECT id, uid, name, phone, created, updated, diagnosed, program,
+			CAST(ROUND(all_fee * 100) AS INTEGER), CAST(ROUND(real_fee * 100) AS INTEGER), CAST(ROUND(paid_fee * 100) AS INTEGER),
+			address, sex, age, deleted, patient_id
+		FROM visits;
+	DROP TABLE visits;
+	ALTER TABLE visits_new RENAME TO visits;
+	CREATE INDEX visits_created ON visits(created);
+	CREATE INDEX visits_phone ON visits(phone);
+	CREATE UNIQUE INDEX visits_uid ON visits(uid);
+	CREATE INDEX visits_patient ON visits(patient_id);
+
+	CREATE TABLE payments_new (
+		uid      TEXT PRIMARY KEY,
+		visit_id TEXT NOT NULL,
+		amount   INTEGER NOT NULL DEFAULT 0,
+		paid_at  TEXT NOT NULL DEFAULT '',
+		method   TEXT NOT NULL DEFAULT '',
+		note     TEXT NOT NULL DEFAULT '',
+		voided   INTEGER NOT NULL DEFAULT 0
+	);
+	INSERT INTO payments_new (uid, visit_id, amount, paid_at, method, note, voided)
+		SELECT uid, visit_id, CAST(ROUND(amount * 100) AS INTEGER), paid_at, method, note, voided
+		FROM payments;
+	DROP TABLE payments;
+	ALTER TABLE payments_new RENAME TO payments;
+	CREATE INDEX payments_visit ON payments(visit_id);
+	CREATE INDEX payments_paid_at ON payments(paid_at);`),
+	//修改记录，只追加
+	execSQL(`CREATE TABLE changes (
+		uid        TEXT PRIMARY KEY,
+		visit_id   TEXT NOT NULL,
+		field      TEXT NOT NULL DEFAULT '',
+		old_value  TEXT NOT NULL DEFAULT '',
+		new_value  TEXT NOT NULL DEFAULT '',
+		changed_at TEXT NOT NULL DEFAULT '',
+		operator   TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX changes_visit ON changes(visit_id);`),
+	//删除时间，已删除的旧记录以最新时间作为删除时间
+	execSQL(`ALTER TABLE visits ADD COLUMN deleted_at TEXT NOT NULL DEFAULT '';
+	UPDATE visits SET deleted_at = updated WHERE deleted = 1;`),
+	//欠费回访的状态、备注和时间
+	execSQL(`ALTER TABLE patients ADD COLUMN follow_up TEXT NOT NULL DEFAULT '';
+	ALTER TABLE patients ADD COLUMN follow_note TEXT NOT NULL DEFAULT '';
+	ALTER TABLE patients ADD COLUMN followed_at TEXT NOT NULL DEFAULT '';`),
+	//病人档案的合并记录，visit_ids 为空格分隔的就诊编号
+	execSQL(`CREATE TABLE merges (
+		uid       TEXT PRIMARY KEY,
+		keep_id   TEXT NOT NULL,
+		merged_id TEXT NOT NULL,
+		visit_ids TEXT NOT NULL DEFAULT '',
+		merged_at TEXT NOT NULL DEFAULT '',
+		operator  TEXT NOT NULL DEFAULT '',
+		undone_at TEXT NOT NULL DEFAULT ''
+	);`),
+	//电话的规范形式，用于查找和识别同一个病人
+	func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`ALTER TABLE visits ADD COLUMN phone_norm TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+		rows, err := tx.Query(`SELECT id, phone FROM visits`)
+		if err != nil {
+			return err
+		}
+		norms := map[int64]string{}
+		for rows.Next() {
+			var id int64
+			var phone string
+			if err := rows.Scan(&id, &phone); err != nil {
+				rows.Close()
+				return err
+			}
+			norms[id] = normalizePhone(phone)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for id, norm := range norms {
+			if _, err := tx.Exec(`UPDATE visits SET phone_norm = ? WHERE id = ?`, norm, id); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`CREATE INDEX visits_phone_norm ON visits(phone_norm)`)
+		return err
+	},
+	//日结和对已日结日期的调整，day 为日结的日期
+	execSQL(`CREATE TABLE closings (
+		uid          TEXT PRIMARY KEY,
+		day          TEXT NOT NULL,
+		registered   INTEGER NOT NULL DEFAULT 0,
+		new_patients INTEGER NOT NULL DEFAULT 0,
+		edited       INTEGER NOT NULL DEFAULT 0,
+		all_fee      INTEGER NOT NULL DEFAULT 0,
+		real_fee     INTEGER NOT NULL DEFAULT 0,
+		collected    INTEGER NOT NULL DEFAULT 0,
+		receivable   INTEGER NOT NULL DEFAULT 0,
+		note         TEXT NOT NULL DEFAULT '',
+		closed_at    TEXT NOT NULL DEFAULT '',
+		operator     TEXT NOT NULL DEFAULT ''
+	);
+	CREATE UNIQUE INDEX closings_day ON closings(day);
+	CREATE TABLE adjustments (
+		uid         TEXT PRIMARY KEY,
+		day         TEXT NOT NULL,
+		visit_id    TEXT NOT NULL,
+		kind        TEXT NOT NULL DEFAULT '',
+		reason      TEXT NOT NULL DEFAULT '',
+		real_fee    INTEGER NOT NULL DEFAULT 0,
+		paid        INTEGER NOT NULL DEFAULT 0,
+		adjusted_at TEXT NOT NULL DEFAULT '',
+		operator    TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX adjustments_visit ON adjustments(visit_id);`),
+}
+
+const visitColumns = `uid, name, phone, created, updated, diagnosed, program, all_fee, real_fee, paid_fee, address, sex, age, deleted, patient_id, deleted_at, phone_norm`
+
+const patientColumnsSQL = `uid, name, phone, sex, age, address, created, follow_up, follow_note, followed_at`
+
+const paymentColumnsSQL = `uid, visit_id, amount, paid_at, method, note, voided`
+
+const changeColumnsSQL = `uid, visit_id, field, old_value, new_value, changed_at, operator`
+
+const mergeColumnsSQL = `uid, keep_id, merged_id, visit_ids, merged_at, operator, undone_at`
+
+const closingColumnsSQL = `uid, day, registered, new_patients, edited, all_fee, real_fee, collected, receivable, note, closed_at, operator`
+
+const adjustmentColumnsSQL = `uid, day, visit_id, kind, reason, real_fee, paid, adjusted_at, operator`
+
+//SQLStore 使用内嵌的 SQLite 数据库按行保存记录
+type SQLStore struct {
+	db *sql.DB
+	//tx 不为空时写入都在这个事务中，见 Transact
+	tx *sql.Tx
+}
+
+func OpenSQLStore(path string) (*SQLStore, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	s := &SQLStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+//migrate 执行 user_version 之后的升级语句
+func (s *SQLStore) migrate() error {
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqlMigrations) {
+		return fmt.Errorf("数据库版本 %d 高于程序支持的版本 %d，请升级程序", version, len(sqlMigrations))
+	}
+	for ; version < len(sqlMigrations); version++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := sqlMigrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("升级数据库到版本 %d 失败: %v", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) Load() (*Dataset, []RowError, error) {
+	ds := new(Dataset)
+	var errs []RowError
+	var err error
+	if ds.Visits, errs, err = s.query(`SELECT ` + visitColumns + ` FROM visits ORDER BY id`); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT ` + patientColumnsSQL + ` FROM patients ORDER BY created`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p Patient
+		var sex, created, followed string
+		if err := rows.Scan(&p.ID, &p.Name, &p.Phone, &sex, &p.Age, &p.Address, &created, &p.FollowUp, &p.FollowNote, &followed); err != nil {
+			return nil, nil, err
+		}
+		p.Sex = Sex(sex)
+		if p.Create, err = parseTime(created); err != nil {
+			errs = append(errs, RowError{Line: len(ds.Patients) + 1, Column: "patients.created", Err: err})
+		}
+		if p.FollowedAt, err = parseTime(followed); err != nil {
+			errs = append(errs, RowError{Line: len(ds.Patients) + 1, Column: "patients.followed_at", Err: err})
+		}
+		ds.Patients = append(ds.Patients, &p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	prows, err := s.db.Query(`SELECT ` + paymentColumnsSQL + ` FROM payments ORDER BY paid_at`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer prows.Close()
+	for prows.Next() {
+		var p Payment
+		var at string
+		if err := prows.Scan(&p.ID, &p.VisitID, &p.Amount, &at, &p.Method, &p.Note, &p.Voided); err != nil {
+			return nil, nil, err
+		}
+		if p.At, err = parseTime(at); err != nil {
+			errs = append(errs, RowError{Line: len(ds.Payments) + 1, Column: "payments.paid_at", Err: err})
+		}
+		ds.Payments = append(ds.Payments, &p)
+	}
+	if err := prows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	crows, err := s.db.Query(`SELECT ` + changeColumnsSQL + ` FROM changes ORDER BY changed_at`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer crows.Close()
+	for crows.Next() {
+		var c Change
+		var at string
+		if err := crows.Scan(&c.ID, &c.VisitID, &c.Field, &c.Old, &c.New, &at, &c.Operator); err != nil {
+			return nil, nil, err
+		}
+		if c.At, err = parseTime(at); err != nil {
+			errs = append(errs, RowError{Line: len(ds.Changes) + 1, Column: "changes.changed_at", Err: err})
+		}
+		ds.Changes = append(ds.Changes, &c)
+	}
+	if err := crows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	grows, err := s.db.Query(`SELECT ` + mergeColumnsSQL + ` FROM merges ORDER BY merged_at`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer grows.Close()
+	for grows.Next() {
+		var g Merge
+		var visits, at, undone string
+		if err := grows.Scan(&g.ID, &g.KeepID, &g.MergedID, &visits, &at, &g.Operator, &undone); err != nil {
+			return nil, nil, err
+		}
+		g.VisitIDs = strings.Fields(visits)
+		if g.At, err = parseTime(at); err != nil {
+			errs = append(errs, RowError{Line: len(ds.Merges) + 1, Column: "merges.merged_at", Err: err})
+		}
+		if g.UndoneAt, err = parseTime(undone); err != nil {
+			errs = append(errs, RowError{Line: len(ds.Merges) + 1, Column: "merges.undone_at", Err: err})
+		}
+		ds.Merges = append(ds.Merges, &g)
+	}
+	if err := grows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	lrows, err := s.db.Query(`SELECT ` + closingColumnsSQL + ` FROM closings ORDER BY day`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer lrows.Close()
+	for lrows.Next() {
+		var c Closing
+		var day, at string
+		if err := lrows.Scan(&c.ID, &day, &c.Registered, &c.NewPatients, &c.Edited,
+			&c.AllFee, &c.RealFee, &c.Collected, &c.Receivable, &c.Note, &at, &c.Operator); err != nil {
+			return nil, nil, err
+		}
+		if c.Day, err = parseTime(day); err != nil {
+			errs = append(errs, RowError{Line: len(ds.Closings) + 1, Column: "closings.day", Err: err})
+		}
+		if c.At, err = parseTime(at); err != nil {
+			errs = append(errs, RowError{Line: len(ds.Closings) + 1, Column: "closings.closed_at", Err: err})
+		}
+		ds.Closings = append(ds.Closings, &c)
+	}
+	if err := lrows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	arows, err := s.db.Query(`SELECT ` + adjustmentColumnsSQL + ` FROM adjustments ORDER BY adjusted_at`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer arows.Close()
+	for arows.Next() {
+		var a Adjustment
+		var day, at string
+		if err := arows.Scan(&a.ID, &day, &a.VisitID, &a.Kind, &a.Reason, &a.RealFee, &a.Paid, &at, &a.Operator); err != nil {
+			return nil, nil, err
+		}
+		if a.Day, err = parseTime(day); err != nil {
+			errs = append(errs, RowError{Line: len(ds.Adjustments) + 1, Column: "adjustments.day", Err: err})
+		}
+		if a.At, err = parseTime(at); err != nil {
+			errs = append(errs, RowError{Line: len(ds.Adjustments) + 1, Column: "adjustments.adjusted_at", Err: err})
+		}
+		ds.Adjustments = append(ds.Adjustments, &a)
+	}
+	return ds, errs, arows.Err()
+}
+
+func (s *SQLStore) Insert(foo *Foo) error {
+	return s.insert(s.conn(), foo)
+}
+
+func (s *SQLStore) Update(foo *Foo) error {
+	res, err := s.conn().Exec(`UPDATE visits SET uid = ?, name = ?, phone = ?, created = ?, updated = ?, diagnosed = ?, program = ?,
+		all_fee = ?, real_fee = ?, paid_fee = ?, address = ?, sex = ?, age = ?, deleted = ?, patient_id = ?, deleted_at = ?, phone_norm = ? WHERE uid = ?`,
+		append(visitArgs(foo), foo.ID)...)
+	if err != nil {
+		return err
+	}
+	return affected(res, foo.ID)
+}
+
+func (s *SQLStore) Delete(foo *Foo) error {
+	at := time.Now()
+	res, err := s.conn().Exec(`UPDATE visits SET deleted = 1, deleted_at = ? WHERE uid = ?`, formatTime(at), foo.ID)
+	if err != nil {
+		return err
+	}
+	if err := affected(res, foo.ID); err != nil {
+		return err
+	}
+	foo.Deleted = true
+	foo.DeletedAt = at
+	return nil
+}
+
+//Purge 彻底删除一条就诊记录及其收款
+func (s *SQLStore) Purge(foo *Foo) error {
+	return s.transact(func(t *SQLStore) error {
+		if _, err := t.tx.Exec(`DELETE FROM payments WHERE visit_id = ?`, foo.ID); err != nil {
+			return err
+		}
+		res, err := t.tx.Exec(`DELETE FROM visits WHERE uid = ?`, foo.ID)
+		if err != nil {
+			return err
+		}
+		return affected(res, foo.ID)
+	})
+}
+
+//Query 条件下推到 SQL：登记时间用 visits_created 索引，电话开头用 visits_phone_norm 索引
+func (s *SQLStore) Query(q VisitQuery) ([]*Foo, error) {
+	where := []string{"1"}
+	var args []interface{}
+	if !q.Deleted {
+		where = append(where, "deleted = 0")
+	}
+	if !q.Start.IsZero() {
+		where = append(where, "created > ?")
+		args = append(args, formatTime(q.Start.In(time.Local)))
+	}
+	if !q.End.IsZero() {
+		where = append(where, "created < ?")
+		args = append(args, formatTime(q.End.In(time.Local)))
+	}
+	if q.Name != "" {
+		where = append(where, "instr(name, ?) > 0")
+		args = append(args, q.Name)
+	}
+	if q.Phone != "" {
+		//规范形式只有数字，GLOB 的前缀可以用索引
+		where = append(where, "phone_norm GLOB ?")
+		args = append(args, q.Phone+"*")
+	}
+	foos, _, err := s.query(`SELECT `+visitColumns+` FROM visits WHERE `+strings.Join(where, " AND ")+` ORDER BY created, id`, args...)
+	return foos, err
+}
+
+func (s *SQLStore) InsertPatient(p *Patient) error {
+	return s.insertPatient(s.conn(), p)
+}
+
+func (s *SQLStore) UpdatePatient(p *Patient) error {
+	res, err := s.conn().Exec(`UPDATE patients SET uid = ?, name = ?, phone = ?, sex = ?, age = ?, address = ?, created = ?, follow_up = ?, follow_note = ?, followed_at = ? WHERE uid = ?`,
+		append(patientArgs(p), p.ID)...)
+	if err != nil {
+		return err
+	}
+	return affected(res, p.ID)
+}
+
+func (s *SQLStore) InsertPayment(p *Payment) error {
+	return s.insertPayment(s.conn(), p)
+}
+
+func (s *SQLStore) UpdatePayment(p *Payment) error {
+	res, err := s.conn().Exec(`UPDATE payments SET uid = ?, visit_id = ?, amount = ?, paid_at = ?, method = ?, note = ?, voided = ? WHERE uid = ?`,
+		append(paymentArgs(p), p.ID)...)
+	if err != nil {
+		return err
+	}
+	return affected(res, p.ID)
+}
+
+func (s *SQLStore) InsertChange(c *Change) error {
+	return s.insertChange(s.conn(), c)
+}
+
+func (s *SQLStore) InsertMerge(g *Merge) error {
+	return s.insertMerge(s.conn(), g)
+}
+
+func (s *SQLStore) UpdateMerge(g *Merge) error {
+	res, err := s.conn().Exec(`UPDATE merges SET uid = ?, keep_id = ?, merged_id = ?, visit_ids = ?, merged_at = ?, operator = ?, undone_at = ? WHERE uid = ?`,
+		append(mergeArgs(g), g.ID)...)
+	if err != nil {
+		return err
+	}
+	return affected(res, g.ID)
+}
+
+func (s *SQLStore) InsertClosing(c *Closing) error {
+	return s.insertClosing(s.conn(), c)
+}
+
+func (s *SQLStore) InsertAdjustment(a *Adjustment) error {
+	return s.insertAdjustment(s.conn(), a)
+}
+
+func (s *SQLStore) Transact(fn func(store Store) error) error {
+	return s.transact(func(t *SQLStore) error {
+		return fn(t)
+	})
+}
+
+//transact 在事务中执行 fn，已经在事务中时直接执行
+func (s *SQLStore) transact(fn func(t *SQLStore) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(&SQLStore{db: s.db, tx: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//conn 写入用的连接，事务中为事务本身
+func (s *SQLStore) conn() execer {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+//execer 是 *sql.DB 与 *sql.Tx 的公共部分
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (s *SQLStore) insert(db execer, foo *Foo) error {
+	if foo.ID == "" {
+		foo.ID = newID(foo.Create)
+	}
+	_, err := db.Exec(`INSERT INTO visits (`+visitColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, visitArgs(foo)...)
+	return err
+}
+
+func (s *SQLStore) insertPatient(db execer, p *Patient) error {
+	if p.ID == "" {
+		p.ID = newID(p.Create)
+	}
+	_, err := db.Exec(`INSERT INTO patients (`+patientColumnsSQL+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, patientArgs(p)...)
+	return err
+}
+
+func (s *SQLStore) insertPayment(db execer, p *Payment) error {
+	if p.ID == "" {
+		p.ID = newID(p.At)
+	}
+	_, err := db.Exec(`INSERT INTO payments (`+paymentColumnsSQL+`) VALUES (?, ?, ?, ?, ?, ?, ?)`, paymentArgs(p)...)
+	return err
+}
+
+func (s *SQLStore) insertChange(db execer, c *Change) error {
+	if c.ID == "" {
+		c.ID = newID(c.At)
+	}
+	_, err := db.Exec(`INSERT INTO changes (`+changeColumnsSQL+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.VisitID, c.Field, c.Old, c.New, c.At.Format("2006-01-02 15:04:05"), c.Operator)
+	return err
+}
+
+func (s *SQLStore) insertMerge(db execer, g *Merge) error {
+	if g.ID == "" {
+		g.ID = newID(g.At)
+	}
+	_, err := db.Exec(`INSERT INTO merges (`+mergeColumnsSQL+`) VALUES (?, ?, ?, ?, ?, ?, ?)`, mergeArgs(g)...)
+	return err
+}
+
+func (s *SQLStore) insertClosing(db execer, c *Closing) error {
+	if c.ID == "" {
+		c.ID = newID(c.At)
+	}
+	_, err := db.Exec(`INSERT INTO closings (`+closingColumnsSQL+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.Day.Format("2006-01-02 15:04:05"), c.Registered, c.NewPatients, c.Edited,
+		c.AllFee, c.RealFee, c.Collected, c.Receivable, c.Note, c.At.Format("2006-01-02 15:04:05"), c.Operator)
+	return err
+}
+
+func (s *SQLStore) insertAdjustment(db execer, a *Adjustment) error {
+	if a.ID == "" {
+		a.ID = newID(a.At)
+	}
+	_, err := db.Exec(`INSERT INTO adjustments (`+adjustmentColumnsSQL+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, a.Day.Format("2006-01-02 15:04:05"), a.VisitID, a.Kind, a.Reason, a.RealFee, a.Paid,
+		a.At.Format("2006-01-02 15:04:05"), a.Operator)
+	return err
+}
+
+//affected 确认语句确实改到了记录
+func affected(res sql.Result, id string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("记录 %s 不存在", id)
+	}
+	return nil
+}
+
+//insertAll 在一个事务中插入全部数据，用于从 CSV 迁移
+func (s *SQLStore) insertAll(ds *Dataset) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, p := range ds.Patients {
+		if err := s.insertPatient(tx, p); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, foo := range ds.Visits {
+		if err := s.insert(tx, foo); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, p := range ds.Payments {
+		if err := s.insertPayment(tx, p); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, c := range ds.Changes {
+		if err := s.insertChange(tx, c); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, g := range ds.Merges {
+		if err := s.insertMerge(tx, g); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, c := range ds.Closings {
+		if err := s.insertClosing(tx, c); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, a := range ds.Adjustments {
+		if err := s.insertAdjustment(tx, a); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) query(query string, args ...interface{}) ([]*Foo, []RowError, error) {
+	rows, err := s.conn().Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var foos []*Foo
+	var errs []RowError
+	for line := 1; rows.Next(); line++ {
+		var foo Foo
+		var create, update, sex, deleted string
+		if err := rows.Scan(&foo.ID, &foo.Name, &foo.Phone, &create, &update, &foo.Diagnosed, &foo.Program,
+			&foo.AllFee, &foo.RealFee, &foo.PaidFee, &foo.Address, &sex, &foo.Age, &foo.Deleted, &foo.PatientID, &deleted, &foo.PhoneNorm); err != nil {
+			return nil, nil, err
+		}
+		foo.Sex = Sex(sex)
+		if foo.Create, err = parseTime(create); err != nil {
+			errs = append(errs, RowError{Line: line, Column: "created", Err: err})
+		}
+		if foo.Update, err = parseTime(update); err != nil {
+			errs = append(errs, RowError{Line: line, Column: "updated", Err: err})
+		}
+		if foo.DeletedAt, err = parseTime(deleted); err != nil {
+			errs = append(errs, RowError{Line: line, Column: "deleted_at", Err: err})
+		}
+		foos = append(foos, &foo)
+	}
+	return foos, errs, rows.Err()
+}
+
+func visitArgs(foo *Foo) []interface{} {
+	return []interface{}{
+		foo.ID, foo.Name, foo.Phone,
+		foo.Create.Format("2006-01-02 15:04:05"),
+		foo.Update.Format("2006-01-02 15:04:05"),
+		foo.Diagnosed, foo.Program,
+		foo.AllFee, foo.RealFee, foo.PaidFee,
+		foo.Address, string(foo.Sex), foo.Age, foo.Deleted, foo.PatientID,
+		formatTime(foo.DeletedAt), foo.PhoneNorm,
+	}
+}
+
+func paymentArgs(p *Payment) []interface{} {
+	return []interface{}{
+		p.ID, p.VisitID, p.Amount,
+		p.At.Format("2006-01-02 15:04:05"),
+		p.Method, p.Note, p.Voided,
+	}
+}
+
+func patientArgs(p *Patient) []interface{} {
+	return []interface{}{
+		p.ID, p.Name, p.Phone, string(p.Sex), p.Age, p.Address,
+		p.Create.Format("2006-01-02 15:04:05"),
+		p.FollowUp, p.FollowNote, formatTime(p.FollowedAt),
+	}
+}
+
+func mergeArgs(g *Merge) []interface{} {
+	return []interface{}{
+		g.ID, g.KeepID, g.MergedID, strings.Join(g.VisitIDs, " "),
+		g.At.Format("2006-01-02 15:04:05"),
+		g.Operator, formatTime(g.UndoneAt),
+	}
+}
+
+//formatTime 零值时间保存为空
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+}
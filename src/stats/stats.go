@@ -0,0 +1,279 @@
+//Package stats 按日、周、月、季、年汇总就诊的应收、已收和欠费，不依赖界面和全局数据。
+//金额以分为单位；时间按给定的时区分段，没有数据的时间段也会列出
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+//Period 时间段的长度
+type Period int
+
+const (
+	Day Period = iota
+	//Week 周一至周日
+	Week
+	Month
+	Quarter
+	Year
+)
+
+//Periods 全部可选的时间段，按从短到长排列
+var Periods = []Period{Day, Week, Month, Quarter, Year}
+
+func (p Period) String() string {
+	switch p {
+	case Day:
+		return "日"
+	case Week:
+		return "周"
+	case Month:
+		return "月"
+	case Quarter:
+		return "季"
+	case Year:
+		return "年"
+	}
+	return fmt.Sprintf("Period(%d)", int(p))
+}
+
+//Floor t 所在时间段在 loc 中的起点
+func (p Period) Floor(t time.Time, loc *time.Location) time.Time {
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+	y, m, d := t.Date()
+	switch p {
+	case Week:
+		//Weekday 以周日为 0，这里以周一为一周的第一天
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	case Month:
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	case Quarter:
+		return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, loc)
+	case Year:
+		return time.Date(y, 1, 1, 0, 0, 0, 0, loc)
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+//Next 以 start 为起点的时间段之后的下一个起点；按日历计算，夏令时的一天可能不是 24 小时
+func (p Period) Next(start time.Time) time.Time {
+	var next time.Time
+	switch p {
+	case Week:
+		next = start.AddDate(0, 0, 7)
+	case Month:
+		next = start.AddDate(0, 1, 0)
+	case Quarter:
+		next = start.AddDate(0, 3, 0)
+	case Year:
+		next = start.AddDate(1, 0, 0)
+	default:
+		next = start.AddDate(0, 0, 1)
+	}
+	return p.Floor(next, start.Location())
+}
+
+//Label 时间段的名称，如 2024-03-05、2024-W10、2024-03、2024Q1、2024
+func (p Period) Label(start time.Time) string {
+	switch p {
+	case Week:
+		y, w := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	case Month:
+		return start.Format("2006-01")
+	case Quarter:
+		return fmt.Sprintf("%dQ%d", start.Year(), (int(start.Month())+2)/3)
+	case Year:
+		return start.Format("2006")
+	}
+	return start.Format("2006-01-02")
+}
+
+//Payment 一笔有效的收款
+type Payment struct {
+	At     time.Time
+	Amount int64
+}
+
+//Visit 一次就诊：登记时间、应收金额和收款
+type Visit struct {
+	At       time.Time
+	Billed   int64
+	Payments []Payment
+}
+
+//Bucket 一个时间段 [Start, End) 的汇总
+type Bucket struct {
+	Start, End time.Time
+	//Visits、Billed 这一时间段登记的就诊次数和应收
+	Visits int
+	Billed int64
+	//Collected 这一时间段收到的款项，不论是哪次就诊的
+	Collected int64
+	//Outstanding 时间段结束时的欠费余额：已登记的就诊各自未付的部分之和，多付的不抵扣其它就诊
+	Outstanding int64
+}
+
+//Series 把 [from, to) 按 p 分段汇总，from 所在的时间段从头算起；没有数据的时间段金额为 0。
+//loc 为分段所用的时区，nil 为本地时区
+func Series(visits []Visit, p Period, from, to time.Time, loc *time.Location) []Bucket {
+	var buckets []Bucket
+	for start := p.Floor(from, loc); start.Before(to); {
+		end := p.Next(start)
+		buckets = append(buckets, Bucket{Start: start, End: end})
+		start = end
+	}
+	fill(visits, buckets)
+	return buckets
+}
+
+//Sum 把 [from, to) 汇总为一个时间段，不按日历取整
+func Sum(visits []Visit, from, to time.Time) Bucket {
+	buckets := []Bucket{{Start: from, End: to}}
+	fill(visits, buckets)
+	return buckets[0]
+}
+
+//fill 汇总到 buckets 中，各段首尾相接、按时间先后排列，允许长度为 0 的时间段
+func fill(visits []Visit, buckets []Bucket) {
+	if len(buckets) == 0 {
+		return
+	}
+	//index t 所在时间段的下标，早于第一段为 -1，晚于最后一段为 len(buckets)
+	index := func(t time.Time) int {
+		if t.Before(buckets[0].Start) {
+			return -1
+		}
+		return sort.Search(len(buckets), func(i int) bool {
+			return t.Before(buckets[i].End)
+		})
+	}
+	//deltas 每段内欠费余额的变化，base 为第一段之前的余额
+	deltas := make([]int64, len(buckets))
+	var base int64
+	owe := func(t time.Time, delta int64) {
+		switch i := index(t); {
+		case i < 0:
+			base += delta
+		case i < len(buckets):
+			deltas[i] += delta
+		}
+	}
+
+	for _, v := range visits {
+		if i := index(v.At); i >= 0 && i < len(buckets) {
+			buckets[i].Visits++
+			buckets[i].Billed += v.Billed
+		}
+		for _, pay := range v.Payments {
+			if i := index(pay.At); i >= 0 && i < len(buckets) {
+				buckets[i].Collected += pay.Amount
+			}
+		}
+
+		owed := positive(v.Billed)
+		owe(v.At, owed)
+		payments := append([]Payment{}, v.Payments...)
+		sort.SliceStable(payments, func(i, j int) bool {
+			return payments[i].At.Before(payments[j].At)
+		})
+		var paid int64
+		for _, pay := range payments {
+			paid += pay.Amount
+			next := positive(v.Billed - paid)
+			//登记之前的收款（如预付）按登记时计入
+			at := pay.At
+			if at.Before(v.At) {
+				at = v.At
+			}
+			owe(at, next-owed)
+			owed = next
+		}
+	}
+
+	balance := base
+	for i := range buckets {
+		balance += deltas[i]
+		buckets[i].Outstanding = balance
+	}
+}
+
+//YearAgo 与 buckets 对应的去年同期，用于同比。按日历往前推一年，
+//按周分段时去年同期不一定从周一开始；闰年的 2 月 29 日没有去年同期
+func YearAgo(visits []Visit, buckets []Bucket) []Bucket {
+	if len(buckets) == 0 {
+		return nil
+	}
+	prior := make([]Bucket, len(buckets))
+	for i, b := range buckets {
+		prior[i] = Bucket{Start: b.Start.AddDate(-1, 0, 0), End: b.End.AddDate(-1, 0, 0)}
+	}
+	fill(visits, prior)
+	return prior
+}
+
+//Previous 每个时间段的上一个时间段，用于环比；buckets 须是 Series 按 p 分出的
+func Previous(visits []Visit, buckets []Bucket, p Period) []Bucket {
+	if len(buckets) == 0 {
+		return nil
+	}
+	first := buckets[0].Start
+	return Series(visits, p, p.Floor(first.Add(-time.Nanosecond), first.Location()), buckets[len(buckets)-1].Start, first.Location())
+}
+
+//Growth cur 相对 prior 的增长百分比，prior 为 0 时无法比较，ok 为 false
+func Growth(cur, prior int64) (pct float64, ok bool) {
+	if prior == 0 {
+		return 0, false
+	}
+	return float64(cur-prior) * 100 / math.Abs(float64(prior)), true
+}
+
+func positive(n int64) int64 {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+//Span 就诊和收款中最早和最晚的时间，没有数据时 ok 为 false
+func Span(visits []Visit) (first, last time.Time, ok bool) {
+	see := func(t time.Time) {
+		if !ok || t.Before(first) {
+			first = t
+		}
+		if !ok || t.After(last) {
+			last = t
+		}
+		ok = true
+	}
+	for _, v := range visits {
+		see(v.At)
+		for _, pay := range v.Payments {
+			see(pay.At)
+		}
+	}
+	return first, last, ok
+}
+
+//Total 合并多个时间段：次数和金额相加，欠费余额取最后一段的
+func Total(buckets []Bucket) Bucket {
+	var t Bucket
+	for i, b := range buckets {
+		if i == 0 {
+			t.Start = b.Start
+		}
+		t.End = b.End
+		t.Visits += b.Visits
+		t.Billed += b.Billed
+		t.Collected += b.Collected
+		t.Outstanding = b.Outstanding
+	}
+	return t
+}
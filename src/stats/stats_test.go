@@ -0,0 +1,298 @@
+package stats
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustZone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+//at 在 loc 中的时间，格式为 2006-01-02 15:04
+func at(loc *time.Location, s string) time.Time {
+	v, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func TestFloor(t *testing.T) {
+	shanghai := mustZone(t, "Asia/Shanghai")
+	tests := []struct {
+		p    Period
+		t    time.Time
+		loc  *time.Location
+		want string
+	}{
+		{Day, at(time.UTC, "2024-03-05 23:59"), time.UTC, "2024-03-05"},
+		{Day, at(time.UTC, "2024-03-05 16:00"), shanghai, "2024-03-06"},
+		{Day, at(time.UTC, "2024-03-05 15:59"), shanghai, "2024-03-05"},
+		{Week, at(time.UTC, "2024-03-04 00:00"), time.UTC, "2024-03-04"},
+		{Week, at(time.UTC, "2024-03-10 23:00"), time.UTC, "2024-03-04"},
+		{Week, at(time.UTC, "2024-03-10 16:30"), shanghai, "2024-03-11"},
+		{Week, at(time.UTC, "2024-01-02 00:00"), time.UTC, "2024-01-01"},
+		{Week, at(time.UTC, "2023-01-01 00:00"), time.UTC, "2022-12-26"},
+		{Month, at(time.UTC, "2024-02-29 12:00"), time.UTC, "2024-02-01"},
+		{Month, at(time.UTC, "2024-01-31 16:00"), shanghai, "2024-02-01"},
+		{Quarter, at(time.UTC, "2024-03-31 00:00"), time.UTC, "2024-01-01"},
+		{Quarter, at(time.UTC, "2024-04-01 00:00"), time.UTC, "2024-04-01"},
+		{Quarter, at(time.UTC, "2024-12-31 00:00"), time.UTC, "2024-10-01"},
+		{Year, at(time.UTC, "2024-12-31 16:00"), shanghai, "2025-01-01"},
+		{Year, at(time.UTC, "2024-12-31 16:00"), time.UTC, "2024-01-01"},
+	}
+	for _, tt := range tests {
+		got := tt.p.Floor(tt.t, tt.loc)
+		if got.Format("2006-01-02") != tt.want || got.Location() != tt.loc || got.Hour() != 0 {
+			t.Errorf("%v.Floor(%v, %v) = %v, want %s", tt.p, tt.t, tt.loc, got, tt.want)
+		}
+	}
+}
+
+func TestNextAndLabel(t *testing.T) {
+	newYork := mustZone(t, "America/New_York")
+	tests := []struct {
+		p     Period
+		start time.Time
+		next  time.Time
+		label string
+	}{
+		{Day, at(time.UTC, "2024-02-28 00:00"), at(time.UTC, "2024-02-29 00:00"), "2024-02-28"},
+		//夏令时开始的一天只有 23 小时，结束的一天有 25 小时
+		{Day, at(newYork, "2024-03-10 00:00"), at(newYork, "2024-03-11 00:00"), "2024-03-10"},
+		{Day, at(newYork, "2024-11-03 00:00"), at(newYork, "2024-11-04 00:00"), "2024-11-03"},
+		{Week, at(time.UTC, "2024-12-30 00:00"), at(time.UTC, "2025-01-06 00:00"), "2025-W01"},
+		{Week, at(time.UTC, "2024-03-04 00:00"), at(time.UTC, "2024-03-11 00:00"), "2024-W10"},
+		{Month, at(time.UTC, "2024-01-01 00:00"), at(time.UTC, "2024-02-01 00:00"), "2024-01"},
+		{Month, at(time.UTC, "2024-12-01 00:00"), at(time.UTC, "2025-01-01 00:00"), "2024-12"},
+		{Quarter, at(time.UTC, "2024-10-01 00:00"), at(time.UTC, "2025-01-01 00:00"), "2024Q4"},
+		{Quarter, at(time.UTC, "2024-04-01 00:00"), at(time.UTC, "2024-07-01 00:00"), "2024Q2"},
+		{Year, at(time.UTC, "2024-01-01 00:00"), at(time.UTC, "2025-01-01 00:00"), "2024"},
+	}
+	for _, tt := range tests {
+		if got := tt.p.Next(tt.start); !got.Equal(tt.next) {
+			t.Errorf("%v.Next(%v) = %v, want %v", tt.p, tt.start, got, tt.next)
+		}
+		if got := tt.p.Label(tt.start); got != tt.label {
+			t.Errorf("%v.Label(%v) = %s, want %s", tt.p, tt.start, got, tt.label)
+		}
+	}
+}
+
+//row 一个时间段的期望值
+type row struct {
+	label                          string
+	visits                         int
+	billed, collected, outstanding int64
+}
+
+func TestSeries(t *testing.T) {
+	utc := time.UTC
+	shanghai := mustZone(t, "Asia/Shanghai")
+	visits := []Visit{
+		//1 月登记，1 月付一部分，3 月付清
+		{At: at(utc, "2024-01-10 09:00"), Billed: 10000, Payments: []Payment{
+			{At: at(utc, "2024-03-02 10:00"), Amount: 6000},
+			{At: at(utc, "2024-01-10 09:30"), Amount: 4000},
+		}},
+		//3 月登记，多付的部分不抵扣其它就诊的欠费
+		{At: at(utc, "2024-03-20 15:00"), Billed: 5000, Payments: []Payment{
+			{At: at(utc, "2024-03-20 15:10"), Amount: 8000},
+		}},
+		//去年登记一直没付，算在期初的欠费里
+		{At: at(utc, "2023-12-31 20:00"), Billed: 3000},
+		//预付：收款早于登记，欠费按登记时计算
+		{At: at(utc, "2024-04-15 08:00"), Billed: 2000, Payments: []Payment{
+			{At: at(utc, "2024-04-01 08:00"), Amount: 500},
+		}},
+	}
+	tests := []struct {
+		name     string
+		p        Period
+		from, to time.Time
+		loc      *time.Location
+		want     []row
+	}{
+		{"months", Month, at(utc, "2024-01-15 00:00"), at(utc, "2024-05-01 00:00"), utc, []row{
+			{"2024-01", 1, 10000, 4000, 9000},
+			{"2024-02", 0, 0, 0, 9000},
+			{"2024-03", 1, 5000, 14000, 3000},
+			{"2024-04", 1, 2000, 500, 4500},
+		}},
+		{"quarters", Quarter, at(utc, "2024-01-01 00:00"), at(utc, "2024-07-01 00:00"), utc, []row{
+			{"2024Q1", 2, 15000, 18000, 3000},
+			{"2024Q2", 1, 2000, 500, 4500},
+		}},
+		{"years", Year, at(utc, "2023-06-01 00:00"), at(utc, "2024-06-01 00:00"), utc, []row{
+			{"2023", 1, 3000, 0, 3000},
+			{"2024", 3, 17000, 18500, 4500},
+		}},
+		//上海时区下，12-31 20:00 UTC 已是 2024 年 1 月 1 日
+		{"shanghai", Month, at(shanghai, "2024-01-01 00:00"), at(shanghai, "2024-02-01 00:00"), shanghai, []row{
+			{"2024-01", 2, 13000, 4000, 9000},
+		}},
+		{"days", Day, at(utc, "2024-03-01 12:00"), at(utc, "2024-03-03 00:00"), utc, []row{
+			{"2024-03-01", 0, 0, 0, 9000},
+			{"2024-03-02", 0, 0, 6000, 3000},
+		}},
+		{"weeks", Week, at(utc, "2024-03-18 00:00"), at(utc, "2024-04-01 00:00"), utc, []row{
+			{"2024-W12", 1, 5000, 8000, 3000},
+			{"2024-W13", 0, 0, 0, 3000},
+		}},
+		{"empty range", Month, at(utc, "2024-03-01 00:00"), at(utc, "2024-03-01 00:00"), utc, nil},
+	}
+	for _, tt := range tests {
+		got := Series(visits, tt.p, tt.from, tt.to, tt.loc)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d buckets, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i, b := range got {
+			w := tt.want[i]
+			g := row{tt.p.Label(b.Start), b.Visits, b.Billed, b.Collected, b.Outstanding}
+			if g != w {
+				t.Errorf("%s: bucket %d = %+v, want %+v", tt.name, i, g, w)
+			}
+			if i > 0 && !got[i-1].End.Equal(b.Start) {
+				t.Errorf("%s: bucket %d starts at %v, previous ends at %v", tt.name, i, b.Start, got[i-1].End)
+			}
+		}
+	}
+}
+
+func TestSpanAndTotal(t *testing.T) {
+	utc := time.UTC
+	if _, _, ok := Span(nil); ok {
+		t.Errorf("Span(nil) ok = true")
+	}
+	visits := []Visit{
+		{At: at(utc, "2024-02-01 00:00"), Payments: []Payment{{At: at(utc, "2024-05-01 00:00")}}},
+		{At: at(utc, "2023-11-01 00:00")},
+	}
+	first, last, ok := Span(visits)
+	if !ok || !first.Equal(at(utc, "2023-11-01 00:00")) || !last.Equal(at(utc, "2024-05-01 00:00")) {
+		t.Errorf("Span = %v, %v, %v", first, last, ok)
+	}
+
+	tests := []struct {
+		buckets []Bucket
+		want    row
+	}{
+		{nil, row{}},
+		{[]Bucket{{Visits: 1, Billed: 100, Collected: 50, Outstanding: 50}}, row{"", 1, 100, 50, 50}},
+		{[]Bucket{
+			{Visits: 1, Billed: 100, Collected: 50, Outstanding: 50},
+			{Visits: 2, Billed: 300, Collected: 400, Outstanding: 0},
+		}, row{"", 3, 400, 450, 0}},
+	}
+	for i, tt := range tests {
+		b := Total(tt.buckets)
+		if got := (row{"", b.Visits, b.Billed, b.Collected, b.Outstanding}); got != tt.want {
+			t.Errorf("Total #%d = %+v, want %+v", i, got, tt.want)
+		}
+	}
+}
+
+func TestSum(t *testing.T) {
+	utc := time.UTC
+	visits := []Visit{
+		{At: at(utc, "2024-01-10 09:00"), Billed: 1000, Payments: []Payment{
+			{At: at(utc, "2024-01-10 09:30"), Amount: 300},
+			{At: at(utc, "2024-02-20 10:00"), Amount: 700},
+		}},
+		{At: at(utc, "2024-02-15 09:00"), Billed: 500},
+		{At: at(utc, "2024-03-01 00:00"), Billed: 200},
+	}
+	tests := []struct {
+		from, to string
+		want     row
+	}{
+		{"2024-01-01 00:00", "2024-02-01 00:00", row{"", 1, 1000, 300, 700}},
+		{"2024-02-10 12:00", "2024-03-01 00:00", row{"", 1, 500, 700, 500}},
+		{"2024-01-01 00:00", "2024-03-01 00:01", row{"", 3, 1700, 1000, 700}},
+		{"2023-01-01 00:00", "2023-12-31 00:00", row{}},
+	}
+	for _, tt := range tests {
+		b := Sum(visits, at(utc, tt.from), at(utc, tt.to))
+		if got := (row{"", b.Visits, b.Billed, b.Collected, b.Outstanding}); got != tt.want {
+			t.Errorf("Sum(%s, %s) = %+v, want %+v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	utc := time.UTC
+	visits := []Visit{
+		{At: at(utc, "2023-02-10 09:00"), Billed: 100, Payments: []Payment{{At: at(utc, "2023-02-10 09:00"), Amount: 100}}},
+		{At: at(utc, "2023-03-01 09:00"), Billed: 200, Payments: []Payment{{At: at(utc, "2023-03-01 09:00"), Amount: 200}}},
+		{At: at(utc, "2024-01-05 09:00"), Billed: 300, Payments: []Payment{{At: at(utc, "2024-01-05 09:00"), Amount: 300}}},
+		{At: at(utc, "2024-02-29 09:00"), Billed: 400, Payments: []Payment{{At: at(utc, "2024-02-29 09:00"), Amount: 400}}},
+	}
+	tests := []struct {
+		name     string
+		p        Period
+		from, to time.Time
+		yearAgo  []int64
+		previous []int64
+	}{
+		{"months", Month, at(utc, "2024-02-01 00:00"), at(utc, "2024-04-01 00:00"), []int64{100, 200}, []int64{300, 400}},
+		{"leap day", Day, at(utc, "2024-02-29 00:00"), at(utc, "2024-03-02 00:00"), []int64{0, 200}, []int64{0, 400}},
+		{"quarters", Quarter, at(utc, "2024-01-01 00:00"), at(utc, "2024-04-01 00:00"), []int64{300}, []int64{0}},
+		{"years", Year, at(utc, "2024-01-01 00:00"), at(utc, "2025-01-01 00:00"), []int64{300}, []int64{300}},
+		{"empty", Month, at(utc, "2024-01-01 00:00"), at(utc, "2024-01-01 00:00"), nil, nil},
+	}
+	collected := func(buckets []Bucket) []int64 {
+		var v []int64
+		for _, b := range buckets {
+			v = append(v, b.Collected)
+		}
+		return v
+	}
+	equal := func(a, b []int64) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+	for _, tt := range tests {
+		buckets := Series(visits, tt.p, tt.from, tt.to, utc)
+		if got := collected(YearAgo(visits, buckets)); !equal(got, tt.yearAgo) {
+			t.Errorf("%s: YearAgo = %v, want %v", tt.name, got, tt.yearAgo)
+		}
+		if got := collected(Previous(visits, buckets, tt.p)); !equal(got, tt.previous) {
+			t.Errorf("%s: Previous = %v, want %v", tt.name, got, tt.previous)
+		}
+	}
+}
+
+func TestGrowth(t *testing.T) {
+	tests := []struct {
+		cur, prior int64
+		pct        float64
+		ok         bool
+	}{
+		{150, 100, 50, true},
+		{50, 100, -50, true},
+		{0, 100, -100, true},
+		{100, 0, 0, false},
+		{-50, -100, 50, true},
+	}
+	for _, tt := range tests {
+		pct, ok := Growth(tt.cur, tt.prior)
+		if pct != tt.pct || ok != tt.ok {
+			t.Errorf("Growth(%d, %d) = %v, %v, want %v, %v", tt.cur, tt.prior, pct, ok, tt.pct, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/gaoyangtok/medic/src/stats"
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+//chartColors 各组数据的颜色，前三种依次为本期、去年同期、上一时间段
+var chartColors = []walk.Color{
+	walk.RGB(65, 105, 225), walk.RGB(255, 127, 36), walk.RGB(34, 139, 34), walk.RGB(220, 20, 60),
+	walk.RGB(148, 0, 211), walk.RGB(0, 139, 139), walk.RGB(139, 69, 19), walk.RGB(255, 20, 147),
+}
+
+//图表四周留给刻度、标签和图例的宽度
+const (
+	chartLeft   = 80
+	chartRight  = 20
+	chartTop    = 30
+	chartBottom = 30
+	legendWidth = 100
+)
+
+//statsWindow 收入统计窗口：选择日期范围、时间段、金额和图形，可以叠加同比、环比
+type statsWindow struct {
+	*walk.MainWindow
+	chart        *walk.CustomWidget
+	fromDE, toDE *walk.DateEdit
+	periodCB     *walk.ComboBox
+	metricCB     *walk.ComboBox
+	styleCB      *walk.ComboBox
+	yearAgoCB    *walk.CheckBox
+	previousCB   *walk.CheckBox
+	summaryLabel *walk.Label
+	options      chartOptions
+	series       []chartSeries
+	err          error
+}
+
+//OpenStatic 打开收入统计窗口，默认按月显示最近 12 个月；主表的数据保存后自动重画
+func OpenStatic() {
+	sw := new(statsWindow)
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month()-11, 1, 0, 0, 0, 0, time.Local)
+
+	if err := (MainWindow{
+		AssignTo: &sw.MainWindow,
+		Title:    "收入统计",
+		MinSize:  Size{Width: 600, Height: 350},
+		Size:     Size{Width: 900, Height: 500},
+		Layout:   VBox{},
+		Children: []Widget{
+			Composite{
+				Layout: HBox{MarginsZero: true},
+				Children: []Widget{
+					Label{Text: "日期:"},
+					DateEdit{
+						AssignTo:      &sw.fromDE,
+						Date:          from,
+						OnDateChanged: sw.refresh,
+					},
+					Label{Text: "-"},
+					DateEdit{
+						AssignTo:      &sw.toDE,
+						Date:          now,
+						OnDateChanged: sw.refresh,
+					},
+					ComboBox{
+						AssignTo:              &sw.periodCB,
+						Model:                 chartPeriodTitles(),
+						CurrentIndex:          2,
+						OnCurrentIndexChanged: sw.refresh,
+					},
+					ComboBox{
+						AssignTo:              &sw.metricCB,
+						Model:                 chartMetricTitles(),
+						CurrentIndex:          0,
+						OnCurrentIndexChanged: sw.refresh,
+					},
+					ComboBox{
+						AssignTo:              &sw.styleCB,
+						Model:                 []string{"柱状图", "折线图"},
+						CurrentIndex:          0,
+						OnCurrentIndexChanged: sw.refresh,
+					},
+					CheckBox{
+						AssignTo:         &sw.yearAgoCB,
+						Text:             "同比",
+						ToolTipText:      "与去年同期比较",
+						OnCheckedChanged: sw.refresh,
+					},
+					CheckBox{
+						AssignTo:         &sw.previousCB,
+						Text:             "环比",
+						ToolTipText:      "与上一个时间段比较",
+						OnCheckedChanged: sw.refresh,
+					},
+					HSpacer{},
+					PushButton{
+						Text:        "年龄性别",
+						ToolTipText: "按年龄段和性别统计病人数、就诊次数和收入",
+						OnClicked: func() {
+							if _, err := DemographicsDialog(sw, sw.options); err != nil {
+								walk.MsgBox(sw, "错误", err.Error(), walk.MsgBoxIconError)
+							}
+						},
+					},
+					PushButton{
+						Text:        "诊断/方案分析",
+						ToolTipText: "按病例诊断或治疗方案分类的收入排行和走势",
+						OnClicked: func() {
+							if sw.err != nil {
+								walk.MsgBox(sw, "提示", sw.err.Error(), walk.MsgBoxIconInformation)
+								return
+							}
+							if _, err := BreakdownDialog(sw, sw.options); err != nil {
+								walk.MsgBox(sw, "错误", err.Error(), walk.MsgBoxIconError)
+							}
+						},
+					},
+				},
+			},
+			CustomWidget{
+				AssignTo:            &sw.chart,
+				ClearsBackground:    true,
+				InvalidatesOnResize: true,
+				Paint:               sw.paint,
+			},
+			Label{
+				AssignTo: &sw.summaryLabel,
+				Font:     labelFont,
+			},
+		},
+	}).Create(); err != nil {
+		log.Fatal(err)
+	}
+	cancel := model.watchers.watch(func() {
+		sw.Synchronize(sw.refresh)
+	})
+	defer cancel()
+	sw.refresh()
+	sw.Run()
+}
+
+//refresh 按当前选项重新计算并重画；控件在创建过程中触发时还没有全部就绪，跳过
+func (sw *statsWindow) refresh() {
+	if sw.chart == nil || sw.summaryLabel == nil || sw.previousCB == nil {
+		return
+	}
+	sw.options = chartOptions{
+		From:     sw.fromDE.Date(),
+		To:       sw.toDE.Date(),
+		Period:   chartPeriods[sw.periodCB.CurrentIndex()],
+		Metric:   chartMetrics[sw.metricCB.CurrentIndex()],
+		YearAgo:  sw.yearAgoCB.Checked(),
+		Previous: sw.previousCB.Checked(),
+	}
+	rwLock.RLock()
+	sw.series, sw.err = model.chartData(sw.options)
+	rwLock.RUnlock()
+	if sw.err != nil {
+		_ = sw.summaryLabel.SetText(sw.err.Error())
+	} else {
+		_ = sw.summaryLabel.SetText(chartSummary(sw.series, sw.options))
+	}
+	_ = sw.chart.Invalidate()
+}
+
+func (sw *statsWindow) paint(canvas *walk.Canvas, updateBounds walk.Rectangle) error {
+	if sw.err != nil {
+		return nil
+	}
+	return paintChart(canvas, sw.chart.ClientBounds(), sw.series, sw.options.Metric, sw.options.Period, sw.styleCB.CurrentIndex() == 1)
+}
+
+//paintChart 在 bounds 中画统计图，各组数据的时间段相同；line 为折线图，否则为柱状图
+func paintChart(canvas *walk.Canvas, bounds walk.Rectangle, series []chartSeries, metric chartMetric, period stats.Period, line bool) error {
+	plot := walk.Rectangle{
+		X:      chartLeft,
+		Y:      chartTop,
+		Width:  bounds.Width - chartLeft - chartRight,
+		Height: bounds.Height - chartTop - chartBottom,
+	}
+	if plot.Width < 50 || plot.Height < 50 || len(series) == 0 || len(series[0].Buckets) == 0 {
+		return nil
+	}
+
+	var max int64
+	values := make([][]int64, len(series))
+	for i, s := range series {
+		values[i] = s.values(metric)
+		for _, v := range values[i] {
+			if v > max {
+				max = v
+			}
+		}
+	}
+	unit := int64(Yuan)
+	if metric.Count {
+		unit = 1
+	}
+	top := axisTop(max, unit)
+	//y 数值 v 的纵坐标，负数画在横轴上
+	y := func(v int64) int {
+		if v < 0 {
+			v = 0
+		}
+		return plot.Y + plot.Height - int(v*int64(plot.Height)/top)
+	}
+
+	if err := drawAxis(canvas, plot, top, unit); err != nil {
+		return err
+	}
+	slot := float64(plot.Width) / float64(len(series[0].Buckets))
+	if err := drawLabels(canvas, plot, slot, series[0].Buckets, period); err != nil {
+		return err
+	}
+
+	for i, s := range series {
+		color := chartColors[s.Kind%len(chartColors)]
+		var err error
+		if line {
+			err = drawLine(canvas, values[i], color, plot, slot, y)
+		} else {
+			width := math.Max(1, slot*0.7/float64(len(series)))
+			err = drawBars(canvas, values[i], color, plot, slot, slot*0.15+width*float64(i), width, y)
+		}
+		if err != nil {
+			return err
+		}
+		if err := drawLegend(canvas, s.Title, color, plot.X+i*legendWidth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//drawAxis 纵轴刻度和网格线，分为 4 格，刻度以 unit 为单位
+func drawAxis(canvas *walk.Canvas, plot walk.Rectangle, top, unit int64) error {
+	grid, err := walk.NewCosmeticPen(walk.PenDot, walk.RGB(200, 200, 200))
+	if err != nil {
+		return err
+	}
+	defer grid.Dispose()
+	axis, err := walk.NewCosmeticPen(walk.PenSolid, walk.RGB(0, 0, 0))
+	if err != nil {
+		return err
+	}
+	defer axis.Dispose()
+
+	for i := 0; i <= 4; i++ {
+		v := top * int64(i) / 4
+		yy := plot.Y + plot.Height - plot.Height*i/4
+		pen := walk.Pen(grid)
+		if i == 0 {
+			pen = axis
+		}
+		if err := canvas.DrawLine(pen, walk.Point{X: plot.X, Y: yy}, walk.Point{X: plot.X + plot.Width, Y: yy}); err != nil {
+			return err
+		}
+		text := fmt.Sprint(v / unit)
+		if err := canvas.DrawText(text, font, walk.RGB(0, 0, 0), walk.Rectangle{
+			X: 0, Y: yy - 8, Width: plot.X - 6, Height: 16,
+		}, walk.TextRight|walk.TextSingleLine|walk.TextVCenter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//drawLabels 横轴上时间段的名称，太密时隔几个显示一个
+func drawLabels(canvas *walk.Canvas, plot walk.Rectangle, slot float64, buckets []stats.Bucket, period stats.Period) error {
+	const labelWidth = 80
+	step := int(math.Ceil(labelWidth / slot))
+	for i, b := range buckets {
+		if i%step != 0 {
+			continue
+		}
+		x := plot.X + int(slot*(float64(i)+0.5))
+		if err := canvas.DrawText(period.Label(b.Start), font, walk.RGB(0, 0, 0), walk.Rectangle{
+			X: x - labelWidth/2, Y: plot.Y + plot.Height + 4, Width: labelWidth, Height: 16,
+		}, walk.TextCenter|walk.TextSingleLine); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//drawBars 一组柱子，offset 为柱子在每个时间段内的左边距
+func drawBars(canvas *walk.Canvas, values []int64, color walk.Color, plot walk.Rectangle, slot, offset, width float64, y func(int64) int) error {
+	brush, err := walk.NewSolidColorBrush(color)
+	if err != nil {
+		return err
+	}
+	defer brush.Dispose()
+	for i, v := range values {
+		top := y(v)
+		if err := canvas.FillRectangle(brush, walk.Rectangle{
+			X:      plot.X + int(slot*float64(i)+offset),
+			Y:      top,
+			Width:  int(math.Max(1, width)),
+			Height: plot.Y + plot.Height - top,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//drawLine 一条折线，点在每个时间段的中间
+func drawLine(canvas *walk.Canvas, values []int64, color walk.Color, plot walk.Rectangle, slot float64, y func(int64) int) error {
+	brush, err := walk.NewSolidColorBrush(color)
+	if err != nil {
+		return err
+	}
+	defer brush.Dispose()
+	pen, err := walk.NewGeometricPen(walk.PenSolid|walk.PenCapRound|walk.PenJoinRound, 2, brush)
+	if err != nil {
+		return err
+	}
+	defer pen.Dispose()
+
+	points := make([]walk.Point, len(values))
+	for i, v := range values {
+		points[i] = walk.Point{X: plot.X + int(slot*(float64(i)+0.5)), Y: y(v)}
+	}
+	if len(points) > 1 {
+		if err := canvas.DrawPolyline(pen, points); err != nil {
+			return err
+		}
+	}
+	for _, p := range points {
+		if err := canvas.FillEllipse(brush, walk.Rectangle{X: p.X - 3, Y: p.Y - 3, Width: 6, Height: 6}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//drawLegend 图上方的图例
+func drawLegend(canvas *walk.Canvas, title string, color walk.Color, x int) error {
+	brush, err := walk.NewSolidColorBrush(color)
+	if err != nil {
+		return err
+	}
+	defer brush.Dispose()
+	if err := canvas.FillRectangle(brush, walk.Rectangle{X: x, Y: 9, Width: 12, Height: 12}); err != nil {
+		return err
+	}
+	return canvas.DrawText(title, font, walk.RGB(0, 0, 0), walk.Rectangle{
+		X: x + 16, Y: 5, Width: legendWidth - 20, Height: 20,
+	}, walk.TextLeft|walk.TextSingleLine|walk.TextVCenter)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//Dataset 从存储中读出的全部数据
+type Dataset struct {
+	Visits      []*Foo
+	Patients    []*Patient
+	Payments    []*Payment
+	Changes     []*Change
+	Merges      []*Merge
+	Closings    []*Closing
+	Adjustments []*Adjustment
+}
+
+//VisitQuery Store.Query 的条件，零值的条件不限
+type VisitQuery struct {
+	//Start、End 登记时间在两者之间（不含两端），同主界面的日期范围
+	Start, End time.Time
+	//Name 姓名中包含的文字
+	Name string
+	//Phone 电话规范形式的开头，见 normalizePhone
+	Phone string
+	//Deleted 为 true 时也查回收站中的记录
+	Deleted bool
+}
+
+//match 记录是否满足条件，供不能下推条件的存储逐条过滤
+func (q *VisitQuery) match(foo *Foo) bool {
+	return (q.Deleted || !foo.Deleted) &&
+		(q.Start.IsZero() || foo.Create.After(q.Start)) &&
+		(q.End.IsZero() || foo.Create.Before(q.End)) &&
+		strings.Contains(foo.Name, q.Name) &&
+		strings.HasPrefix(foo.PhoneNorm, q.Phone)
+}
+
+//Store 就诊记录的存储，调用方负责加锁
+type Store interface {
+	//Load 读取全部数据（含已删除的记录），无法解析的字段逐行报告
+	Load() (*Dataset, []RowError, error)
+	//Insert 新增一条记录，没有编号时为其分配编号
+	Insert(foo *Foo) error
+	//Update 保存对一条已有记录的修改
+	Update(foo *Foo) error
+	//Delete 软删除，标记 Deleted 并记下删除时间，记录进入回收站
+	Delete(foo *Foo) error
+	//Purge 从回收站彻底删除一条记录及其收款，不能恢复
+	Purge(foo *Foo) error
+	//Query 返回满足条件的记录，按登记时间排列
+	Query(q VisitQuery) ([]*Foo, error)
+	//InsertPatient 新增病人档案，没有编号时为其分配编号
+	InsertPatient(p *Patient) error
+	//UpdatePatient 保存对病人档案的修改
+	UpdatePatient(p *Patient) error
+	//InsertPayment 新增一笔收款，没有编号时为其分配编号
+	InsertPayment(p *Payment) error
+	//UpdatePayment 保存对收款的修改（作废）
+	UpdatePayment(p *Payment) error
+	//InsertChange 追加一条修改记录，修改记录不能更改
+	InsertChange(c *Change) error
+	//InsertMerge 记下一次病人档案的合并
+	InsertMerge(g *Merge) error
+	//UpdateMerge 保存对合并记录的修改（撤销）
+	UpdateMerge(g *Merge) error
+	//InsertClosing 记下一天的日结，日结不能撤销
+	InsertClosing(c *Closing) error
+	//InsertAdjustment 追加一条对已日结日期的调整
+	InsertAdjustment(a *Adjustment) error
+	//Transact 在一个事务中执行 fn，fn 只能通过传入的 store 写入；
+	//fn 返回错误或写入失败时，其中的修改都不保存
+	Transact(fn func(store Store) error) error
+	Close() error
+}
+
+const (
+	storeCSV    = "csv"
+	storeSQLite = "sqlite"
+)
+
+//OpenStore 按类型打开存储
+func OpenStore(kind, path string) (Store, error) {
+	switch kind {
+	case storeCSV:
+		return NewCSVStore(path), nil
+	case storeSQLite:
+		return OpenSQLStore(path)
+	}
+	return nil, fmt.Errorf("未知的存储类型: %s", kind)
+}
+
+//siblingPath 与数据文件放在同一目录下的其它文件
+func siblingPath(path, name string) string {
+	return filepath.Join(filepath.Dir(path), name)
+}
+
+//Migrate 把 src 中的全部数据一次性导入 dst，返回导入的记录条数
+func Migrate(src Store, dst *SQLStore) (int, error) {
+	var n int
+	if err := dst.db.QueryRow(`SELECT COUNT(*) FROM visits`).Scan(&n); err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		return 0, fmt.Errorf("数据库中已有 %d 条记录，不能重复导入", n)
+	}
+
+	defer src.Close()
+	ds, errs, err := src.Load()
+	if err != nil {
+		return 0, err
+	}
+	if len(errs) > 0 {
+		return 0, fmt.Errorf("源数据有 %d 处无法识别，请先修正: %v", len(errs), errs[0])
+	}
+	if err := dst.insertAll(ds); err != nil {
+		return 0, err
+	}
+	return len(ds.Visits), nil
+}
+
+//migrateCSV 把 CSV 文件导入新的 SQLite 数据库
+func migrateCSV(csvPath, dbPath string) (int, error) {
+	dst, err := OpenSQLStore(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+	return Migrate(NewCSVStore(csvPath), dst)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/gaoyangtok/medic/src/fulltext"
+)
+
+//textFields 全文索引的字段，顺序与 reindex 中的 Put 一致，诊断最重要
+var textFields = []fulltext.Field{
+	{Name: "病理诊断", Weight: 3},
+	{Name: "治疗方案", Weight: 2},
+	{Name: "住址", Weight: 1},
+}
+
+//TextHit 全文检索的一条结果，摘要中命中的词用【】标出
+type TextHit struct {
+	*Foo
+	Score          float64
+	DiagSnippet    string
+	ProgramSnippet string
+	AddressSnippet string
+}
+
+//buildIndex 为未删除的记录建立全文索引
+func (m *FooModel) buildIndex() {
+	for _, item := range m.items {
+		m.reindex(item)
+	}
+}
+
+//reindex 在记录保存、删除、恢复之后更新它在索引中的内容，需持有写锁
+func (m *FooModel) reindex(foo *Foo) {
+	if foo.Deleted {
+		m.index.Remove(foo.ID)
+		return
+	}
+	m.index.Put(foo.ID, foo.Diagnosed, foo.Program, foo.Address)
+}
+
+//fullText 按相关度返回最多 limit 条匹配的记录，需持有读锁
+func (m *FooModel) fullText(q string, limit int) []*TextHit {
+	byID := map[string]*Foo{}
+	for _, item := range m.items {
+		byID[item.ID] = item
+	}
+	var hits []*TextHit
+	for _, h := range m.index.Search(q, limit) {
+		foo, ok := byID[h.ID]
+		if !ok {
+			continue
+		}
+		hits = append(hits, &TextHit{
+			Foo:            foo,
+			Score:          h.Score,
+			DiagSnippet:    h.Snippets[0],
+			ProgramSnippet: h.Snippets[1],
+			AddressSnippet: h.Snippets[2],
+		})
+	}
+	return hits
+}
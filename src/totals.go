@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+)
+
+//visitTotals 一组就诊记录的合计
+type visitTotals struct {
+	Count int
+	//Billed 应收，即实收费用之和
+	Billed    Money
+	Collected Money
+	//Outstanding 欠费，多付的不抵扣其它记录的欠费
+	Outstanding Money
+}
+
+func sumVisits(items []*Foo) visitTotals {
+	var t visitTotals
+	for _, item := range items {
+		t.Count++
+		t.Billed += item.RealFee
+		t.Collected += item.PaidFee
+		if item.RealFee > item.PaidFee {
+			t.Outstanding += item.RealFee - item.PaidFee
+		}
+	}
+	return t
+}
+
+//Average 平均每次的应收，四舍五入到分
+func (t visitTotals) Average() Money {
+	if t.Count == 0 {
+		return 0
+	}
+	n := Money(t.Count)
+	if t.Billed < 0 {
+		return (t.Billed - n/2) / n
+	}
+	return (t.Billed + n/2) / n
+}
+
+func (t visitTotals) String() string {
+	return fmt.Sprintf("当前结果 %d 条：应收 %s 元，已收 %s 元，欠费 %s 元，平均 %s 元",
+		t.Count, t.Billed, t.Collected, t.Outstanding, t.Average())
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+//TrashDialog 回收站，列出已删除的记录，可以恢复或彻底删除选中的记录
+func TrashDialog(owner walk.Form) (int, error) {
+	var dlg *walk.Dialog
+	var tv *walk.TableView
+	rwLock.RLock()
+	trash := model.trash()
+	rwLock.RUnlock()
+
+	//apply 对选中的记录执行 fn 并刷新列表
+	apply := func(fn func(store Store, foo *Foo) error) {
+		var selected []*Foo
+		for _, index := range tv.SelectedIndexes() {
+			selected = append(selected, trash[index])
+		}
+		err := model.commit(func(store Store) error {
+			for _, foo := range selected {
+				if err := fn(store, foo); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			walk.MsgBox(dlg, "错误", "保存失败："+err.Error(), walk.MsgBoxIconError)
+		}
+		rwLock.RLock()
+		trash = model.trash()
+		rwLock.RUnlock()
+		_ = tv.SetModel(trash)
+	}
+
+	title := "回收站"
+	if retainDays > 0 {
+		title = fmt.Sprintf("回收站（保留 %d 天）", retainDays)
+	}
+	return Dialog{
+		AssignTo: &dlg,
+		Title:    title,
+		MinSize:  Size{Width: 800, Height: 400},
+		Layout:   VBox{},
+		Children: []Widget{
+			TableView{
+				AssignTo:       &tv,
+				MultiSelection: true,
+				Columns: []TableViewColumn{
+					{Name: "DeletedAt", Title: "删除时间", Format: "2006-01-02 15:04", Width: 130},
+					{Name: "Name", Title: "姓名", Width: 60},
+					{Name: "Phone", Title: "电话", Width: 100},
+					{Name: "Create", Title: "登记时间", Format: "2006-01-02", Width: 100},
+					{Name: "Diagnosed", Title: "病理诊断", Width: 180},
+					{Name: "RealFee", Title: "实收", Alignment: AlignFar, Width: 70},
+					{Name: "PaidFee", Title: "已付", Alignment: AlignFar, Width: 70},
+				},
+				Model: trash,
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						Text: "恢复",
+						OnClicked: func() {
+							//登记在已日结日期的记录要说明恢复的原因
+							var locked int
+							rwLock.RLock()
+							for _, index := range tv.SelectedIndexes() {
+								if model.closingOf(trash[index].Create) != nil {
+									locked++
+								}
+							}
+							rwLock.RUnlock()
+							var reason string
+							if locked > 0 {
+								var ok bool
+								if reason, ok = adjustReason(dlg, fmt.Sprintf("选中的记录中有 %d 条登记在已日结的日期，恢复将记为调整。", locked)); !ok {
+									return
+								}
+							}
+							apply(func(store Store, foo *Foo) error {
+								before := *foo
+								if err := model.restore(store, foo); err != nil {
+									return err
+								}
+								if c := model.closingOf(foo.Create); c != nil {
+									return model.adjust(store, c, adjustRestore, reason, &before, foo)
+								}
+								return nil
+							})
+						},
+					},
+					PushButton{
+						Text: "彻底删除",
+						OnClicked: func() {
+							if len(tv.SelectedIndexes()) == 0 {
+								return
+							}
+							//涉及已日结日期的记录留在回收站中，不彻底删除
+							var locked int
+							rwLock.RLock()
+							for _, index := range tv.SelectedIndexes() {
+								if model.lockedBy(trash[index]) != nil {
+									locked++
+								}
+							}
+							rwLock.RUnlock()
+							n := len(tv.SelectedIndexes()) - locked
+							if n == 0 {
+								walk.MsgBox(dlg, "彻底删除", "选中的记录都涉及已日结的日期，不能彻底删除。", walk.MsgBoxIconInformation)
+								return
+							}
+							msg := fmt.Sprintf("彻底删除选中的 %d 条记录及其收款？删除后不能恢复。", n)
+							if locked > 0 {
+								msg += fmt.Sprintf("\n另有 %d 条涉及已日结的日期，将留在回收站中。", locked)
+							}
+							if walk.MsgBox(dlg, "彻底删除", msg, walk.MsgBoxYesNo|walk.MsgBoxIconWarning) != walk.DlgCmdYes {
+								return
+							}
+							apply(func(store Store, foo *Foo) error {
+								if model.lockedBy(foo) != nil {
+									return nil
+								}
+								return model.purge(store, foo)
+							})
+						},
+					},
+					PushButton{
+						Text:      "关闭",
+						OnClicked: func() { dlg.Accept() },
+					},
+				},
+			},
+		},
+	}.Run(owner)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"sync"
+)
+
+//watchers 数据保存后要通知的函数，如统计窗口的重画；可以在任何线程注册、注销和通知
+type watchers struct {
+	mu   sync.Mutex
+	next int
+	fns  map[int]func()
+}
+
+//watch 注册 fn，返回注销的函数。fn 在保存数据的线程中调用，需要时自行转到界面线程
+func (w *watchers) watch(fn func()) (cancel func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fns == nil {
+		w.fns = map[int]func(){}
+	}
+	id := w.next
+	w.next++
+	w.fns[id] = fn
+	return func() {
+		w.mu.Lock()
+		delete(w.fns, id)
+		w.mu.Unlock()
+	}
+}
+
+//notify 调用已注册的函数，在释放写锁之后调用
+func (w *watchers) notify() {
+	w.mu.Lock()
+	fns := make([]func(), 0, len(w.fns))
+	for _, fn := range w.fns {
+		fns = append(fns, fn)
+	}
+	w.mu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}